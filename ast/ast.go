@@ -0,0 +1,154 @@
+//Package ast is a typed, traversable representation of compiled XPath
+//expressions.  It is built from the parser's AST with FromNode, or from an
+//XPathExec with its AST method.
+package ast
+
+import "github.com/ChrisTrenkamp/goxpath/tree"
+
+//Node is implemented by every AST node.
+type Node interface {
+	astNode()
+}
+
+//Expr is implemented by the AST nodes that are expressions.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+//PathExpr is a location path, or a path that follows a primary expression.
+//If Start is nil, the path starts at the context node, or at the document
+//root if Absolute is true.  Abbreviated paths are expanded, so // becomes a
+//descendant-or-self::node() step, . becomes self::node(), .. becomes
+//parent::node() and @ becomes the attribute axis.
+type PathExpr struct {
+	Absolute bool
+	Start    Expr
+	Steps    []*Step
+}
+
+//Step is a step in a location path.
+type Step struct {
+	Axis       string
+	Test       NodeTest
+	Predicates []*Predicate
+}
+
+//NodeTest is the node test of a step.  Kind is set to the node type for node
+//type tests, such as text() or node(), and Target holds the literal of a
+//processing-instruction test.  Otherwise, it is a name test, and Local may be
+//the * wildcard.
+type NodeTest struct {
+	Prefix string
+	Local  string
+	Kind   string
+	Target string
+}
+
+//Predicate is a predicate of a step or a filter expression.
+type Predicate struct {
+	Expr Expr
+}
+
+//FilterExpr is a primary expression followed by predicates, such as $var[1].
+type FilterExpr struct {
+	Primary    Expr
+	Predicates []*Predicate
+}
+
+//FunctionCall is a call to a built-in or custom function.
+type FunctionCall struct {
+	Prefix string
+	Local  string
+	Args   []Expr
+}
+
+//BinaryOp is a binary operator, including the comma operator.
+type BinaryOp struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+//UnaryOp is the negation operator.
+type UnaryOp struct {
+	Op      string
+	Operand Expr
+}
+
+//Literal is a string or numeric literal.  Value is a tree.String or a
+//tree.Num.
+type Literal struct {
+	Value tree.Result
+}
+
+//VariableRef is a reference to a variable.
+type VariableRef struct {
+	Prefix string
+	Local  string
+}
+
+//EmptySequence is the empty sequence, ().
+type EmptySequence struct{}
+
+//Binding is a variable binding in a for, let, some or every expression.
+type Binding struct {
+	Var  VariableRef
+	Expr Expr
+}
+
+//ForExpr is a for expression.
+type ForExpr struct {
+	Bindings []*Binding
+	Return   Expr
+}
+
+//LetExpr is a let expression.
+type LetExpr struct {
+	Bindings []*Binding
+	Return   Expr
+}
+
+//QuantifiedExpr is a some or every expression.  Quantifier is either "some"
+//or "every".
+type QuantifiedExpr struct {
+	Quantifier string
+	Bindings   []*Binding
+	Satisfies  Expr
+}
+
+//IfExpr is an if expression.
+type IfExpr struct {
+	Cond Expr
+	Then Expr
+	Else Expr
+}
+
+func (*PathExpr) astNode()       {}
+func (*Step) astNode()           {}
+func (*Predicate) astNode()      {}
+func (*FilterExpr) astNode()     {}
+func (*FunctionCall) astNode()   {}
+func (*BinaryOp) astNode()       {}
+func (*UnaryOp) astNode()        {}
+func (*Literal) astNode()        {}
+func (*VariableRef) astNode()    {}
+func (*EmptySequence) astNode()  {}
+func (*Binding) astNode()        {}
+func (*ForExpr) astNode()        {}
+func (*LetExpr) astNode()        {}
+func (*QuantifiedExpr) astNode() {}
+func (*IfExpr) astNode()         {}
+
+func (*PathExpr) exprNode()       {}
+func (*FilterExpr) exprNode()     {}
+func (*FunctionCall) exprNode()   {}
+func (*BinaryOp) exprNode()       {}
+func (*UnaryOp) exprNode()        {}
+func (*Literal) exprNode()        {}
+func (*VariableRef) exprNode()    {}
+func (*EmptySequence) exprNode()  {}
+func (*ForExpr) exprNode()        {}
+func (*LetExpr) exprNode()        {}
+func (*QuantifiedExpr) exprNode() {}
+func (*IfExpr) exprNode()         {}
@@ -0,0 +1,178 @@
+package ast
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/ChrisTrenkamp/goxpath/lexer"
+	"github.com/ChrisTrenkamp/goxpath/parser"
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/xconst"
+)
+
+//FromNode converts the parser's AST to a typed AST.
+func FromNode(n *parser.Node) Expr {
+	if n == nil {
+		return &EmptySequence{}
+	}
+
+	switch n.Val.Typ {
+	case lexer.XItemAbsLocPath, lexer.XItemAbbrAbsLocPath, lexer.XItemRelLocPath, lexer.XItemAbbrRelLocPath:
+		ret := &PathExpr{}
+		ret.Absolute, ret.Steps = steps(n)
+		return ret
+	case lexer.XItemFunction:
+		ret := &FunctionCall{}
+		ret.Prefix, ret.Local = splitQName(n.Val.Val)
+		for arg := n.Left; arg != nil; arg = arg.Right {
+			ret.Args = append(ret.Args, FromNode(arg.Left))
+		}
+		return filter(ret, n.Right)
+	case parser.Empty:
+		if n.Left == nil {
+			return filter(&EmptySequence{}, n.Right)
+		}
+		return filter(FromNode(n.Left), n.Right)
+	case lexer.XItemOperator:
+		if n.Left == nil {
+			return &UnaryOp{Op: n.Val.Val, Operand: FromNode(n.Right)}
+		}
+		return &BinaryOp{Op: n.Val.Val, Left: FromNode(n.Left), Right: FromNode(n.Right)}
+	case lexer.XItemStrLit:
+		return &Literal{Value: tree.String(n.Val.Val)}
+	case lexer.XItemNumLit:
+		num, _ := strconv.ParseFloat(n.Val.Val, 64)
+		return &Literal{Value: tree.Num(num)}
+	case lexer.XItemVariable:
+		ret := &VariableRef{}
+		ret.Prefix, ret.Local = splitQName(n.Val.Val)
+		return ret
+	case lexer.XItemFor:
+		return &ForExpr{Bindings: bindings(n.Left), Return: FromNode(n.Right)}
+	case lexer.XItemLet:
+		return &LetExpr{Bindings: bindings(n.Left), Return: FromNode(n.Right)}
+	case lexer.XItemQuantifier:
+		return &QuantifiedExpr{Quantifier: n.Val.Val, Bindings: bindings(n.Left), Satisfies: FromNode(n.Right)}
+	case lexer.XItemIf:
+		return &IfExpr{Cond: FromNode(n.Left), Then: FromNode(n.Right.Left), Else: FromNode(n.Right.Right)}
+	}
+
+	return &EmptySequence{}
+}
+
+//filter adds the predicates and location path that follow a primary
+//expression.
+func filter(primary Expr, n *parser.Node) Expr {
+	var preds []*Predicate
+	for n != nil && n.Val.Typ == lexer.XItemPredicate {
+		preds = append(preds, &Predicate{Expr: FromNode(n.Left)})
+		n = n.Right
+	}
+
+	ret := primary
+	if len(preds) > 0 {
+		ret = &FilterExpr{Primary: primary, Predicates: preds}
+	}
+
+	if n == nil {
+		return ret
+	}
+
+	path := &PathExpr{Start: ret}
+	_, path.Steps = steps(n)
+	return path
+}
+
+//steps converts the steps of a location path.  Steps are chained on the left,
+//except predicates, which continue on the right.
+func steps(n *parser.Node) (bool, []*Step) {
+	abs := false
+	ret := []*Step{}
+	cur := &Step{}
+
+	finish := func() {
+		if cur.Axis == "" {
+			cur.Axis = xconst.AxisChild
+		}
+
+		if cur.Test.Prefix == "" && cur.Test.Kind == "" {
+			if cur.Test.Local == "." {
+				cur.Axis = xconst.AxisSelf
+				cur.Test = NodeTest{Kind: xconst.NodeTypeNode}
+			} else if cur.Test.Local == ".." {
+				cur.Axis = xconst.AxisParent
+				cur.Test = NodeTest{Kind: xconst.NodeTypeNode}
+			}
+		}
+
+		ret = append(ret, cur)
+		cur = &Step{}
+	}
+
+	abbr := func() {
+		ret = append(ret, &Step{Axis: xconst.AxisDescendentOrSelf, Test: NodeTest{Kind: xconst.NodeTypeNode}})
+	}
+
+	for n != nil {
+		next := n.Left
+
+		switch n.Val.Typ {
+		case lexer.XItemAbsLocPath:
+			abs = true
+		case lexer.XItemAbbrAbsLocPath:
+			abs = true
+			abbr()
+		case lexer.XItemAbbrRelLocPath:
+			abbr()
+		case lexer.XItemAxis:
+			cur.Axis = n.Val.Val
+		case lexer.XItemAbbrAxis:
+			cur.Axis = xconst.AxisAttribute
+		case lexer.XItemNCName:
+			cur.Test.Prefix = n.Val.Val
+		case lexer.XItemQName:
+			cur.Test.Local = n.Val.Val
+			finish()
+		case lexer.XItemNodeType:
+			cur.Test.Kind = n.Val.Val
+			if next != nil && next.Val.Typ == lexer.XItemProcLit {
+				cur.Test.Target = next.Val.Val
+				next = next.Left
+			}
+			finish()
+		case lexer.XItemPredicate:
+			//The parser does not allow predicates before the first node test,
+			//but the predicate is kept on an empty step if there is one
+			if len(ret) == 0 {
+				finish()
+			}
+			last := ret[len(ret)-1]
+			last.Predicates = append(last.Predicates, &Predicate{Expr: FromNode(n.Left)})
+			next = n.Right
+		}
+
+		n = next
+	}
+
+	return abs, ret
+}
+
+func bindings(n *parser.Node) []*Binding {
+	ret := []*Binding{}
+
+	for ; n != nil; n = n.Right {
+		b := &Binding{Expr: FromNode(n.Left)}
+		b.Var.Prefix, b.Var.Local = splitQName(n.Val.Val)
+		ret = append(ret, b)
+	}
+
+	return ret
+}
+
+func splitQName(name string) (string, string) {
+	if i := strings.Index(name, ":"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+
+	return "", name
+}
@@ -0,0 +1,301 @@
+package ast
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisTrenkamp/goxpath/parser"
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/xconst"
+)
+
+//Format renders the AST as XPath text.  If abbreviate is true, steps are
+//written with the abbreviated syntax where possible, such as // and @.
+//Otherwise, every step is written with its axis.  Operators are separated by
+//single spaces, and parentheses are only added where they are needed.
+func Format(n Node, abbreviate bool) string {
+	p := &printer{abbr: abbreviate}
+	p.node(n)
+	return p.buf.String()
+}
+
+type printer struct {
+	buf  bytes.Buffer
+	abbr bool
+}
+
+func (p *printer) node(n Node) {
+	switch t := n.(type) {
+	case Expr:
+		p.expr(t, parser.MaxPrecedence)
+	case *Step:
+		p.step(t, false)
+	case *Predicate:
+		p.pred(t)
+	case *Binding:
+		p.binding(t, " in ")
+	}
+}
+
+//prec returns the precedence of an expression when it is the operand of an
+//operator.  Primary expressions and paths have a precedence of 0.
+func prec(e Expr) int {
+	switch t := e.(type) {
+	case *BinaryOp:
+		return parser.Precedence(t.Op)
+	case *ForExpr, *LetExpr, *QuantifiedExpr, *IfExpr:
+		return parser.MaxPrecedence
+	}
+	return 0
+}
+
+//negative returns true if the expression starts with a negation, which takes
+//union expressions that follow it as its operand.
+func negative(e Expr) bool {
+	switch t := e.(type) {
+	case *UnaryOp:
+		return true
+	case *Literal:
+		num, ok := t.Value.(tree.Num)
+		return ok && num < 0
+	}
+	return false
+}
+
+//expr writes e, surrounding it with parentheses if its precedence is greater
+//than max.
+func (p *printer) expr(e Expr, max int) {
+	if prec(e) > max {
+		p.buf.WriteString("(")
+		p.expr(e, parser.MaxPrecedence)
+		p.buf.WriteString(")")
+		return
+	}
+
+	switch t := e.(type) {
+	case *PathExpr:
+		p.path(t)
+	case *FilterExpr:
+		p.primary(t.Primary)
+		for _, i := range t.Predicates {
+			p.pred(i)
+		}
+	case *FunctionCall:
+		p.buf.WriteString(qname(t.Prefix, t.Local) + "(")
+		for i, arg := range t.Args {
+			if i > 0 {
+				p.buf.WriteString(", ")
+			}
+			p.expr(arg, parser.SinglePrecedence)
+		}
+		p.buf.WriteString(")")
+	case *BinaryOp:
+		p.binaryOp(t)
+	case *UnaryOp:
+		p.buf.WriteString(t.Op)
+		p.operand(t.Operand, parser.UnionPrecedence, false)
+	case *Literal:
+		p.literal(t)
+	case *VariableRef:
+		p.buf.WriteString("$" + qname(t.Prefix, t.Local))
+	case *EmptySequence:
+		p.buf.WriteString("()")
+	case *ForExpr:
+		p.bindings("for ", " in ", t.Bindings)
+		p.buf.WriteString(" return ")
+		p.expr(t.Return, parser.SinglePrecedence)
+	case *LetExpr:
+		p.bindings("let ", " := ", t.Bindings)
+		p.buf.WriteString(" return ")
+		p.expr(t.Return, parser.SinglePrecedence)
+	case *QuantifiedExpr:
+		p.bindings(t.Quantifier+" ", " in ", t.Bindings)
+		p.buf.WriteString(" satisfies ")
+		p.expr(t.Satisfies, parser.SinglePrecedence)
+	case *IfExpr:
+		p.buf.WriteString("if (")
+		p.expr(t.Cond, parser.MaxPrecedence)
+		p.buf.WriteString(") then ")
+		p.expr(t.Then, parser.SinglePrecedence)
+		p.buf.WriteString(" else ")
+		p.expr(t.Else, parser.SinglePrecedence)
+	}
+}
+
+//operand writes the operand of an operator with the precedence, opPrec.
+//Operators are left-associative, so the right operand is parenthesized if it
+//has the same precedence.  Comparisons are not associative, so both operands
+//are parenthesized if they are comparisons.
+func (p *printer) operand(e Expr, opPrec int, right bool) {
+	max := opPrec
+	if right || opPrec == parser.CompPrecedence {
+		max--
+	}
+
+	if negative(e) && opPrec <= parser.UnionPrecedence {
+		max = -1
+	}
+
+	p.expr(e, max)
+}
+
+func (p *printer) binaryOp(op *BinaryOp) {
+	opPrec := parser.Precedence(op.Op)
+	p.operand(op.Left, opPrec, false)
+
+	if op.Op == "," {
+		p.buf.WriteString(", ")
+	} else {
+		p.buf.WriteString(" " + op.Op + " ")
+	}
+
+	p.operand(op.Right, opPrec, true)
+}
+
+//primary writes the primary expression of a filter expression or path.
+func (p *printer) primary(e Expr) {
+	switch e.(type) {
+	case *FunctionCall, *VariableRef, *EmptySequence, *FilterExpr:
+		p.expr(e, 0)
+		return
+	case *Literal:
+		if !negative(e) {
+			p.expr(e, 0)
+			return
+		}
+	}
+
+	p.buf.WriteString("(")
+	p.expr(e, parser.MaxPrecedence)
+	p.buf.WriteString(")")
+}
+
+func (p *printer) literal(l *Literal) {
+	switch t := l.Value.(type) {
+	case tree.Num:
+		p.buf.WriteString(strconv.FormatFloat(float64(t), 'f', -1, 64))
+	default:
+		p.buf.WriteString(quote(l.Value.String()))
+	}
+}
+
+//quote writes a string literal.  XPath does not have escapes, so strings
+//that contain both kinds of quotes are split into a call to concat.
+func quote(str string) string {
+	if !strings.Contains(str, "'") {
+		return "'" + str + "'"
+	}
+
+	if !strings.Contains(str, `"`) {
+		return `"` + str + `"`
+	}
+
+	parts := strings.Split(str, "'")
+	for i := range parts {
+		parts[i] = "'" + parts[i] + "'"
+	}
+	return "concat(" + strings.Join(parts, `, "'", `) + ")"
+}
+
+func (p *printer) pred(pred *Predicate) {
+	p.buf.WriteString("[")
+	p.expr(pred.Expr, parser.MaxPrecedence)
+	p.buf.WriteString("]")
+}
+
+func (p *printer) bindings(keyword, sep string, bindings []*Binding) {
+	p.buf.WriteString(keyword)
+	for i, b := range bindings {
+		if i > 0 {
+			p.buf.WriteString(", ")
+		}
+		p.binding(b, sep)
+	}
+}
+
+func (p *printer) binding(b *Binding, sep string) {
+	p.buf.WriteString("$" + qname(b.Var.Prefix, b.Var.Local) + sep)
+	p.expr(b.Expr, parser.SinglePrecedence)
+}
+
+func (p *printer) path(path *PathExpr) {
+	if path.Start != nil {
+		p.primary(path.Start)
+	}
+
+	if len(path.Steps) == 0 {
+		if path.Absolute {
+			p.buf.WriteString("/")
+		}
+		return
+	}
+
+	sep := path.Start != nil || path.Absolute
+	for i, step := range path.Steps {
+		if i > 0 || sep {
+			p.buf.WriteString("/")
+		}
+
+		//descendant-or-self::node() is abbreviated to // when it is between steps
+		if p.abbr && isAbbrStep(step) && i+1 < len(path.Steps) && sep {
+			sep = false
+			continue
+		}
+		sep = true
+
+		//A leading node type test without an axis is parsed as a function call
+		p.step(step, i == 0 && path.Start == nil && !path.Absolute && step.Axis == xconst.AxisChild && step.Test.Kind != "")
+	}
+}
+
+func isAbbrStep(step *Step) bool {
+	return step.Axis == xconst.AxisDescendentOrSelf && step.Test == NodeTest{Kind: xconst.NodeTypeNode} && len(step.Predicates) == 0
+}
+
+//step writes a location step.  If explicit is true, the axis is always written.
+func (p *printer) step(step *Step, explicit bool) {
+	if p.abbr && !explicit {
+		kindNode := step.Test == NodeTest{Kind: xconst.NodeTypeNode} && len(step.Predicates) == 0
+
+		switch {
+		case step.Axis == xconst.AxisSelf && kindNode:
+			p.buf.WriteString(".")
+			return
+		case step.Axis == xconst.AxisParent && kindNode:
+			p.buf.WriteString("..")
+			return
+		case step.Axis == xconst.AxisAttribute:
+			p.buf.WriteString("@")
+		case step.Axis != xconst.AxisChild:
+			p.buf.WriteString(step.Axis + "::")
+		}
+	} else {
+		p.buf.WriteString(step.Axis + "::")
+	}
+
+	p.nodeTest(step.Test)
+	for _, i := range step.Predicates {
+		p.pred(i)
+	}
+}
+
+func (p *printer) nodeTest(test NodeTest) {
+	if test.Kind == "" {
+		p.buf.WriteString(qname(test.Prefix, test.Local))
+		return
+	}
+
+	p.buf.WriteString(test.Kind + "(")
+	if test.Target != "" {
+		p.buf.WriteString(quote(test.Target))
+	}
+	p.buf.WriteString(")")
+}
+
+func qname(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
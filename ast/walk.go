@@ -0,0 +1,88 @@
+package ast
+
+//Visitor is called by Walk for each node in the AST.  If the returned visitor,
+//w, is not nil, Walk visits each of the node's children with w, followed by a
+//call of w.Visit(nil).
+type Visitor interface {
+	Visit(n Node) (w Visitor)
+}
+
+//Walk traverses the AST in depth-first order.  It starts by calling
+//v.Visit(n).
+func Walk(v Visitor, n Node) {
+	if v = v.Visit(n); v == nil {
+		return
+	}
+
+	switch t := n.(type) {
+	case *PathExpr:
+		if t.Start != nil {
+			Walk(v, t.Start)
+		}
+		for _, i := range t.Steps {
+			Walk(v, i)
+		}
+	case *Step:
+		walkPreds(v, t.Predicates)
+	case *Predicate:
+		Walk(v, t.Expr)
+	case *FilterExpr:
+		Walk(v, t.Primary)
+		walkPreds(v, t.Predicates)
+	case *FunctionCall:
+		for _, i := range t.Args {
+			Walk(v, i)
+		}
+	case *BinaryOp:
+		Walk(v, t.Left)
+		Walk(v, t.Right)
+	case *UnaryOp:
+		Walk(v, t.Operand)
+	case *Binding:
+		Walk(v, &t.Var)
+		Walk(v, t.Expr)
+	case *ForExpr:
+		walkBindings(v, t.Bindings)
+		Walk(v, t.Return)
+	case *LetExpr:
+		walkBindings(v, t.Bindings)
+		Walk(v, t.Return)
+	case *QuantifiedExpr:
+		walkBindings(v, t.Bindings)
+		Walk(v, t.Satisfies)
+	case *IfExpr:
+		Walk(v, t.Cond)
+		Walk(v, t.Then)
+		Walk(v, t.Else)
+	}
+
+	v.Visit(nil)
+}
+
+func walkPreds(v Visitor, preds []*Predicate) {
+	for _, i := range preds {
+		Walk(v, i)
+	}
+}
+
+func walkBindings(v Visitor, bindings []*Binding) {
+	for _, i := range bindings {
+		Walk(v, i)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(n Node) Visitor {
+	if f(n) {
+		return f
+	}
+	return nil
+}
+
+//Inspect traverses the AST in depth-first order, calling f for each node.  If
+//f returns true, Inspect visits the node's children, followed by a call of
+//f(nil).
+func Inspect(n Node, f func(Node) bool) {
+	Walk(inspector(f), n)
+}
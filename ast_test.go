@@ -0,0 +1,192 @@
+package goxpath
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ChrisTrenkamp/goxpath/ast"
+	"github.com/ChrisTrenkamp/goxpath/tree"
+)
+
+func execAST(xp string, exp ast.Expr, t *testing.T) {
+	res := MustParse(xp).AST()
+	if !reflect.DeepEqual(res, exp) {
+		t.Errorf("Incorrect AST for XPath expression '%s': %#v", xp, res)
+	}
+}
+
+func nameStep(axis, local string, preds ...*ast.Predicate) *ast.Step {
+	return &ast.Step{Axis: axis, Test: ast.NodeTest{Local: local}, Predicates: preds}
+}
+
+func kindStep(axis, kind string) *ast.Step {
+	return &ast.Step{Axis: axis, Test: ast.NodeTest{Kind: kind}}
+}
+
+func num(n float64) *ast.Literal {
+	return &ast.Literal{Value: tree.Num(n)}
+}
+
+func TestASTPaths(t *testing.T) {
+	execAST("/", &ast.PathExpr{Absolute: true, Steps: []*ast.Step{}}, t)
+	execAST("/a/b", &ast.PathExpr{Absolute: true, Steps: []*ast.Step{nameStep("child", "a"), nameStep("child", "b")}}, t)
+	execAST("//a", &ast.PathExpr{Absolute: true, Steps: []*ast.Step{kindStep("descendant-or-self", "node"), nameStep("child", "a")}}, t)
+	execAST("a//@x", &ast.PathExpr{Steps: []*ast.Step{nameStep("child", "a"), kindStep("descendant-or-self", "node"), nameStep("attribute", "x")}}, t)
+	execAST("./..", &ast.PathExpr{Steps: []*ast.Step{kindStep("self", "node"), kindStep("parent", "node")}}, t)
+	execAST("ancestor::p:*", &ast.PathExpr{Steps: []*ast.Step{{Axis: "ancestor", Test: ast.NodeTest{Prefix: "p", Local: "*"}}}}, t)
+	execAST("a/processing-instruction('pi')", &ast.PathExpr{Steps: []*ast.Step{nameStep("child", "a"), {Axis: "child", Test: ast.NodeTest{Kind: "processing-instruction", Target: "pi"}}}}, t)
+	execAST("a[1][@x]/b", &ast.PathExpr{Steps: []*ast.Step{
+		nameStep("child", "a", &ast.Predicate{Expr: num(1)}, &ast.Predicate{Expr: &ast.PathExpr{Steps: []*ast.Step{nameStep("attribute", "x")}}}),
+		nameStep("child", "b"),
+	}}, t)
+}
+
+func TestASTFilterExpr(t *testing.T) {
+	execAST("$v[1]/a", &ast.PathExpr{
+		Start: &ast.FilterExpr{Primary: &ast.VariableRef{Local: "v"}, Predicates: []*ast.Predicate{{Expr: num(1)}}},
+		Steps: []*ast.Step{nameStep("child", "a")},
+	}, t)
+	execAST("f:g(1, 'a')//b", &ast.PathExpr{
+		Start: &ast.FunctionCall{Prefix: "f", Local: "g", Args: []ast.Expr{num(1), &ast.Literal{Value: tree.String("a")}}},
+		Steps: []*ast.Step{kindStep("descendant-or-self", "node"), nameStep("child", "b")},
+	}, t)
+	execAST("(1, 2)[2]", &ast.FilterExpr{
+		Primary:    &ast.BinaryOp{Op: ",", Left: num(1), Right: num(2)},
+		Predicates: []*ast.Predicate{{Expr: num(2)}},
+	}, t)
+	execAST("()", &ast.EmptySequence{}, t)
+}
+
+func TestASTExprs(t *testing.T) {
+	execAST("-$x + -1 * 2", &ast.BinaryOp{
+		Op:    "+",
+		Left:  &ast.UnaryOp{Op: "-", Operand: &ast.VariableRef{Local: "x"}},
+		Right: &ast.BinaryOp{Op: "*", Left: num(-1), Right: num(2)},
+	}, t)
+	execAST("for $a in 1, $b in 2 return $a", &ast.ForExpr{
+		Bindings: []*ast.Binding{{Var: ast.VariableRef{Local: "a"}, Expr: num(1)}, {Var: ast.VariableRef{Local: "b"}, Expr: num(2)}},
+		Return:   &ast.VariableRef{Local: "a"},
+	}, t)
+	execAST("let $a := 1 return $a", &ast.LetExpr{
+		Bindings: []*ast.Binding{{Var: ast.VariableRef{Local: "a"}, Expr: num(1)}},
+		Return:   &ast.VariableRef{Local: "a"},
+	}, t)
+	execAST("every $a in 1 satisfies $a", &ast.QuantifiedExpr{
+		Quantifier: "every",
+		Bindings:   []*ast.Binding{{Var: ast.VariableRef{Local: "a"}, Expr: num(1)}},
+		Satisfies:  &ast.VariableRef{Local: "a"},
+	}, t)
+	execAST("if (1) then 2 else ()", &ast.IfExpr{Cond: num(1), Then: num(2), Else: &ast.EmptySequence{}}, t)
+}
+
+func TestASTInspect(t *testing.T) {
+	fns := []string{}
+	vars := []string{}
+	ast.Inspect(MustParse("count(/a[string-length($x) > 1]) + sum(for $y in b return $y)").AST(), func(n ast.Node) bool {
+		switch t := n.(type) {
+		case *ast.FunctionCall:
+			fns = append(fns, t.Local)
+		case *ast.VariableRef:
+			vars = append(vars, t.Local)
+		}
+		return true
+	})
+
+	if !reflect.DeepEqual(fns, []string{"count", "string-length", "sum"}) {
+		t.Error("Incorrect functions:", fns)
+	}
+
+	if !reflect.DeepEqual(vars, []string{"x", "y", "y"}) {
+		t.Error("Incorrect variables:", vars)
+	}
+
+	count := 0
+	ast.Inspect(MustParse("a[1]/b[2]").AST(), func(n ast.Node) bool {
+		if n != nil {
+			count++
+		}
+		_, ok := n.(*ast.Step)
+		return !ok
+	})
+
+	if count != 3 {
+		t.Error("Incorrect node count:", count)
+	}
+}
+
+func execFormat(xp, abbr, expanded string, t *testing.T) {
+	x := MustParse(xp)
+	if res := x.String(); res != abbr {
+		t.Errorf("Incorrect string for XPath expression '%s': %s, expecting %s", xp, res, abbr)
+	}
+
+	if res := x.Format(false); res != expanded {
+		t.Errorf("Incorrect expanded string for XPath expression '%s': %s, expecting %s", xp, res, expanded)
+	}
+
+	for _, i := range []string{abbr, expanded} {
+		re, err := Parse(i)
+		if err != nil {
+			t.Errorf("Could not parse the formatted expression '%s': %s", i, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(re.AST(), x.AST()) {
+			t.Errorf("The formatted expression '%s' does not have the same AST as '%s'", i, xp)
+		}
+	}
+}
+
+func TestFormatPaths(t *testing.T) {
+	execFormat("/", "/", "/", t)
+	execFormat("/ a / b", "/a/b", "/child::a/child::b", t)
+	execFormat("//a", "//a", "/descendant-or-self::node()/child::a", t)
+	execFormat("a//@x", "a//@x", "child::a/descendant-or-self::node()/attribute::x", t)
+	execFormat("self::node()/parent::node()", "./..", "self::node()/parent::node()", t)
+	execFormat("child::p:a[ 1 ][attribute::b]", "p:a[1][@b]", "child::p:a[1][attribute::b]", t)
+	execFormat("descendant-or-self::node()/a", "descendant-or-self::node()/a", "descendant-or-self::node()/child::a", t)
+	execFormat("a/descendant-or-self::node()", "a/descendant-or-self::node()", "child::a/descendant-or-self::node()", t)
+	execFormat("child::text()", "child::text()", "child::text()", t)
+	execFormat("a/text()|a/processing-instruction(\"pi\")", "a/text() | a/processing-instruction('pi')", "child::a/child::text() | child::a/child::processing-instruction('pi')", t)
+	execFormat("$v[1]//a", "$v[1]//a", "$v[1]/descendant-or-self::node()/child::a", t)
+	execFormat("(a/b)[1]/c", "(a/b)[1]/c", "(child::a/child::b)[1]/child::c", t)
+	execFormat("f:g( 1,2 )/.", "f:g(1, 2)/.", "f:g(1, 2)/self::node()", t)
+}
+
+func TestFormatExprs(t *testing.T) {
+	execFormat("(1+2)*3", "(1 + 2) * 3", "(1 + 2) * 3", t)
+	execFormat("1+(2*3)", "1 + 2 * 3", "1 + 2 * 3", t)
+	execFormat("1-(2-3)", "1 - (2 - 3)", "1 - (2 - 3)", t)
+	execFormat("(1-2)-3", "1 - 2 - 3", "1 - 2 - 3", t)
+	execFormat("-(a|b)", "-a | b", "-child::a | child::b", t)
+	execFormat("(-a)|b", "(-a) | b", "(-child::a) | child::b", t)
+	execFormat("- - 1.50", "1.5", "1.5", t)
+	execFormat("-(1+2)", "-(1 + 2)", "-(1 + 2)", t)
+	execFormat("((1,2),3)", "1, 2, 3", "1, 2, 3", t)
+	execFormat("1,(2,3)", "1, (2, 3)", "1, (2, 3)", t)
+	execFormat("count((1,2))", "count((1, 2))", "count((1, 2))", t)
+	execFormat("(1 = 2) = (3 = 4)", "(1 = 2) = (3 = 4)", "(1 = 2) = (3 = 4)", t)
+	execFormat("(1 eq 1) eq true()", "(1 eq 1) eq true()", "(1 eq 1) eq true()", t)
+	execFormat("1 = 2 < 3", "(1 = 2) < 3", "(1 = 2) < 3", t)
+	execFormat("a and (b or c)", "a and (b or c)", "child::a and (child::b or child::c)", t)
+	execFormat("100000000000000000000000", "100000000000000000000000", "100000000000000000000000", t)
+	execFormat(`"it's"`, `"it's"`, `"it's"`, t)
+	execFormat("(for $x in 1 return $x) + 1", "(for $x in 1 return $x) + 1", "(for $x in 1 return $x) + 1", t)
+	execFormat("for $x in (1,2), $y in 3 return ($x, $y)", "for $x in (1, 2), $y in 3 return ($x, $y)", "for $x in (1, 2), $y in 3 return ($x, $y)", t)
+	execFormat("let  $x  :=  1  return  $x", "let $x := 1 return $x", "let $x := 1 return $x", t)
+	execFormat("some $x in a satisfies $x", "some $x in a satisfies $x", "some $x in child::a satisfies $x", t)
+	execFormat("if(a)then(1)else()", "if (a) then 1 else ()", "if (child::a) then 1 else ()", t)
+	execFormat("(if (a) then 1 else 2), 3", "if (a) then 1 else 2, 3", "if (child::a) then 1 else 2, 3", t)
+}
+
+func TestFormatQuotes(t *testing.T) {
+	res := ast.Format(&ast.Literal{Value: tree.String(`'a" b'`)}, true)
+	exp := `concat('', "'", 'a" b', "'", '')`
+	if res != exp {
+		t.Error("Incorrect string:", res, "expecting", exp)
+	}
+
+	if str := MustParse(res).MustExec(nil).String(); str != `'a" b'` {
+		t.Error("Incorrect result:", str)
+	}
+}
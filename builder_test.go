@@ -0,0 +1,83 @@
+package goxpath
+
+import (
+	"testing"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlele"
+)
+
+func TestBuilder(t *testing.T) {
+	n := xmlele.Elem("r").Attr("id", "1").Attr("b", "2").
+		Child(
+			xmlele.Elem("a").Text("foo"),
+			xmlele.Elem("a").Attr("x", "y").Comment("c").Child(xmlele.Elem("b")),
+		).
+		Text("bar").
+		ProcInst("pi", "inst").
+		MustBuild()
+
+	checkEdit(n, `<r id="1" b="2"><a>foo</a><a x="y"><!--c--><b></b></a>bar<?pi inst?></r>`, t)
+
+	if res := MustParse(`/r/a[@x = 'y']/b/../preceding-sibling::a`).MustExec(n).String(); res != "foo" {
+		t.Errorf("Incorrect result: %s", res)
+	}
+	if res := MustParse(`count(//node())`).MustExec(n).String(); res != "8" {
+		t.Errorf("Incorrect count: %s", res)
+	}
+}
+
+func TestBuilderNS(t *testing.T) {
+	n := xmlele.Elem("p:r").NS("p", "http://p").NS("", "http://d").
+		Child(
+			xmlele.Elem("a").Attr("p:x", "1").Attr("xml:lang", "en"),
+			xmlele.Elem("b").NS("", "").Child(xmlele.Elem("p:c")),
+		).
+		MustBuild()
+
+	opts := func(o *Opts) {
+		o.NS["p"] = "http://p"
+		o.NS["d"] = "http://d"
+	}
+
+	vals := map[string]string{
+		`count(/p:r/d:a/@p:x)`:                 "1",
+		`count(/p:r/d:a[lang('en')])`:          "1",
+		`count(/p:r/b/p:c)`:                    "1",
+		`count(/p:r/d:a/namespace::*)`:         "3",
+		`count(/p:r/b/p:c/namespace::*)`:       "2",
+		`/p:r/d:a/.. is /p:r`:                  "true",
+		`/p:r/b/p:c/namespace::p = 'http://p'`: "true",
+	}
+
+	for xp, exp := range vals {
+		if res := MustParse(xp).MustExec(n, opts).String(); res != exp {
+			t.Errorf("Incorrect result for %s: %s.  Expecting: %s", xp, res, exp)
+		}
+	}
+
+	nodes := MustParse(`//node() | //@* | //namespace::*`).MustExec(n).(tree.NodeSet)
+	for i := 1; i < len(nodes); i++ {
+		if nodes[i-1].Pos() >= nodes[i].Pos() {
+			t.Errorf("Node %d is not in document order: %d", i, nodes[i].Pos())
+		}
+		if nodes[i].GetParent() == nil {
+			t.Errorf("Node %d has no parent", i)
+		}
+	}
+}
+
+func TestBuilderErr(t *testing.T) {
+	errs := map[string]*xmlele.Builder{
+		"Unknown namespace prefix: p":        xmlele.Elem("p:r"),
+		"Unknown namespace prefix: q":        xmlele.Elem("r").Child(xmlele.Elem("a").Attr("q:x", "1")),
+		"Duplicate attribute x on element a": xmlele.Elem("a").Attr("x", "1").Attr("x", "2"),
+		"Cannot build a node without a name": xmlele.Elem("r").Child(xmlele.Elem("")),
+	}
+
+	for exp, b := range errs {
+		if _, err := b.Build(); err == nil || err.Error() != exp {
+			t.Errorf("Incorrect error: %v.  Expecting: %s", err, exp)
+		}
+	}
+}
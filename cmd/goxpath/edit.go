@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ChrisTrenkamp/goxpath"
+	"github.com/ChrisTrenkamp/goxpath/internal/xsort"
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlele"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlnode"
+)
+
+//edit is an operation of the ed command.  The operations are applied to each
+//document in the order they are given.
+type edit struct {
+	op       string
+	src      string
+	xp       goxpath.XPathExec
+	typ      string
+	name     string
+	value    string
+	hasValue bool
+}
+
+//editOp is a flag that adds an edit operation on the nodes selected by an
+//XPath expression.
+type editOp struct {
+	op string
+}
+
+func (e *editOp) String() string {
+	return ""
+}
+
+func (e *editOp) Set(value string) error {
+	xp, err := goxpath.Parse(value)
+	if err != nil {
+		nsErr = fmt.Errorf("Invalid XPath expression for -%s: %s\n", e.op, err.Error())
+		return nil
+	}
+
+	edits = append(edits, &edit{op: e.op, src: value, xp: xp, typ: "elem"})
+	return nil
+}
+
+//editArg is a flag that sets an argument of the previous edit operation.
+type editArg struct {
+	arg string
+}
+
+func (e *editArg) String() string {
+	return ""
+}
+
+func (e *editArg) Set(value string) error {
+	if len(edits) == 0 {
+		nsErr = fmt.Errorf("-%s must follow an edit operation\n", e.arg)
+		return nil
+	}
+
+	last := edits[len(edits)-1]
+
+	switch e.arg {
+	case "value":
+		last.value = value
+		last.hasValue = true
+	case "name":
+		last.name = value
+	case "type":
+		switch value {
+		case "elem", "attr", "text", "comment":
+			last.typ = value
+		default:
+			nsErr = fmt.Errorf("Invalid node type for -%s: %s\n", last.op, value)
+		}
+	}
+
+	return nil
+}
+
+var edits []*edit
+var inplace bool
+
+func editFlags() {
+	flag.Var(&editOp{op: "update"}, "update", "Set the value of the selected nodes to -value. e.g. -update //port -value 8080")
+	flag.Var(&editOp{op: "delete"}, "delete", "Delete the selected nodes. e.g. -delete //debug")
+	flag.Var(&editOp{op: "insert"}, "insert", "Insert a node of -type named -name before the selected nodes. e.g. -insert //port -type elem -name host -value localhost")
+	flag.Var(&editOp{op: "append"}, "append", "Insert a node of -type named -name after the selected nodes")
+	flag.Var(&editOp{op: "subnode"}, "subnode", "Add a node of -type named -name as the last child of the selected elements")
+	flag.Var(&editOp{op: "rename"}, "rename", "Rename the selected elements and attributes to -name. e.g. -rename //@old -name new")
+	flag.Var(&editArg{arg: "value"}, "value", "The value of the previous edit operation")
+	flag.Var(&editArg{arg: "name"}, "name", "The name of the node of the previous edit operation.  Prefixes are resolved with -ns")
+	flag.Var(&editArg{arg: "type"}, "type", "The node type of the previous -insert, -append or -subnode: elem, attr, text or comment.  The default is elem")
+	flag.BoolVar(&inplace, "inplace", false, "Write the edited documents back to their files instead of stdout")
+}
+
+func execEdit() {
+	if len(edits) == 0 {
+		fmt.Fprintf(stderr, "Specify one or more edit operations.  Run 'goxpath ed --help' for more information.\n")
+		retCode = 1
+		return
+	}
+
+	for _, e := range edits {
+		if err := e.check(); err != nil {
+			fmt.Fprintf(stderr, "-%s %s: %s\n", e.op, e.src, err.Error())
+			retCode = 1
+			return
+		}
+	}
+
+	if len(args) == 0 {
+		if inplace {
+			fmt.Fprintf(stderr, "-inplace requires one or more files\n")
+			retCode = 1
+			return
+		}
+
+		data, _ := ioutil.ReadAll(stdin)
+		edited, err := editDoc(data, stdout)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s\n", err.Error())
+			retCode = 1
+		} else if !edited {
+			stdout.Write(data)
+		}
+		return
+	}
+
+	for _, i := range args {
+		editPath(i)
+	}
+}
+
+func editPath(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "Could not open file: %s\n", path)
+		retCode = 1
+		return
+	}
+
+	if fi.IsDir() {
+		if !rec {
+			fmt.Fprintf(stderr, "%s: Is a directory\n", path)
+			retCode = 1
+			return
+		}
+
+		list, _ := ioutil.ReadDir(path)
+		for _, i := range list {
+			editPath(filepath.Join(path, i.Name()))
+		}
+		return
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	buf := &bytes.Buffer{}
+
+	edited, err := editDoc(data, buf)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", path, err.Error())
+		retCode = 1
+		return
+	}
+
+	if !inplace {
+		if !edited {
+			stdout.Write(data)
+		} else {
+			stdout.Write(buf.Bytes())
+		}
+		return
+	}
+
+	if !edited {
+		return
+	}
+
+	if err = replaceFile(path, buf.Bytes(), fi.Mode()); err != nil {
+		fmt.Fprintf(stderr, "%s: %s\n", path, err.Error())
+		retCode = 1
+	}
+}
+
+//replaceFile writes data to a temporary file in the same directory as path,
+//and renames it to path, so the file is not left truncated if the write fails.
+func replaceFile(path string, data []byte, mode os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(mode)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+//editDoc applies the edit operations to the document, data, and writes the
+//result to w.  It returns false without writing anything if no node was
+//selected, so the document can be left as it is.  The prolog, namespace
+//prefixes and empty-element tags of the document are kept.
+func editDoc(data []byte, w io.Writer) (bool, error) {
+	t, err := xmltree.ParseXML(bytes.NewBuffer(data), func(o *xmltree.ParseOptions) {
+		o.Strict = !unstrict
+	})
+	if err != nil {
+		return false, err
+	}
+
+	opts, err := bindVars(t, ns)
+	if err != nil {
+		return false, err
+	}
+
+	edited := false
+
+	for _, e := range edits {
+		//The edits renumber the document, so the -varfile documents are
+		//moved after it again
+		orderVarFiles(t)
+
+		n, err := e.exec(t, opts)
+		if err != nil {
+			return false, fmt.Errorf("-%s %s: %s", e.op, e.src, err.Error())
+		}
+		edited = edited || n > 0
+	}
+
+	if !edited {
+		return false, nil
+	}
+
+	return true, goxpath.Marshal(t, w, func(o *goxpath.MarshalOpts) {
+		o.Prolog = true
+		o.Prefixes = true
+		o.EmptyTags = true
+	})
+}
+
+func (e *edit) check() error {
+	switch e.op {
+	case "update":
+		if !e.hasValue {
+			return fmt.Errorf("-value is required")
+		}
+	case "insert", "append", "subnode":
+		if e.name == "" && (e.typ == "elem" || e.typ == "attr") {
+			return fmt.Errorf("-name is required")
+		}
+	case "rename":
+		if e.name == "" {
+			return fmt.Errorf("-name is required")
+		}
+	}
+
+	if e.name != "" {
+		if _, err := expandName(e.name, ns); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//exec applies the edit to the selected nodes, and returns the number of nodes
+//it selected.  The nodes are edited in reverse document order in a batch, so
+//the document is only renumbered once.
+func (e *edit) exec(t tree.Node, opts func(*goxpath.Opts)) (int, error) {
+	res, err := e.xp.Exec(t, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	nodes, ok := selectedNodes(res)
+	if !ok {
+		return 0, fmt.Errorf("The expression does not select nodes")
+	}
+
+	name, _ := expandName(e.name, ns)
+
+	return len(nodes), t.(*xmlele.XMLEle).Batch(func() error {
+		return e.editNodes(nodes, name)
+	})
+}
+
+//selectedNodes returns the nodes of a node-set, or of a sequence that only
+//contains nodes.  The nodes of a sequence are put in document order, and
+//duplicates are removed, so each node is edited once.
+func selectedNodes(res tree.Result) (tree.NodeSet, bool) {
+	switch t := res.(type) {
+	case tree.NodeSet:
+		return t, true
+	case tree.Sequence:
+		nodes, ok := t.NodeSet()
+		if !ok {
+			return nil, false
+		}
+
+		xsort.SortNodes(nodes)
+
+		ret := nodes[:0]
+		for _, n := range nodes {
+			if len(ret) == 0 || n.Pos() != ret[len(ret)-1].Pos() {
+				ret = append(ret, n)
+			}
+		}
+
+		return ret, true
+	}
+
+	return nil, false
+}
+
+func (e *edit) editNodes(nodes tree.NodeSet, name xml.Name) error {
+	for _, n := range nodes {
+		switch n.GetNodeType() {
+		case tree.NtRoot:
+			if e.op != "subnode" {
+				return fmt.Errorf("Cannot %s the root node", e.op)
+			}
+		case tree.NtNs:
+			return fmt.Errorf("Cannot %s a namespace node", e.op)
+		}
+	}
+
+	if e.op == "delete" {
+		return e.remove(nodes)
+	}
+
+	var err error
+
+	for i := len(nodes) - 1; i >= 0; i-- {
+		n := nodes[i]
+
+		switch e.op {
+		case "update":
+			err = e.update(n)
+		case "insert", "append", "subnode":
+			err = e.insert(n, name)
+		case "rename":
+			err = e.rename(n, name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *edit) update(n tree.Node) error {
+	switch n.GetNodeType() {
+	case tree.NtAttr:
+		n.GetParent().(*xmlele.XMLEle).SetAttr(n.GetToken().(xml.Attr).Name, e.value)
+		return nil
+	case tree.NtElem:
+		ele := n.(*xmlele.XMLEle)
+		for len(ele.Children) > 0 {
+			if err := ele.RemoveChild(ele.Children[0]); err != nil {
+				return err
+			}
+		}
+
+		if e.value == "" {
+			return nil
+		}
+
+		return ele.AppendChild(xmlnode.XMLNode{Token: xml.CharData(e.value), NodeType: tree.NtChd})
+	}
+
+	node := xmlnode.XMLNode{NodeType: n.GetNodeType()}
+
+	switch tok := n.GetToken().(type) {
+	case xml.CharData:
+		node.Token = xml.CharData(e.value)
+	case xml.Comment:
+		node.Token = xml.Comment(e.value)
+	case xml.ProcInst:
+		node.Token = xml.ProcInst{Target: tok.Target, Inst: []byte(e.value)}
+	}
+
+	return n.GetParent().(*xmlele.XMLEle).ReplaceChild(n, node)
+}
+
+//remove deletes the nodes.  The children of each element are removed
+//together, so large deletes do not shift the children once per node.
+func (e *edit) remove(nodes tree.NodeSet) error {
+	var parents []*xmlele.XMLEle
+	children := make(map[*xmlele.XMLEle][]tree.Node)
+
+	for _, n := range nodes {
+		parent := n.GetParent().(*xmlele.XMLEle)
+
+		if n.GetNodeType() == tree.NtAttr {
+			parent.RemoveAttr(n.GetToken().(xml.Attr).Name)
+			continue
+		}
+
+		if _, ok := children[parent]; !ok {
+			parents = append(parents, parent)
+		}
+		children[parent] = append(children[parent], n)
+	}
+
+	for _, i := range parents {
+		if err := i.RemoveChildren(children[i]...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *edit) insert(n tree.Node, name xml.Name) error {
+	if e.typ == "attr" {
+		ele, ok := n.(*xmlele.XMLEle)
+		if !ok || n.GetNodeType() != tree.NtElem {
+			return fmt.Errorf("Cannot add an attribute to a node that is not an element")
+		}
+
+		ele.SetAttr(name, e.value)
+		return nil
+	}
+
+	var node tree.Node
+
+	switch e.typ {
+	case "elem":
+		ele := xmlele.NewElem(name)
+		if e.value != "" {
+			ele.Children = append(ele.Children, xmlnode.XMLNode{Token: xml.CharData(e.value), NodeType: tree.NtChd, Parent: ele})
+		}
+		node = ele
+	case "text":
+		node = xmlnode.XMLNode{Token: xml.CharData(e.value), NodeType: tree.NtChd}
+	case "comment":
+		node = xmlnode.XMLNode{Token: xml.Comment(e.value), NodeType: tree.NtComm}
+	}
+
+	if e.op == "subnode" {
+		ele, ok := n.(*xmlele.XMLEle)
+		if !ok {
+			return fmt.Errorf("Cannot add a node to a node that is not an element")
+		}
+
+		return ele.AppendChild(node)
+	}
+
+	if n.GetNodeType() == tree.NtAttr {
+		return fmt.Errorf("Cannot %s a node next to an attribute", e.op)
+	}
+
+	parent := n.GetParent().(*xmlele.XMLEle)
+	if e.op == "insert" {
+		return parent.InsertBefore(n, node)
+	}
+
+	return parent.InsertAfter(n, node)
+}
+
+func (e *edit) rename(n tree.Node, name xml.Name) error {
+	switch n.GetNodeType() {
+	case tree.NtElem:
+		n.(*xmlele.XMLEle).Rename(name)
+		return nil
+	case tree.NtAttr:
+		attr := n.GetToken().(xml.Attr).Name
+		if !n.GetParent().(*xmlele.XMLEle).RenameAttr(attr, name) {
+			return fmt.Errorf("Cannot rename the attribute %s to %s, since it already exists", attr.Local, e.name)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("Only elements and attributes can be renamed")
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	"bytes"
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/ChrisTrenkamp/goxpath"
 	"github.com/ChrisTrenkamp/goxpath/tree"
 	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlele"
+	"github.com/ChrisTrenkamp/goxpath/xconst"
 )
 
 type namespace map[string]string
@@ -30,28 +34,112 @@ func (n *namespace) Set(value string) error {
 	return nil
 }
 
-type variables map[string]string
+//variable is an XPath variable given on the command line.  Variables from
+//-var:xpath are evaluated against each input document, and the documents of
+//-varfile are parsed after the flags.
+type variable struct {
+	name string
+	val  tree.Result
+	xp   *goxpath.XPathExec
+	file string
+}
+
+//xmlName resolves the prefix of the variable's name with the namespace
+//mappings.
+func (v variable) xmlName(ns namespace) (xml.Name, error) {
+	name, err := expandName(v.name, ns)
+	if err != nil {
+		return name, fmt.Errorf("Variable %s: %s", v.name, err.Error())
+	}
+
+	return name, nil
+}
+
+//expandName resolves the prefix of a QName with the namespace mappings, or
+//the predeclared prefixes, such as xs, like the prefixes of XPath expressions.
+func expandName(qname string, ns namespace) (xml.Name, error) {
+	spl := strings.SplitN(qname, ":", 2)
+	if len(spl) == 1 {
+		return xml.Name{Local: qname}, nil
+	}
+
+	space, ok := ns[spl[0]]
+	if !ok {
+		space, ok = xconst.PredeclaredNS[spl[0]]
+	}
+	if !ok {
+		return xml.Name{}, fmt.Errorf("Unknown namespace prefix: %s", spl[0])
+	}
+
+	return xml.Name{Space: space, Local: spl[1]}, nil
+}
+
+//variables is a flag that binds variables of the type, typ.
+type variables struct {
+	typ string
+}
 
 func (v *variables) String() string {
-	return fmt.Sprint(*v)
+	return fmt.Sprint(vars)
 }
 
 func (v *variables) Set(value string) error {
-	varMap := strings.Split(value, "=")
+	var varMap []string
+	if v.typ == "xpath" || v.typ == "file" {
+		varMap = strings.SplitN(value, "=", 2)
+	} else {
+		varMap = strings.Split(value, "=")
+	}
+
 	if len(varMap) != 2 {
 		nsErr = fmt.Errorf("Invalid variable mapping: %s\n", value)
+		return nil
 	}
-	(*v)[varMap[0]] = varMap[1]
+
+	ret := variable{name: varMap[0]}
+	val := varMap[1]
+
+	switch v.typ {
+	case "num":
+		num, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			nsErr = fmt.Errorf("Invalid number for variable %s: %s\n", ret.name, val)
+			return nil
+		}
+		ret.val = tree.Num(num)
+	case "bool":
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			nsErr = fmt.Errorf("Invalid boolean for variable %s: %s\n", ret.name, val)
+			return nil
+		}
+		ret.val = tree.Bool(b)
+	case "xpath":
+		xp, err := goxpath.Parse(val)
+		if err != nil {
+			nsErr = fmt.Errorf("Invalid XPath expression for variable %s: %s\n", ret.name, err.Error())
+			return nil
+		}
+		ret.xp = &xp
+	case "file":
+		ret.file = val
+	default:
+		ret.val = tree.String(val)
+	}
+
+	vars = append(vars, ret)
 	return nil
 }
 
 var rec bool
 var value bool
+var explain bool
 var ns = make(namespace)
-var vars = make(variables)
+var vars []variable
 var nsErr error
 var unstrict bool
 var noFileName bool
+var lineNum bool
 var args = []string{}
 var stdin io.Reader = os.Stdin
 var stdout io.ReadWriter = os.Stdout
@@ -65,21 +153,45 @@ func main() {
 }
 
 func exec() {
+	edMode := len(os.Args) > 1 && os.Args[1] == "ed"
+	edits = nil
+
 	flag.BoolVar(&rec, "r", false, "Recursive")
 	flag.BoolVar(&value, "v", false, "Output the string value of the XPath result")
 	flag.Var(&ns, "ns", "Namespace mappings. e.g. -ns myns=http://example.com")
-	flag.Var(&vars, "var", "Variables mappings. e.g. -var myvar=myvalue")
+	flag.Var(&variables{typ: "string"}, "var", "Variables mappings. e.g. -var myvar=myvalue")
+	flag.Var(&variables{typ: "num"}, "var:num", "Number variable mappings. e.g. -var:num limit=5")
+	flag.Var(&variables{typ: "bool"}, "var:bool", "Boolean variable mappings. e.g. -var:bool debug=true")
+	flag.Var(&variables{typ: "xpath"}, "var:xpath", "Variables bound to an XPath expression, evaluated against the input document. e.g. -var:xpath total=count(//item)")
+	flag.Var(&variables{typ: "file"}, "varfile", "Variables bound to the root node of another XML document, whose nodes are ordered after the input document's. e.g. -varfile other=path.xml")
 	flag.BoolVar(&unstrict, "u", false, "Turns off strict XML validation")
 	flag.BoolVar(&noFileName, "h", false, "Suppress filename prefixes.")
-	flag.Parse()
+	flag.BoolVar(&lineNum, "n", false, "Prefix each node with its line and column in the input, and the file name, e.g. file.xml:3:5:")
+	flag.BoolVar(&explain, "explain", false, "Output the evaluation plan of the XPath expression, with the number of items and time of each step, instead of the result")
+
+	if edMode {
+		editFlags()
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 	args = flag.Args()
 
+	if nsErr == nil {
+		nsErr = loadVarFiles()
+	}
+
 	if nsErr != nil {
 		fmt.Fprintf(stderr, nsErr.Error())
 		retCode = 1
 		return
 	}
 
+	if edMode {
+		execEdit()
+		return
+	}
+
 	if len(args) < 1 {
 		fmt.Fprintf(stderr, "Specify an XPath expression with one or more files, or pipe the XML from stdin.  Run 'goxpath --help' for more information.\n")
 		retCode = 1
@@ -134,7 +246,7 @@ func procPath(path string, x goxpath.XPathExec, ns namespace, value bool) {
 
 func printResult(ret []string, path string) {
 	for _, j := range ret {
-		if (len(flag.Args()) > 2 || rec) && !noFileName {
+		if (len(flag.Args()) > 2 || rec || (lineNum && path != "")) && !noFileName {
 			fmt.Fprintf(stdout, "%s:", path)
 		}
 
@@ -165,12 +277,21 @@ func runXPath(x goxpath.XPathExec, r io.Reader, ns namespace, value bool) ([]str
 		return nil, err
 	}
 
-	res, err := x.Exec(t, func(o *goxpath.Opts) {
-		o.NS = ns
-		for k, v := range vars {
-			o.Vars[k] = tree.String(v)
+	opts, err := bindVars(t, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	if explain {
+		plan, err := x.ExplainExec(t, opts)
+		if err != nil {
+			return nil, err
 		}
-	})
+
+		return strings.Split(plan, "\n"), nil
+	}
+
+	res, err := x.Exec(t, opts)
 
 	if err != nil {
 		return nil, err
@@ -178,11 +299,19 @@ func runXPath(x goxpath.XPathExec, r io.Reader, ns namespace, value bool) ([]str
 
 	var ret []string
 
-	if nodes, ok := res.(tree.NodeSet); ok && !value {
+	if nodes, ok := res.(tree.NodeSet); ok && (!value || lineNum) {
 		ret = make([]string, len(nodes))
 		for i, v := range nodes {
-			ret[i], _ = goxpath.MarshalStr(v)
-			ret[i] = strings.Replace(ret[i], "\n", "&#10;", -1)
+			ret[i] = location(v) + nodeStr(v, value)
+		}
+	} else if seq, ok := res.(tree.Sequence); ok {
+		for _, i := range tree.Items(seq) {
+			if tree.IsNode(i) && (!value || lineNum) {
+				n := i.(tree.NodeSet)[0]
+				ret = append(ret, location(n)+nodeStr(n, value))
+			} else {
+				ret = append(ret, i.String())
+			}
 		}
 	} else {
 		str := res.String()
@@ -193,3 +322,121 @@ func runXPath(x goxpath.XPathExec, r io.Reader, ns namespace, value bool) ([]str
 
 	return ret, nil
 }
+
+//nodeStr returns the node in XML form, or its string value.  Newlines are
+//escaped so each node is printed on one line.
+func nodeStr(n tree.Node, value bool) string {
+	str := n.ResValue()
+	if !value {
+		str, _ = goxpath.MarshalStr(n)
+	}
+
+	return strings.Replace(str, "\n", "&#10;", -1)
+}
+
+//location returns the line:col: prefix of the node if -n is set.  Namespace
+//nodes use the location of their element.
+func location(n tree.Node) string {
+	if !lineNum {
+		return ""
+	}
+
+	if n.GetNodeType() == tree.NtNs {
+		n = n.GetParent()
+	}
+
+	if l, ok := n.(tree.LocatedNode); ok && l.GetLocation().Line > 0 {
+		return fmt.Sprintf("%d:%d:", l.GetLocation().Line, l.GetLocation().Col)
+	}
+
+	return ""
+}
+
+//loadVarFiles parses the documents of the -varfile variables.
+func loadVarFiles() error {
+	for i := range vars {
+		if vars[i].file == "" {
+			continue
+		}
+
+		f, err := os.Open(vars[i].file)
+		if err != nil {
+			return fmt.Errorf("Could not open file: %s\n", vars[i].file)
+		}
+
+		t, err := xmltree.ParseXML(f, func(o *xmltree.ParseOptions) {
+			o.Strict = !unstrict
+		})
+		f.Close()
+
+		if err != nil {
+			return fmt.Errorf("%s: %s\n", vars[i].file, err.Error())
+		}
+		vars[i].val = tree.NodeSet{t}
+	}
+
+	return nil
+}
+
+//orderVarFiles numbers the nodes of the -varfile documents after the nodes of
+//the input document, t.  Node-sets are ordered and deduplicated by position,
+//so the documents must not share positions to be used in the same
+//expression.  Nothing is renumbered if there are no -varfile documents.
+func orderVarFiles(t tree.Node) {
+	root, ok := t.(*xmlele.XMLEle)
+	if !ok {
+		return
+	}
+
+	var docs []*xmlele.XMLEle
+	for _, i := range vars {
+		if i.file == "" {
+			continue
+		}
+
+		if doc, ok := i.val.(tree.NodeSet)[0].(*xmlele.XMLEle); ok {
+			docs = append(docs, doc)
+		}
+	}
+
+	if len(docs) == 0 {
+		return
+	}
+
+	pos := root.Renumber(root.Pos())
+	for _, i := range docs {
+		pos = i.Renumber(pos)
+	}
+}
+
+//bindVars returns the options for executing XPath expressions against t, with
+//the variables given on the command line.
+func bindVars(t tree.Node, ns namespace) (func(*goxpath.Opts), error) {
+	bound := make(map[xml.Name]tree.Result)
+	opts := func(o *goxpath.Opts) {
+		o.NS = ns
+		o.Vars = bound
+	}
+
+	orderVarFiles(t)
+
+	for _, i := range vars {
+		name, err := i.xmlName(ns)
+		if err != nil {
+			return nil, err
+		}
+
+		if i.xp == nil {
+			bound[name] = i.val
+			continue
+		}
+
+		val, err := i.xp.Exec(t, opts)
+		if err != nil {
+			return nil, fmt.Errorf("Variable %s: %s", i.name, err.Error())
+		}
+		bound[name] = val
+	}
+
+	return opts, nil
+}
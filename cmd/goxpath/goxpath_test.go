@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"encoding/xml"
 	"flag"
+	"io/ioutil"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
 )
 
 func setup(in string, args ...string) (*bytes.Buffer, *bytes.Buffer) {
 	retCode = 0
+	nsErr = nil
+	vars = nil
 	os.Args = append([]string{"test"}, args...)
 	flag.CommandLine = flag.NewFlagSet("test", flag.ExitOnError)
 	out := &bytes.Buffer{}
@@ -163,3 +167,282 @@ func TestInvalidVarMap(t *testing.T) {
 		t.Error("Incorrect return value")
 	}
 }
+
+func TestSequenceResult(t *testing.T) {
+	out, _ := setup(xml.Header+"<root><tag>test</tag></root>", "(/root/tag, 'foo', 1 + 1)")
+	if out.String() != "<tag>test</tag>\nfoo\n2\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	out, _ = setup(xml.Header+"<root><tag>test</tag></root>", "-v", "(/root/tag, 'foo')")
+	if out.String() != "test\nfoo\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	if retCode != 0 {
+		t.Error("Incorrect return value")
+	}
+}
+
+func TestTypedVars(t *testing.T) {
+	x := xml.Header + "<root><item>3</item><item>7</item><item>10</item></root>"
+	out, _ := setup(x, "-var:num", "limit=5", "count(/root/item[. > $limit])")
+	if out.String() != "2\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	out, _ = setup(x, "-var", "limit=5", "$limit + 1")
+	if out.String() != "6\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	out, _ = setup(x, "-var:bool", "all=false", "if ($all) then count(/root/item) else 1")
+	if out.String() != "1\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	out, _ = setup(x, "-var:xpath", "max=/root/item[last()]", "-var:xpath", "n=count(/root/item[. != $max])", "$n * $max")
+	if out.String() != "20\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	out, _ = setup(x, "-var:xpath", "big=/root/item[. >= 7]", "$big")
+	if out.String() != "<item>7</item>\n<item>10</item>\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	if retCode != 0 {
+		t.Error("Incorrect return value")
+	}
+}
+
+func TestVarFile(t *testing.T) {
+	x := xml.Header + "<root><ref>2</ref></root>"
+	out, _ := setup(x, "-varfile", "other=test/subdir/2.xml", "-v", "concat($other/foo, /root/ref)")
+	if out.String() != "bar2\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	if retCode != 0 {
+		t.Error("Incorrect return value")
+	}
+}
+
+func TestVarFileOrder(t *testing.T) {
+	x := xml.Header + "<root><y/><y/></root>"
+	tests := map[string]string{
+		`count($other/foo | //y)`:          "3\n",
+		`count(//y except $other//foo)`:    "2\n",
+		`count(//y intersect $other//foo)`: "0\n",
+		`$other/foo is /root`:              "false\n",
+		`/root << $other/foo`:              "true\n",
+	}
+
+	for xp, exp := range tests {
+		out, _ := setup(x, "-varfile", "other=test/subdir/2.xml", "-v", xp)
+		if out.String() != exp {
+			t.Error("Incorrect result for", xp, "Recieved: ", out.String(), "Expecting", exp)
+		}
+	}
+}
+
+func TestVarFileUnstrict(t *testing.T) {
+	f, _ := ioutil.TempFile("", "goxpath")
+	defer os.Remove(f.Name())
+	f.WriteString("<foo>&nbsp;bar</foo>")
+	f.Close()
+
+	out, _ := setup(xml.Header+"<root/>", "-varfile", "other="+f.Name(), "-u", "-v", "$other/foo")
+	if out.String() != "&nbsp;bar\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	if retCode != 0 {
+		t.Error("Incorrect return value")
+	}
+}
+
+func TestTypedVarErr(t *testing.T) {
+	tests := map[string][]string{
+		"Invalid number for variable limit: five\n":                                            {"-var:num", "limit=five", "/root"},
+		"Invalid boolean for variable b: maybe\n":                                              {"-var:bool", "b=maybe", "/root"},
+		"Invalid XPath expression for variable x: Missing ) at end of function declaration.\n": {"-var:xpath", "x=count(", "/root"},
+		"Could not open file: nonexistent.xml\n":                                               {"-varfile", "doc=nonexistent.xml", "/root"},
+		"Variable x: Invalid variable 'y'\n":                                                   {"-var:xpath", "x=$y", "$x"},
+		"Invalid variable mapping: x\n":                                                        {"-var:xpath", "x", "/root"},
+	}
+
+	for exp, args := range tests {
+		_, err := setup(xml.Header+"<root/>", args...)
+		if err.String() != exp {
+			t.Error("Invalid error", err.String(), "Expecting", exp)
+		}
+		if retCode != 1 {
+			t.Error("Incorrect return value")
+		}
+	}
+}
+
+func TestNamespacedVar(t *testing.T) {
+	out, _ := setup(xml.Header+"<root/>", "-var:num", "p:x=5", "-ns", "p=http://p", "-v", "$p:x * 2")
+	if out.String() != "10\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	if retCode != 0 {
+		t.Error("Incorrect return value")
+	}
+
+	out, _ = setup(xml.Header+"<root/>", "-var", "xs:x=5", "-v", "$xs:x")
+	if out.String() != "5\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+
+	_, err := setup(xml.Header+"<root/>", "-var", "q:x=5", "/root")
+	if err.String() != "Variable q:x: Unknown namespace prefix: q\n" {
+		t.Error("Incorrect error.  Recieved: ", err.String())
+	}
+	if retCode != 1 {
+		t.Error("Incorrect return value")
+	}
+}
+
+func TestExplain(t *testing.T) {
+	x := xml.Header + "<root><item>1</item><item>2</item></root>"
+	out, _ := setup(x, "-explain", "//item[. > 1]")
+	res := regexp.MustCompile(`time=[^\]]*`).ReplaceAllString(out.String(), "time=T")
+	exp := `absolute path
+  step descendant::item  [calls=1 items=2 time=T]
+    predicate  [calls=1 items=1 time=T]
+      operator >  [calls=2 items=2 time=T]
+        relative path
+          step self::node()  [calls=2 items=2 time=T]
+        number 1  [calls=2 items=2 time=T]
+optimizations
+  //item rewritten to descendant::item
+`
+	if res != exp {
+		t.Error("Incorrect result.  Recieved: ", res)
+	}
+	if retCode != 0 {
+		t.Error("Incorrect return value")
+	}
+}
+
+func TestEdit(t *testing.T) {
+	x := xml.Header + `<config xmlns:p="http://p"><port>80</port><debug/><p:host name="a" old="1">x</p:host><!--c--></config>`
+	tests := map[string][]string{
+		`<config xmlns:p="http://p"><port>8080</port><debug/><p:host name="b" old="1">x</p:host><!--c--></config>`:                                                   {"-update", "/config/port", "-value", "8080", "-update", "//@name", "-value", "b"},
+		`<config xmlns:p="http://p"><port>80</port><p:host name="a">x</p:host></config>`:                                                                             {"-delete", "//debug | //@old | //comment()"},
+		`<config xmlns:p="http://p"><port>80</port><p:host name="a" old="1">x</p:host></config>`:                                                                     {"-delete", "(//comment(), //debug, //debug)"},
+		`<config xmlns:p="http://p"><port>80</port><host>localhost</host><debug/><p:host name="a" old="1">x</p:host><!--c--></config>`:                               {"-insert", "/config/debug", "-name", "host", "-value", "localhost"},
+		`<config xmlns:p="http://p"><port>80</port><debug level="2"/><p:host name="a" old="1">x</p:host><!--c--><!--end--></config>`:                                 {"-subnode", "/config/debug", "-type", "attr", "-name", "level", "-value", "2", "-append", "//comment()", "-type", "comment", "-value", "end"},
+		`<config xmlns:p="http://p"><port>80</port><trace/><p:server name="a" new="1">x</p:server><!--c--></config>`:                                                 {"-ns", "q=http://p", "-rename", "/config/debug", "-name", "trace", "-rename", "//q:host", "-name", "q:server", "-rename", "//@old", "-name", "new"},
+		`<config xmlns:p="http://p"><port>80</port><debug/><p:host name="a" old="1">y</p:host><!--d--></config>`:                                                     {"-update", "//text()[. = 'x'] | //comment()", "-value", "y", "-update", "//comment()", "-value", "d"},
+		`<config xmlns:ns1="http://z" xmlns:p="http://p" ns1:b="1"><port>80</port><debug/><p:host name="a" old="1">x</p:host><!--c--><a xmlns="http://z"/></config>`: {"-ns", "z=http://z", "-subnode", "/config", "-name", "z:a", "-subnode", "/config", "-type", "attr", "-name", "z:b", "-value", "1"},
+	}
+
+	for exp, args := range tests {
+		out, errOut := setup(x, append([]string{"ed"}, args...)...)
+		exp = xml.Header + exp
+		if out.String() != exp {
+			t.Error("Incorrect result.  Recieved: ", out.String(), errOut.String(), "Expecting: ", exp)
+		}
+		if retCode != 0 {
+			t.Error("Incorrect return value")
+		}
+	}
+}
+
+func TestEditErr(t *testing.T) {
+	tests := map[string][]string{
+		"Specify one or more edit operations.  Run 'goxpath ed --help' for more information.\n": {},
+		"-value must follow an edit operation\n":                                                {"-value", "1"},
+		"Invalid node type for -insert: pi\n":                                                   {"-insert", "/r", "-type", "pi"},
+		"-update /r: -value is required\n":                                                      {"-update", "/r"},
+		"-rename /r: -name is required\n":                                                       {"-rename", "/r"},
+		"-insert /r: Unknown namespace prefix: zz\n":                                            {"-insert", "/r", "-name", "zz:a"},
+		"-delete /: Cannot delete the root node\n":                                              {"-delete", "/"},
+		"-delete count(/r): The expression does not select nodes\n":                             {"-delete", "count(/r)"},
+		"-rename /r/@a: Cannot rename the attribute a to b, since it already exists\n":          {"-rename", "/r/@a", "-name", "b"},
+		"-inplace requires one or more files\n":                                                 {"-delete", "/r", "-inplace"},
+	}
+
+	for exp, args := range tests {
+		_, err := setup(`<r a="1" b="2"/>`, append([]string{"ed"}, args...)...)
+		if err.String() != exp {
+			t.Error("Invalid error", err.String(), "Expecting", exp)
+		}
+		if retCode != 1 {
+			t.Error("Incorrect return value")
+		}
+	}
+}
+
+func TestEditInplace(t *testing.T) {
+	f, err := ioutil.TempFile("", "goxpath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("<r>\n  <a>1</a>\n</r>\n")
+	f.Close()
+
+	out, _ := setup("", "ed", "-inplace", "-update", "/r/a", "-value", "2", f.Name())
+	if out.String() != "" || retCode != 0 {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+
+	data, _ := ioutil.ReadFile(f.Name())
+	if string(data) != "<r>\n  <a>2</a>\n</r>\n" {
+		t.Error("Incorrect file.  Recieved: ", string(data))
+	}
+}
+
+func TestEditUnchanged(t *testing.T) {
+	x := "<?xml version='1.0'?>\n<!DOCTYPE r [\n<!ATTLIST a id ID #IMPLIED>\n]>\n<r xmlns:p=\"http://p\">\n  <p:a id='1'></p:a>\n</r>\n"
+
+	f, err := ioutil.TempFile("", "goxpath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(x)
+	f.Close()
+
+	out, _ := setup("", "ed", "-inplace", "-delete", "//nomatch", f.Name())
+	if out.String() != "" || retCode != 0 {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+
+	data, _ := ioutil.ReadFile(f.Name())
+	if string(data) != x {
+		t.Error("Incorrect file.  Recieved: ", string(data))
+	}
+
+	out, _ = setup(x, "ed", "-delete", "//nomatch")
+	if out.String() != x {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+
+	exp := "<?xml version='1.0'?>\n<!DOCTYPE r [\n<!ATTLIST a id ID #IMPLIED>\n]>\n<r xmlns:p=\"http://p\">\n  <p:a id=\"2\"/>\n</r>\n"
+	out, _ = setup(x, "ed", "-update", "//@id", "-value", "2")
+	if out.String() != exp {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+}
+
+func TestLineNum(t *testing.T) {
+	x := xml.Header + "<root>\n  <item id=\"1\">a</item>\n  <item>b</item>\n</root>"
+	out, _ := setup(x, "-n", "//item | //@id")
+	if out.String() != "3:3:<item id=\"1\">a</item>\n3:3:<?attribute id=\"1\"?>\n4:3:<item>b</item>\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	out, _ = setup(x, "-n", "-v", "(//item/text(), count(//item))")
+	if out.String() != "3:16:a\n4:9:b\n2\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	out, _ = setup("", "-n", "-ns", "foo=http://foo.bar", "/foo:test/foo:path", "test/1.xml")
+	if out.String() != `test/1.xml:1:72:<path xmlns="http://foo.bar">path</path>`+"\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	out, _ = setup("", "-n", "-h", "/foo", "test/subdir/2.xml")
+	if out.String() != "1:39:<foo>bar</foo>\n" {
+		t.Error("Incorrect result.  Recieved: ", out.String())
+	}
+	if retCode != 0 {
+		t.Error("Incorrect return value")
+	}
+}
@@ -89,3 +89,58 @@ func TestNumberOps(t *testing.T) {
 		execVal(k, x, v, nil, t)
 	}
 }
+
+func TestPrecedence(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><t><t1>2</t1><t2>3</t2></t>`
+	execVal(`1 - 2 * 3 - 4`, x, "-9", nil, t)
+	execVal(`2 * count(/t/*) + 3`, x, "7", nil, t)
+	execVal(`/t/t1 * /t/t2 - 1`, x, "5", nil, t)
+	execVal(`1 = 1 and 2 = 3 or 4 = 4`, x, "true", nil, t)
+	execVal(`1 = 2 or 3 = 3 and 4 = 5`, x, "false", nil, t)
+}
+
+func TestNegation(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><t><t1>2</t1><t2>3</t2></t>`
+	execVal(`5-3`, x, "2", nil, t)
+	execVal(`5 -3`, x, "2", nil, t)
+	execVal(`-(1 + 2)`, x, "-3", nil, t)
+	execVal(`- -2`, x, "2", nil, t)
+	execVal(`-/t/t1 * 2`, x, "-4", nil, t)
+	execVal(`/t/t2 - -/t/t1`, x, "5", nil, t)
+}
+
+func TestValueComparisons(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2 a="1">5</p2><p2 a="2">10</p2><p3>abc</p3><p4>true</p4></p1>`
+	execVal(`1 eq 1`, x, "true", nil, t)
+	execVal(`1 ne 1`, x, "false", nil, t)
+	execVal(`'abc' lt 'abd'`, x, "true", nil, t)
+	execVal(`'b' le 'abc'`, x, "false", nil, t)
+	execVal(`2 gt 10`, x, "false", nil, t)
+	execVal(`'2' gt '10'`, x, "true", nil, t)
+	execVal(`3 ge 3`, x, "true", nil, t)
+	execVal(`true() gt false()`, x, "true", nil, t)
+	execVal(`/p1/p2[1] lt 6`, x, "true", nil, t)
+	execVal(`/p1/p2[2] lt /p1/p2[1]`, x, "true", nil, t)
+	execVal(`/p1/p3 eq 'abc'`, x, "true", nil, t)
+	execVal(`/p1/p4 eq true()`, x, "true", nil, t)
+	execPath(`/p1/p2[@a eq 2]`, x, []string{`<p2 a="2">10</p2>`}, nil, t)
+	execPath(`/p1/p2[@b eq 2]`, x, []string{}, nil, t)
+	execSeq(`() eq 1`, x, []string{}, t)
+	execSeq(`/p1/p5 ne 1`, x, []string{}, t)
+	execVal(`xs:date('2001-01-01') lt xs:date('2001-01-02')`, x, "true", nil, t)
+	execVal(`/p1/p2 = 10`, x, "true", nil, t)
+	execVal(`1 eq 1 and 2 ne 1`, x, "true", nil, t)
+}
+
+func TestNodeComparisons(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2 a="1"/><p2 a="2"/><p3/></p1>`
+	execVal(`/p1/p2[1] is /p1/p2[@a = 1]`, x, "true", nil, t)
+	execVal(`/p1/p2[1] is /p1/p2[2]`, x, "false", nil, t)
+	execVal(`/p1/p2[1] << /p1/p3`, x, "true", nil, t)
+	execVal(`/p1/p2[1] >> /p1/p3`, x, "false", nil, t)
+	execVal(`/p1/p2[2]/@a >> /p1/p2[1]`, x, "true", nil, t)
+	execVal(`/p1 << /p1/p3`, x, "true", nil, t)
+	execPath(`/p1/*[. << /p1/p3]`, x, []string{`<p2 a="1"></p2>`, `<p2 a="2"></p2>`}, nil, t)
+	execSeq(`/p1/p4 is /p1`, x, []string{}, t)
+	execPath(`/p1/is`, `<p1><is/></p1>`, []string{`<is></is>`}, nil, t)
+}
@@ -0,0 +1,145 @@
+package goxpath
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+)
+
+func TestDateTimeConstructors(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>2004-05-12T18:17:15.125-05:00</p2></p1>`
+	execVal(`xs:dateTime('2002-10-10T12:00:00-05:00')`, x, "2002-10-10T12:00:00-05:00", nil, t)
+	execVal(`xs:dateTime(' 2002-10-10T12:00:00Z ')`, x, "2002-10-10T12:00:00Z", nil, t)
+	execVal(`xs:dateTime('2002-10-10T24:00:00')`, x, "2002-10-11T00:00:00", nil, t)
+	execVal(`xs:dateTime(/p1/p2)`, x, "2004-05-12T18:17:15.125-05:00", nil, t)
+	execVal(`xs:dateTime(xs:date('2002-10-10+13:00'))`, x, "2002-10-10T00:00:00+13:00", nil, t)
+	execVal(`xs:date('2002-10-10')`, x, "2002-10-10", nil, t)
+	execVal(`xs:date(xs:dateTime('2002-10-10T23:00:00Z'))`, x, "2002-10-10Z", nil, t)
+	execVal(`xs:date('-0044-03-15')`, x, "-0044-03-15", nil, t)
+	execVal(`xs:time('13:20:00.5+01:00')`, x, "13:20:00.5+01:00", nil, t)
+	execVal(`xs:time('24:00:00')`, x, "00:00:00", nil, t)
+	execVal(`xs:time(xs:dateTime(/p1/p2))`, x, "18:17:15.125-05:00", nil, t)
+	execVal(`xs:duration('P1Y2M3DT10H30M')`, x, "P1Y2M3DT10H30M", nil, t)
+	execVal(`xs:duration('P14M')`, x, "P1Y2M", nil, t)
+	execVal(`xs:duration('-PT90M1.5S')`, x, "-PT1H30M1.5S", nil, t)
+	execVal(`xs:duration('P0D')`, x, "PT0S", nil, t)
+	execSeq(`xs:date(())`, x, []string{}, t)
+}
+
+func TestDateTimeComponents(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>1999-05-31T13:20:00-05:00</p2></p1>`
+	execVal(`year-from-dateTime(/p1/p2)`, x, "1999", nil, t)
+	execVal(`month-from-dateTime(/p1/p2)`, x, "5", nil, t)
+	execVal(`day-from-dateTime(/p1/p2)`, x, "31", nil, t)
+	execVal(`hours-from-dateTime(/p1/p2)`, x, "13", nil, t)
+	execVal(`minutes-from-dateTime(/p1/p2)`, x, "20", nil, t)
+	execVal(`seconds-from-dateTime(xs:dateTime('1999-05-31T13:20:01.5'))`, x, "1.5", nil, t)
+	execVal(`timezone-from-dateTime(/p1/p2)`, x, "-PT5H", nil, t)
+	execSeq(`timezone-from-dateTime(xs:dateTime('1999-05-31T13:20:00'))`, x, []string{}, t)
+	execVal(`year-from-date(xs:date('1999-05-31'))`, x, "1999", nil, t)
+	execVal(`month-from-date('2000-01-01')`, x, "1", nil, t)
+	execVal(`day-from-date(xs:date(xs:dateTime(/p1/p2)))`, x, "31", nil, t)
+	execVal(`timezone-from-date(xs:date('1999-05-31Z'))`, x, "PT0S", nil, t)
+	execVal(`hours-from-time(xs:time('21:23:00'))`, x, "21", nil, t)
+	execVal(`minutes-from-time(xs:time('21:23:00'))`, x, "23", nil, t)
+	execVal(`seconds-from-time(xs:time('21:23:00.25'))`, x, "0.25", nil, t)
+	execVal(`timezone-from-time(xs:time('13:00:00+01:30'))`, x, "PT1H30M", nil, t)
+	execVal(`years-from-duration(xs:duration('P20Y15M'))`, x, "21", nil, t)
+	execVal(`months-from-duration(xs:duration('-P20Y18M'))`, x, "-6", nil, t)
+	execVal(`days-from-duration(xs:duration('P3DT10H'))`, x, "3", nil, t)
+	execVal(`hours-from-duration(xs:duration('P3DT10H'))`, x, "10", nil, t)
+	execVal(`minutes-from-duration(xs:duration('-P5DT12H30M'))`, x, "-30", nil, t)
+	execVal(`seconds-from-duration(xs:duration('P3DT10H12.5S'))`, x, "12.5", nil, t)
+	execSeq(`year-from-date(())`, x, []string{}, t)
+}
+
+func TestDateTimeArithmetic(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>2000-10-30T06:12:00-05:00</p2><p2>1999-11-28T09:00:00Z</p2></p1>`
+	execVal(`xs:dateTime('2000-10-30T11:12:00') + xs:duration('P1Y2M')`, x, "2001-12-30T11:12:00", nil, t)
+	execVal(`xs:dateTime('2000-10-30T11:12:00') + xs:duration('P3DT1H15M')`, x, "2000-11-02T12:27:00", nil, t)
+	execVal(`xs:dateTime('2000-10-30T11:12:00') - xs:duration('P3DT1H15M')`, x, "2000-10-27T09:57:00", nil, t)
+	execVal(`xs:date('2000-01-31') + xs:duration('P1M')`, x, "2000-02-29", nil, t)
+	execVal(`xs:date('2000-10-30') - xs:duration('P1Y2M')`, x, "1999-08-30", nil, t)
+	execVal(`xs:duration('P1D') + xs:date('2000-12-31')`, x, "2001-01-01", nil, t)
+	execVal(`xs:time('11:12:00') + xs:duration('P3DT1H15M')`, x, "12:27:00", nil, t)
+	execVal(`xs:time('23:00:00Z') + xs:duration('PT2H')`, x, "01:00:00Z", nil, t)
+	execVal(`xs:dateTime(/p1/p2[1]) - xs:dateTime(/p1/p2[2])`, x, "P337DT2H12M", nil, t)
+	execVal(`xs:date('2000-10-30') - xs:date('1999-11-28')`, x, "P337D", nil, t)
+	execVal(`xs:time('11:12:00Z') - xs:time('04:00:00-05:00')`, x, "PT2H12M", nil, t)
+	execVal(`xs:duration('P2Y11M') + xs:duration('P3Y3M')`, x, "P6Y2M", nil, t)
+	execVal(`xs:duration('P2DT12H5M') - xs:duration('P1DT10H')`, x, "P1DT2H5M", nil, t)
+	execVal(`xs:duration('P2Y11M') * 2.3`, x, "P6Y9M", nil, t)
+	execVal(`2 * xs:duration('PT1H30M')`, x, "PT3H", nil, t)
+	execVal(`-xs:duration('P1DT2H')`, x, "-P1DT2H", nil, t)
+	execVal(`-xs:duration('-P1Y')`, x, "P1Y", nil, t)
+	execVal(`xs:duration('P2DT53M11S') div 1.5`, x, "P1DT8H35M27.333333333S", nil, t)
+	execVal(`xs:duration('P3Y4M') div xs:duration('-P1Y4M')`, x, "-2.5", nil, t)
+	execVal(`xs:duration('P2DT53M11S') div xs:duration('P1DT10H')`, x, "1.4378349673202615", nil, t)
+	execVal(`xs:duration('P1Y1D') - xs:duration('P1Y2D')`, x, "-P1D", nil, t)
+}
+
+func TestDateTimeArithmeticErr(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1/>`
+	execErr(`xs:duration('P1M') - xs:duration('P1D')`, x, "The months and days of the resulting duration have different signs", nil, t)
+	execErr(`xs:duration('P100000D') + xs:duration('P100000D')`, x, "Duration is out of range", nil, t)
+	execErr(`xs:duration('P100000D') - xs:duration('-P100000D')`, x, "Duration is out of range", nil, t)
+	execErr(`xs:date('2500-01-01') - xs:date('1900-01-01')`, x, "Duration is out of range", nil, t)
+}
+
+func TestDateTimeComparison(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>2002-04-02T12:00:00-01:00</p2><p2>2002-04-02T23:00:00+06:00</p2></p1>`
+	execVal(`xs:dateTime(/p1/p2[1]) = xs:dateTime('2002-04-02T17:00:00+04:00')`, x, "true", nil, t)
+	execVal(`xs:dateTime(/p1/p2[1]) > xs:dateTime(/p1/p2[2])`, x, "false", nil, t)
+	execVal(`xs:dateTime(/p1/p2[1]) < xs:dateTime(/p1/p2[2])`, x, "true", nil, t)
+	execVal(`/p1/p2 = xs:dateTime('2002-04-02T17:00:00Z')`, x, "true", nil, t)
+	execVal(`/p1/p2 < xs:dateTime('2002-04-02T00:00:00Z')`, x, "false", nil, t)
+	execVal(`count(/p1/p2[. >= xs:dateTime('2002-04-02T14:00:00Z')])`, x, "1", nil, t)
+	execVal(`xs:date('2004-12-25Z') = xs:date('2004-12-25+07:00')`, x, "false", nil, t)
+	execVal(`xs:date('2004-12-25') <= xs:date('2004-12-25')`, x, "true", nil, t)
+	execVal(`xs:time('08:00:00+09:00') = xs:time('17:00:00-06:00')`, x, "false", nil, t)
+	execVal(`xs:time('21:30:00+10:30') = xs:time('06:00:00-05:00')`, x, "true", nil, t)
+	execVal(`xs:duration('P1Y') = xs:duration('P12M')`, x, "true", nil, t)
+	execVal(`xs:duration('PT24H') != xs:duration('P1D')`, x, "false", nil, t)
+	execVal(`xs:duration('P1Y') < xs:duration('P13M')`, x, "true", nil, t)
+	execVal(`xs:duration('PT1H') >= xs:duration('PT61M')`, x, "false", nil, t)
+	execVal(`(xs:date('2001-01-01'), xs:date('2002-01-01')) = xs:date('2002-01-01')`, x, "true", nil, t)
+}
+
+func TestCurrentDateTime(t *testing.T) {
+	x := xmltree.MustParseXML(bytes.NewBufferString(`<?xml version="1.0" encoding="UTF-8"?><p1/>`))
+	now := time.Date(2016, 3, 4, 10, 20, 30, 0, time.FixedZone("", -5*3600))
+	clock := func(o *Opts) { o.Now = func() time.Time { return now } }
+
+	tests := map[string]string{
+		`current-dateTime()`:                                       "2016-03-04T10:20:30-05:00",
+		`current-date()`:                                           "2016-03-04-05:00",
+		`current-time()`:                                           "10:20:30-05:00",
+		`year-from-dateTime(current-dateTime())`:                   "2016",
+		`current-date() - xs:date('2016-01-01-05:00')`:             "P63D",
+		`current-dateTime() = current-dateTime()`:                  "true",
+		`current-dateTime() > xs:dateTime('2016-03-04T15:00:00Z')`: "true",
+	}
+
+	for xp, exp := range tests {
+		res, err := MustParse(xp).Exec(x, clock)
+		if err != nil {
+			t.Error(xp, err)
+			continue
+		}
+		if res.String() != exp {
+			t.Error("Incorrect result for '"+xp+"':", res.String(), "Expecting:", exp)
+		}
+	}
+
+	start := time.Now()
+	res, err := MustParse(`current-dateTime()`).Exec(x)
+	if err != nil {
+		t.Error(err)
+	}
+	if res.(tree.DateTime).Time.Before(start.Add(-time.Second)) {
+		t.Error("current-dateTime() did not default to the current time")
+	}
+}
@@ -0,0 +1,213 @@
+package goxpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlele"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlnode"
+)
+
+func parseEdit(x string) *xmlele.XMLEle {
+	return xmltree.MustParseXML(bytes.NewBufferString(x)).(*xmlele.XMLEle)
+}
+
+func selectOne(xp string, n tree.Node, t *testing.T) tree.Node {
+	res := MustParse(xp).MustExec(n, func(o *Opts) { o.NS["t"] = "http://test" }).(tree.NodeSet)
+	if len(res) != 1 {
+		t.Fatalf("Expected one node for %s, found %d", xp, len(res))
+	}
+	return res[0]
+}
+
+//checkEdit checks the marshaled document, and checks that the positions of
+//the edited document are the same as the positions of the document when it is
+//parsed again.
+func checkEdit(n tree.Node, exp string, t *testing.T) {
+	str, err := MarshalStr(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str != exp {
+		t.Errorf("Incorrect document: %s.  Expecting: %s", str, exp)
+	}
+
+	xp := MustParse(`//node() | //@* | //namespace::*`)
+	edited := xp.MustExec(n).(tree.NodeSet)
+	parsed := xp.MustExec(xmltree.MustParseXML(bytes.NewBufferString(str))).(tree.NodeSet)
+
+	if len(edited) != len(parsed) {
+		t.Errorf("Incorrect number of nodes: %d.  Expecting: %d", len(edited), len(parsed))
+		return
+	}
+
+	for i := range edited {
+		if edited[i].Pos() != parsed[i].Pos() || edited[i].GetNodeType() != parsed[i].GetNodeType() {
+			t.Errorf("Incorrect position of node %d: %d.  Expecting: %d", i, edited[i].Pos(), parsed[i].Pos())
+		}
+	}
+}
+
+func TestEditInsert(t *testing.T) {
+	n := parseEdit(`<r><a x="1" y="2"/>text<b/></r>`)
+	r := selectOne(`/r`, n, t).(*xmlele.XMLEle)
+	b := selectOne(`/r/b`, n, t)
+
+	if err := r.InsertBefore(b, xmlele.NewElem(xml.Name{Local: "c"}, xml.Attr{Name: xml.Name{Local: "z"}, Value: "3"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.InsertAfter(selectOne(`/r/text()`, n, t), xmlnode.XMLNode{Token: xml.Comment("c"), NodeType: tree.NtComm}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.AppendChild(xmlnode.XMLNode{Token: xml.CharData("end"), NodeType: tree.NtChd}); err != nil {
+		t.Fatal(err)
+	}
+	checkEdit(n, `<r><a x="1" y="2"></a>text<!--c--><c z="3"></c><b></b>end</r>`, t)
+
+	if res := MustParse(`name(/r/*[last()])`).MustExec(n).String(); res != "b" {
+		t.Errorf("Incorrect last element: %s", res)
+	}
+	if res := MustParse(`name(/r/b/preceding-sibling::*[1])`).MustExec(n).String(); res != "c" {
+		t.Errorf("Incorrect preceding sibling: %s", res)
+	}
+}
+
+func TestEditMove(t *testing.T) {
+	n := parseEdit(`<r><a><b/></a><c/></r>`)
+	a := selectOne(`/r/a`, n, t).(*xmlele.XMLEle)
+	c := selectOne(`/r/c`, n, t).(*xmlele.XMLEle)
+
+	if err := c.AppendChild(selectOne(`/r/a/b`, n, t)); err != nil {
+		t.Fatal(err)
+	}
+	checkEdit(n, `<r><a></a><c><b></b></c></r>`, t)
+
+	if err := a.AppendChild(a); err == nil {
+		t.Error("Inserted an element into itself")
+	}
+	if err := c.AppendChild(n); err == nil {
+		t.Error("Inserted the root node")
+	}
+	if err := c.AppendChild(selectOne(`/r/a`, n, t).(*xmlele.XMLEle).GetParent()); err == nil {
+		t.Error("Inserted a parent")
+	}
+
+	r := n.Children[0].(*xmlele.XMLEle)
+	if err := r.InsertBefore(a, c); err != nil {
+		t.Fatal(err)
+	}
+	checkEdit(n, `<r><c><b></b></c><a></a></r>`, t)
+}
+
+func TestEditRemoveReplace(t *testing.T) {
+	n := parseEdit(`<r><a/>one<b/>two<!--c--></r>`)
+	r := selectOne(`/r`, n, t).(*xmlele.XMLEle)
+
+	if err := r.RemoveChild(selectOne(`/r/text()[2]`, n, t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReplaceChild(selectOne(`/r/a`, n, t), xmlnode.XMLNode{Token: xml.Comment("zero"), NodeType: tree.NtComm}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ReplaceChild(selectOne(`/r/comment()[2]`, n, t), selectOne(`/r/b`, n, t)); err != nil {
+		t.Fatal(err)
+	}
+	checkEdit(n, `<r><!--zero-->one<b></b></r>`, t)
+
+	if err := r.RemoveChild(xmlnode.XMLNode{Token: xml.CharData("one"), NodeType: tree.NtChd, NodePos: 100}); err == nil {
+		t.Error("Removed a node that was not found")
+	}
+}
+
+func TestEditAttrs(t *testing.T) {
+	n := parseEdit(`<r><a x="1" y="2"><b/></a></r>`)
+	a := selectOne(`/r/a`, n, t).(*xmlele.XMLEle)
+
+	a.SetAttr(xml.Name{Local: "x"}, "one")
+	a.SetAttr(xml.Name{Local: "z"}, "3")
+	if !a.RemoveAttr(xml.Name{Local: "y"}) {
+		t.Error("Attribute y not removed")
+	}
+	if a.RemoveAttr(xml.Name{Local: "y"}) {
+		t.Error("Attribute y removed twice")
+	}
+	checkEdit(n, `<r><a x="one" z="3"><b></b></a></r>`, t)
+
+	if err := a.AppendChild(selectOne(`/r/a/@x`, n, t)); err == nil {
+		t.Error("Inserted an attribute as a child")
+	}
+	if res := MustParse(`/r/a[@z = 3]/@x`).MustExec(n).String(); res != "one" {
+		t.Errorf("Incorrect attribute: %s", res)
+	}
+}
+
+func TestEditRename(t *testing.T) {
+	n := parseEdit(`<r xmlns:t="http://test"><a><b/></a><t:c/></r>`)
+	a := selectOne(`/r/a`, n, t).(*xmlele.XMLEle)
+
+	a.Rename(xml.Name{Space: "http://test", Local: "a2"})
+	if res := MustParse(`count(/r/t:a2/b)`).MustExec(n, func(o *Opts) { o.NS["t"] = "http://test" }).String(); res != "1" {
+		t.Errorf("Incorrect count after rename: %s", res)
+	}
+
+	a.Rename(xml.Name{Space: "http://other", Local: "a3"})
+	if res := MustParse(`/r/*[2]/namespace::*[. = 'http://other']`).MustExec(n).String(); res != "" {
+		t.Errorf("Namespace declared on the wrong element: %s", res)
+	}
+	if res := MustParse(`count(/r/*[1]/namespace::*[. = 'http://other'])`).MustExec(n).String(); res != "1" {
+		t.Errorf("Namespace not declared: %s", res)
+	}
+
+	c := selectOne(`/r/t:c`, n, t).(*xmlele.XMLEle)
+	c.Rename(xml.Name{Local: "c"})
+	if res := MustParse(`count(/r/c)`).MustExec(n).String(); res != "1" {
+		t.Errorf("Incorrect count after rename: %s", res)
+	}
+
+	moved := xmlele.NewElem(xml.Name{Space: "http://test", Local: "d"})
+	if err := c.AppendChild(moved); err != nil {
+		t.Fatal(err)
+	}
+	if res := MustParse(`count(/r/c/t:d)`).MustExec(n, func(o *Opts) { o.NS["t"] = "http://test" }).String(); res != "1" {
+		t.Errorf("Incorrect count after insert: %s", res)
+	}
+}
+
+func TestEditBatch(t *testing.T) {
+	n := parseEdit(`<r><a/><b>x</b><a/><c xmlns="http://p"/><a/></r>`)
+	as := MustParse(`//a`).MustExec(n).(tree.NodeSet)
+	r := selectOne(`/r`, n, t).(*xmlele.XMLEle)
+	pos := selectOne(`/r/b`, n, t).Pos()
+
+	err := n.Batch(func() error {
+		for i := len(as) - 1; i >= 0; i-- {
+			if err := r.RemoveChild(as[i]); err != nil {
+				return err
+			}
+		}
+
+		if selectOne(`/r/b`, n, t).Pos() != pos {
+			t.Error("The document was renumbered during the batch")
+		}
+
+		return r.AppendChild(xmlnode.XMLNode{Token: xml.Comment("end"), NodeType: tree.NtComm})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkEdit(n, `<r><b>x</b><c xmlns="http://p"></c><!--end--></r>`, t)
+
+	b := selectOne(`/r/b`, n, t).(*xmlele.XMLEle)
+	if err = r.RemoveChildren(b, selectOne(`/r/comment()`, n, t)); err != nil {
+		t.Fatal(err)
+	}
+	if b.Parent != nil {
+		t.Error("The removed element still has a parent")
+	}
+
+	checkEdit(n, `<r><c xmlns="http://p"></c></r>`, t)
+}
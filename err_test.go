@@ -3,9 +3,14 @@ package goxpath
 import (
 	"bytes"
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
 	"runtime/debug"
+	"strings"
 	"testing"
 
+	"github.com/ChrisTrenkamp/goxpath/parser"
 	"github.com/ChrisTrenkamp/goxpath/tree"
 	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
 	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlele"
@@ -232,3 +237,215 @@ func TestGoxpathNode(t *testing.T) {
 		t.Error("Incorrect result")
 	}
 }
+
+func TestExprErr(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1/>`
+	execErr(`for $x in (1, 2)`, x, "Missing 'return' in for expression", nil, t)
+	execErr(`some $x in (1, 2) return 1`, x, "Missing 'satisfies' in some expression", nil, t)
+	execErr(`let $x = 1 return $x`, x, "Missing ':=' in variable binding", nil, t)
+	execErr(`for $x in (1, 2) return`, x, "Unexpected end of XPath expression", nil, t)
+	execErr(`if (1) then 2`, x, "Missing 'else' in if expression", nil, t)
+	execErr(`if (1) 2 else 3`, x, "Missing 'then' in if expression", nil, t)
+	execErr(`for $x in (1, 2) return $y`, x, "Invalid variable 'y'", nil, t)
+	execErr(`if (dummy()) then 1 else 2`, x, "Cannot convert argument to boolean", nil, t)
+}
+
+func TestRegexpErr(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1/>`
+	execErr(`matches('a', 'a', 'q')`, x, "Invalid regular expression flags: q", nil, t)
+	execErr(`matches('a', '[a')`, x, "Invalid regular expression: Missing ] at end of character class", nil, t)
+	execErr(`matches('a', '(a)\1')`, x, "Invalid regular expression: Back-references are not supported", nil, t)
+	execErr(`matches('a', '\p{IsBasicLatin}')`, x, "Invalid regular expression: Unicode blocks are not supported: {IsBasicLatin}", nil, t)
+	execErr(`matches('a', '\q')`, x, "Invalid regular expression: Invalid escape \\q", nil, t)
+	execErr(`replace('a', 'x*', 'b')`, x, "Regular expression matches the zero-length string", nil, t)
+	execErr(`replace('a', 'a', '$')`, x, "Invalid replacement string: $", nil, t)
+	execErr(`tokenize('a', 'x?')`, x, "Regular expression matches the zero-length string", nil, t)
+	execErr(`matches('a')`, x, "Invalid number of arguments", nil, t)
+}
+
+func TestStringFnErr(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1/>`
+	execErr(`compare('a', 'b', 'http://example.com/collation')`, x, "Unsupported collation: http://example.com/collation", nil, t)
+	execErr(`codepoints-to-string((65, 0))`, x, "Invalid XML character: 0", nil, t)
+	execErr(`normalize-unicode('a', 'foo')`, x, "Unsupported normalization form: FOO", nil, t)
+	execErr(`upper-case()`, x, "Invalid number of arguments", nil, t)
+}
+
+func TestAggregateErr(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1/>`
+	execErr(`max(('a', 1))`, x, "Cannot compare strings with numbers", nil, t)
+	execErr(`avg(('a', 'b'))`, x, "Cannot convert object to a number", nil, t)
+	execErr(`sum('a')`, x, "Cannot convert object to a node-set", nil, t)
+	execErr(`abs(dummy())`, x, "Cannot convert object to a number", nil, t)
+}
+
+func TestDateTimeErr(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1/>`
+	execErr(`xs:date('2001-02-29')`, x, "Invalid xs:date value: 2001-02-29", nil, t)
+	execErr(`xs:dateTime('2001-02-01T25:00:00')`, x, "Invalid xs:dateTime value: 2001-02-01T25:00:00", nil, t)
+	execErr(`xs:time('12:00')`, x, "Invalid xs:time value: 12:00", nil, t)
+	execErr(`xs:duration('P1YT')`, x, "Invalid xs:duration value: P1YT", nil, t)
+	execErr(`xs:duration('P100000000D')`, x, "xs:duration value is out of range: P100000000D", nil, t)
+	execErr(`xs:duration('P10000D') * 1000`, x, "Duration is out of range", nil, t)
+	execErr(`xs:date(1)`, x, "Cannot convert object to xs:date", nil, t)
+	execErr(`xs:date(('2001-01-01', '2001-01-02'))`, x, "Expected a single item, but got 2", nil, t)
+	execErr(`xs:date('2001-01-01') = xs:time('12:00:00')`, x, "Cannot compare 2001-01-01 with 12:00:00", nil, t)
+	execErr(`xs:date('2001-01-01') + xs:date('2001-01-01')`, x, "Invalid operator + for date, time and duration values", nil, t)
+	execErr(`xs:duration('P1M') < xs:duration('P30D')`, x, "Cannot order durations with both year-month and day-time components", nil, t)
+	execErr(`xs:duration('P1D') * (0 div 0)`, x, "Cannot multiply a duration by NaN or infinity", nil, t)
+	execErr(`xs:date('2001-01-01') = 'foo'`, x, "Invalid xs:date value: foo", nil, t)
+}
+
+func TestValueCompErr(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2/><p2/></p1>`
+	execErr(`/p1/p2 eq 'a'`, x, "Cannot compare a sequence of more than one item", nil, t)
+	execErr(`'a' eq 1`, x, "Cannot compare a with 1", nil, t)
+	execErr(`/p1/p2[1] eq true()`, x, "Cannot convert '' to boolean", nil, t)
+	execErr(`1 is /p1`, x, "Cannot convert data type to node", nil, t)
+	execErr(`/p1/p2 << /p1`, x, "Cannot compare a sequence of more than one item", nil, t)
+}
+
+func TestSetOpErr(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2/><p2/></p1>`
+	execErr(`/p1 intersect 1`, x, "Cannot convert data type to node-set", nil, t)
+	execErr(`(1, 2) except /p1`, x, "Cannot convert data type to node-set", nil, t)
+	execErr(`1 to 2.5`, x, "Cannot convert 2.5 to an integer", nil, t)
+	execErr(`1 to 9000000000000000000`, x, "The range 1 to 9e+18 is too large", nil, t)
+	execErr(`(1, 2) to 3`, x, "Expected a single item, but got 2", nil, t)
+	execErr(`1 to dummy()`, x, "Cannot convert data type to number", nil, t)
+}
+
+func TestIDErr(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1/>`
+	execErr(`(1, 2)[id('a')]`, x, "Cannot find the document without a context node", nil, t)
+	execErr(`id()`, x, "Invalid number of arguments", nil, t)
+}
+
+func execPosErr(xp string, line, col int, tok string, expected []string, caret string, t *testing.T) {
+	_, err := ParseExec(xp, xmltree.MustParseXML(bytes.NewBufferString(`<?xml version="1.0" encoding="UTF-8"?><p1/>`)))
+	e, ok := err.(*parser.Error)
+	if !ok {
+		t.Errorf("Incorrect error type from XPath expr '%s': %#v", xp, err)
+		return
+	}
+
+	if e.Line != line || e.Col != col || e.Token != tok || !reflect.DeepEqual(e.Expected, expected) {
+		t.Errorf("Incorrect error position from XPath expr '%s': %d:%d %q %q.  Expecting: %d:%d %q %q", xp, e.Line, e.Col, e.Token, e.Expected, line, col, tok, expected)
+	}
+
+	if e.Caret() != caret {
+		t.Errorf("Incorrect caret from XPath expr '%s':\n%s\nExpecting:\n%s", xp, e.Caret(), caret)
+	}
+}
+
+func TestSyntaxErrPos(t *testing.T) {
+	execPosErr(`/test/chil::p2`, 1, 7, "chil", nil, "/test/chil::p2\n      ^", t)
+	execPosErr(`count(1`, 1, 8, "", []string{",", ")"}, "count(1\n       ^", t)
+	execPosErr(`/p1[1`, 1, 6, "", []string{"]"}, "/p1[1\n     ^", t)
+	execPosErr(`1 +`, 1, 4, "", []string{"expression"}, "1 +\n   ^", t)
+	execPosErr(`1 + )`, 1, 5, ")", nil, "1 + )\n    ^", t)
+	execPosErr(`@[.]exceptdiv`, 1, 2, "[", []string{"node test"}, "@[.]exceptdiv\n ^", t)
+	execPosErr(`for $x 1 return 2`, 1, 8, "1", []string{"in"}, "for $x 1 return 2\n       ^", t)
+	execPosErr("/p1[\n\tfoo::bar]", 2, 2, "foo", nil, "\tfoo::bar]\n\t^", t)
+	execPosErr("'ü' = 'ü' and /p1[a b]", 1, 21, "b", []string{"]"}, "'ü' = 'ü' and /p1[a b]\n                    ^", t)
+}
+
+func TestNestingDepth(t *testing.T) {
+	deep := map[string]string{
+		"parentheses": strings.Repeat("(", 1000000) + "1" + strings.Repeat(")", 1000000),
+		"negation":    strings.Repeat("-", 1000000) + "/p1",
+		"predicates":  strings.Repeat("/p1[", 1000000) + "1" + strings.Repeat("]", 1000000),
+		"functions":   strings.Repeat("count(", 1000000) + "1" + strings.Repeat(")", 1000000),
+	}
+
+	for name, xp := range deep {
+		_, err := Parse(xp)
+		if e, ok := err.(*parser.Error); !ok || e.Error() != "Expression is nested more than 1000 levels deep" {
+			t.Errorf("Incorrect error for nested %s: %v", name, err)
+		}
+	}
+
+	xp := strings.Repeat("(", 500) + "1" + strings.Repeat(")", 500)
+	if res := MustParse(xp).MustExec(xmltree.MustParseXML(bytes.NewBufferString(`<p1/>`))).String(); res != "1" {
+		t.Errorf("Incorrect result: %s", res)
+	}
+}
+
+func TestExecErrPos(t *testing.T) {
+	execPosErr(`/p1 = $x`, 1, 7, "$x", nil, "/p1 = $x\n      ^", t)
+	execPosErr("/p1[\n  unknown(1)]", 2, 3, "unknown", nil, "  unknown(1)]\n  ^", t)
+	execPosErr(`1 + count(1, 2)`, 1, 5, "count", nil, "1 + count(1, 2)\n    ^", t)
+	execPosErr(`(1, 2)/p1`, 1, 8, "p1", nil, "(1, 2)/p1\n       ^", t)
+}
+
+func TestErrUnwrap(t *testing.T) {
+	custErr := fmt.Errorf("custom error")
+	fn := func(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+		return nil, custErr
+	}
+
+	_, err := ParseExec(`1 + fail()`, xmltree.MustParseXML(bytes.NewBufferString(`<p1/>`)), func(o *Opts) {
+		o.Funcs[xml.Name{Local: "fail"}] = tree.Wrap{Fn: fn}
+	})
+
+	if !errors.Is(err, custErr) || err.Error() != "custom error" {
+		t.Error("Incorrect error:", err)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	opts := func(o *Opts) {
+		o.NS = map[string]string{"foo": "http://foo.com"}
+		o.Funcs = custFns
+		o.Vars[xml.Name{Local: "v"}] = nil
+	}
+
+	valid := []string{
+		`/foo:p1[dummy()]/@foo:attr`,
+		`foo:spaceDummy() = $v`,
+		`xs:date('2000-01-01')`,
+		`for $x in 1, $y in $x return $x + $y`,
+		`some $x in /p1 satisfies $x = $v`,
+		`substring('abc', 1)`,
+		`concat('a', 'b', 'c', 'd')`,
+		`/*:p1`,
+		`/xs:p1/@xs:attr`,
+	}
+
+	for _, i := range valid {
+		if _, err := Compile(i, opts); err != nil {
+			t.Errorf("Error compiling '%s': %s", i, err)
+		}
+	}
+
+	x := xmltree.MustParseXML(bytes.NewBufferString(`<p1 xmlns="http://www.w3.org/2001/XMLSchema"/>`))
+	if res := MustCompile(`count(/xs:p1)`).MustExec(x).String(); res != "1" {
+		t.Error("Incorrect result for a predeclared prefix:", res)
+	}
+
+	_, err := Compile("/bar:p1[unknown()]\n  | count(1, 2) | $x | (for $y in $y return $y) | bar:f()", opts)
+	errs, ok := err.(parser.ErrorList)
+	if !ok {
+		t.Errorf("Incorrect error type: %#v", err)
+		return
+	}
+
+	exp := `1:2: Unknown namespace prefix: bar
+1:9: Unknown function: unknown
+2:5: Invalid number of arguments for count: 2
+2:19: Invalid variable 'x'
+2:35: Invalid variable 'y'
+2:51: Unknown namespace prefix: bar`
+
+	if errs.Error() != exp {
+		t.Error("Incorrect errors:\n" + errs.Error() + "\nExpecting:\n" + exp)
+	}
+
+	if errs[0].Token != "bar" || errs[4].Token != "$y" || errs[5].Caret() != "  | count(1, 2) | $x | (for $y in $y return $y) | bar:f()\n                                                  ^" {
+		t.Errorf("Incorrect error details: %#v %#v", errs[0], errs[5])
+	}
+
+	if _, err := Compile(`/p1[`); err == nil || err.Error() != "Missing ] at end of predicate." {
+		t.Error("Incorrect syntax error:", err)
+	}
+}
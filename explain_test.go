@@ -0,0 +1,97 @@
+package goxpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+	"testing"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+)
+
+func TestExplain(t *testing.T) {
+	opts := func(o *Opts) {
+		o.NS["p"] = "http://p"
+		o.Funcs[xml.Name{Space: "http://p", Local: "f"}] = tree.Wrap{Fn: func(c tree.Ctx, args ...tree.Result) (tree.Result, error) { return args[0], nil }, NArgs: 1}
+	}
+
+	res := MustParse(`/a/b[1 + 1] | p:f(count(//c[@x])) | (let $y := 1 return $y)[$y]/..`).Explain(opts)
+	exp := `operator |
+  operator |
+    absolute path
+      step child::a
+      step child::b
+        predicate by position
+          number 2
+    function p:f (resolved to {http://p}f, custom, 1 arg)
+      function count (built-in, 1 arg)
+        absolute path
+          step descendant::c
+            predicate
+              relative path
+                step attribute::x
+  path
+    filter
+      let
+        $y :=
+          number 1
+        return
+          variable $y
+      predicate
+        variable $y
+    step parent::node()
+optimizations
+  [2] selects by position
+  1 + 1 folded to 2
+  //c rewritten to descendant::c`
+
+	if res != exp {
+		t.Error("Incorrect plan:\n" + res)
+	}
+
+	if res := MustParse(`q:g()`).Explain(); res != `function q:g (unknown namespace prefix, 0 args)` {
+		t.Error("Incorrect plan:", res)
+	}
+}
+
+func TestExplainExec(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><r><a><b/><b/></a><a><b/></a></r>`
+	xt := xmltree.MustParseXML(bytes.NewBufferString(x))
+
+	res, err := MustParse(`/r/a/b[last()] | /r/a[2]/x`).ExplainExec(xt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = regexp.MustCompile(`time=[^\]]*`).ReplaceAllString(res, "time=T")
+	exp := `operator |  [calls=1 items=2 time=T]
+  absolute path
+    step child::r  [calls=1 items=1 time=T]
+    step child::a  [calls=1 items=2 time=T]
+    step child::b  [calls=1 items=3 time=T]
+      predicate by position  [calls=1 items=2 time=T]
+        function last (built-in, 0 args)  [not evaluated]
+  absolute path
+    step child::r  [calls=1 items=1 time=T]
+    step child::a  [calls=1 items=2 time=T]
+      predicate by position  [calls=1 items=1 time=T]
+        number 2  [not evaluated]
+    step child::x  [calls=1 items=0 time=T]
+optimizations
+  [last()] selects by position
+  [2] selects by position`
+
+	if res != exp {
+		t.Error("Incorrect plan:\n" + res)
+	}
+
+	res, err = MustParse(`count(/r/a) + $x`).ExplainExec(xt)
+	if err == nil || err.Error() != "Invalid variable 'x'" {
+		t.Error("Expecting an error:", err)
+	}
+
+	if !regexp.MustCompile(`(?m)^  function count \(built-in, 1 arg\)  \[calls=1 items=1 `).MatchString(res) {
+		t.Error("The plan was not returned with the error:\n" + res)
+	}
+}
@@ -0,0 +1,50 @@
+package goxpath
+
+import (
+	"testing"
+)
+
+func TestFor(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>a</p2><p2>b</p2></p1>`
+	execSeq(`for $x in (1, 2, 3) return $x * 2`, x, []string{"2", "4", "6"}, t)
+	execSeq(`for $x in /p1/p2, $y in (1, 2) return concat($x, $y)`, x, []string{"a1", "a2", "b1", "b2"}, t)
+	execSeq(`for $x in /p1/p2 return $x/text()`, x, []string{"a", "b"}, t)
+	execSeq(`for $x in () return 1`, x, []string{}, t)
+	execSeq(`for $x in (1, 2) return $x, 3`, x, []string{"1", "2", "3"}, t)
+	execSeq(`(for $x in (1, 2) return ($x, $x))[3]`, x, []string{"2"}, t)
+	execSeq(`for $x in (1, 2) return for $y in (3, 4) return $x + $y`, x, []string{"4", "5", "5", "6"}, t)
+	execVal(`count(for $x in /p1/p2 return $x)`, x, "2", nil, t)
+	execPath(`for/p2`, `<for><p2/></for>`, []string{"<p2></p2>"}, nil, t)
+}
+
+func TestLet(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>1</p2><p2>2</p2></p1>`
+	execVal(`let $x := /p1/p2 return sum($x)`, x, "3", nil, t)
+	execVal(`let $x := 1, $y := $x + 1 return $x + $y`, x, "3", nil, t)
+	execVal(`let $x := 1 return let $x := $x + 1 return $x`, x, "2", nil, t)
+	execVal(`count(let $x := (1, 2, 3) return $x)`, x, "3", nil, t)
+	execPath(`/p1/p2[let $x := . return $x = 2]`, x, []string{"<p2>2</p2>"}, nil, t)
+}
+
+func TestQuantified(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>1</p2><p2>2</p2></p1>`
+	execVal(`some $x in /p1/p2 satisfies $x = 2`, x, "true", nil, t)
+	execVal(`some $x in /p1/p2 satisfies $x = 3`, x, "false", nil, t)
+	execVal(`every $x in /p1/p2 satisfies $x > 0`, x, "true", nil, t)
+	execVal(`every $x in /p1/p2 satisfies $x > 1`, x, "false", nil, t)
+	execVal(`some $x in () satisfies true()`, x, "false", nil, t)
+	execVal(`every $x in () satisfies false()`, x, "true", nil, t)
+	execVal(`some $x in (1, 2), $y in (2, 3) satisfies $x = $y`, x, "true", nil, t)
+	execVal(`every $x in (1, 2), $y in (2, 3) satisfies $x < $y`, x, "false", nil, t)
+}
+
+func TestIf(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>1</p2><p2>2</p2></p1>`
+	execVal(`if (/p1/p2) then 'yes' else 'no'`, x, "yes", nil, t)
+	execVal(`if (/p1/p3) then 'yes' else 'no'`, x, "no", nil, t)
+	execVal(`if (1 = 2) then 1 else if (2 = 2) then 2 else 3`, x, "2", nil, t)
+	execVal(`if (true()) then 1 else 2 + 3`, x, "1", nil, t)
+	execVal(`if (false()) then 1 else 2 + 3`, x, "5", nil, t)
+	execVal(`1 + (if (false()) then 1 else 2)`, x, "3", nil, t)
+	execVal(`count(/p1/p2[if (. = 1) then true() else false()])`, x, "1", nil, t)
+}
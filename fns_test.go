@@ -277,3 +277,156 @@ func TestPathAfterFunction(t *testing.T) {
 		t.Error("result not foo:", result.String())
 	}
 }
+
+func TestMatches(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1>abracadabra</p1>`
+	execVal(`matches(/p1, 'bra')`, x, "true", nil, t)
+	execVal(`matches(/p1, '^a.*a$')`, x, "true", nil, t)
+	execVal(`matches(/p1, '^bra')`, x, "false", nil, t)
+	execVal(`matches('ABC', 'abc', 'i')`, x, "true", nil, t)
+	execVal(`matches('a&#10;b', '^b$')`, x, "false", nil, t)
+	execVal("matches('a\nb', '^b$', 'm')", x, "true", nil, t)
+	execVal("matches('a\nb', 'a.b')", x, "false", nil, t)
+	execVal("matches('a\nb', 'a.b', 's')", x, "true", nil, t)
+	execVal(`matches('abc', 'a b c', 'x')`, x, "true", nil, t)
+	execVal(`matches('a b', '[ ]', 'x')`, x, "true", nil, t)
+	execVal(`matches('e', '^[a-z-[aeiou]]$')`, x, "false", nil, t)
+	execVal(`matches('f', '^[a-z-[aeiou]]$')`, x, "true", nil, t)
+	execVal(`matches('5', '^[\w-[\d]]$')`, x, "false", nil, t)
+	execVal(`matches('_foo:bar', '^\i\c*$')`, x, "true", nil, t)
+	execVal(`matches('1foo', '^\i\c*$')`, x, "false", nil, t)
+	execVal(`matches('a-b', '^[\c]+$')`, x, "true", nil, t)
+	execVal(`matches('٣', '^\d$')`, x, "true", nil, t)
+	execVal(`matches('a.b', 'a\.b')`, x, "true", nil, t)
+	execVal(`matches('Ab', '^\p{Lu}\P{Lu}$')`, x, "true", nil, t)
+}
+
+func TestReplace(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1>abracadabra</p1>`
+	execVal(`replace(/p1, 'bra', '*')`, x, "a*cada*", nil, t)
+	execVal(`replace(/p1, 'a.*a', '*')`, x, "*", nil, t)
+	execVal(`replace(/p1, 'a.*?a', '*')`, x, "*c*bra", nil, t)
+	execVal(`replace(/p1, 'a', '')`, x, "brcdbr", nil, t)
+	execVal(`replace(/p1, 'a(.)', 'a$1$1')`, x, "abbraccaddabbra", nil, t)
+	execVal(`replace('darted', '^(.*?)d(.*)$', '$1c$2')`, x, "carted", nil, t)
+	execVal(`replace('abc', '(b)', '$10')`, x, "ab0c", nil, t)
+	execVal(`replace('abc', 'b', '\$\\')`, x, "a$\\c", nil, t)
+	execVal(`replace('AAA', 'a', 'b', 'i')`, x, "bbb", nil, t)
+}
+
+func TestTokenize(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1>The cat sat</p1>`
+	execSeq(`tokenize(/p1, '\s+')`, x, []string{"The", "cat", "sat"}, t)
+	execSeq(`tokenize('1, 15, 24, 50', ',\s*')`, x, []string{"1", "15", "24", "50"}, t)
+	execSeq(`tokenize('1,,2', ',')`, x, []string{"1", "", "2"}, t)
+	execSeq(`tokenize('', ',')`, x, []string{}, t)
+	execSeq(`tokenize('aXbxc', 'x', 'i')`, x, []string{"a", "b", "c"}, t)
+	execVal(`count(tokenize(/p1, ' '))`, x, "3", nil, t)
+}
+
+func TestStringFns2(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>a</p2><p2>b</p2><p2>c</p2></p1>`
+	execVal(`upper-case('abCd0')`, x, "ABCD0", nil, t)
+	execVal(`lower-case('ABc!D')`, x, "abc!d", nil, t)
+	execVal(`ends-with('tattoo', 'tattoo')`, x, "true", nil, t)
+	execVal(`ends-with('tattoo', 'atto')`, x, "false", nil, t)
+	execVal(`ends-with('tattoo', 'too', 'http://www.w3.org/2005/xpath-functions/collation/codepoint')`, x, "true", nil, t)
+	execVal(`string-join(/p1/p2, ', ')`, x, "a, b, c", nil, t)
+	execVal(`string-join(('a', 1, true()), '-')`, x, "a-1-true", nil, t)
+	execVal(`string-join((), '-')`, x, "", nil, t)
+	execVal(`string-join(/p1/p2)`, x, "abc", nil, t)
+	execVal(`compare('abc', 'abc')`, x, "0", nil, t)
+	execVal(`compare('abc', 'abd')`, x, "-1", nil, t)
+	execVal(`compare('abd', 'abc')`, x, "1", nil, t)
+	execSeq(`compare((), 'abc')`, x, []string{}, t)
+	execVal(`codepoints-to-string((72, 105, 8364))`, x, "Hi€", nil, t)
+	execVal(`codepoints-to-string(())`, x, "", nil, t)
+	execSeq(`string-to-codepoints('Hi€')`, x, []string{"72", "105", "8364"}, t)
+	execSeq(`string-to-codepoints('')`, x, []string{}, t)
+	execVal("string-length(normalize-unicode('e\u0301'))", x, "1", nil, t)
+	execVal("string-length(normalize-unicode('é', 'NFD'))", x, "2", nil, t)
+	execVal("normalize-unicode('ﬁ', 'nfkc')", x, "fi", nil, t)
+	execVal("normalize-unicode('ﬁ', '')", x, "ﬁ", nil, t)
+	execVal(`encode-for-uri('http://www.example.com/00/Weather/CA/Los%20Angeles#ocean')`, x, "http%3A%2F%2Fwww.example.com%2F00%2FWeather%2FCA%2FLos%2520Angeles%23ocean", nil, t)
+	execVal(`encode-for-uri('~bébé')`, x, "~b%C3%A9b%C3%A9", nil, t)
+	execVal(`escape-html-uri('http://www.example.com/00/Weather/CA/Los Angeles#ocean')`, x, "http://www.example.com/00/Weather/CA/Los Angeles#ocean", nil, t)
+	execVal(`escape-html-uri('javascript:if (navigator.browserLanguage == "fr") window.open("http://www.example.com/~bébé");')`, x, `javascript:if (navigator.browserLanguage == "fr") window.open("http://www.example.com/~b%C3%A9b%C3%A9");`, nil, t)
+}
+
+func TestSubstringCodepoints(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1/>`
+	execVal(`substring('日本語です', 2, 2)`, x, "本語", nil, t)
+	execVal(`substring('日本語です', 4)`, x, "です", nil, t)
+	execVal(`string-length('日本語です')`, x, "5", nil, t)
+}
+
+func TestAggregateFns(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>3</p2><p2>1</p2><p2>5</p2><p2>1</p2><p3>a</p3></p1>`
+	execVal(`min(/p1/p2)`, x, "1", nil, t)
+	execVal(`max(/p1/p2)`, x, "5", nil, t)
+	execVal(`avg(/p1/p2)`, x, "2.5", nil, t)
+	execVal(`min((3, 4, 5))`, x, "3", nil, t)
+	execVal(`max((3, -4, 5.5))`, x, "5.5", nil, t)
+	execVal(`max(('a', 'c', 'b'))`, x, "c", nil, t)
+	execVal(`min(('b', 'c', 'a'))`, x, "a", nil, t)
+	execVal(`max(/p1/*)`, x, "NaN", nil, t)
+	execVal(`avg((1, /p1/p3))`, x, "NaN", nil, t)
+	execVal(`sum((1, 2, /p1/p2))`, x, "13", nil, t)
+	execVal(`sum(())`, x, "0", nil, t)
+	execSeq(`min(())`, x, []string{}, t)
+	execSeq(`max(/p1/p4)`, x, []string{}, t)
+	execSeq(`avg(())`, x, []string{}, t)
+	execSeq(`distinct-values(/p1/p2)`, x, []string{"3", "1", "5"}, t)
+	execSeq(`distinct-values((1, '1', 1.0, 2, 0 div 0, 0 div 0, true(), 1 = 1))`, x, []string{"1", "1", "2", "NaN", "true"}, t)
+	execVal(`count(distinct-values(/p1/*))`, x, "4", nil, t)
+}
+
+func TestNumFns2(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>-3.5</p2></p1>`
+	execVal(`abs(-10.5)`, x, "10.5", nil, t)
+	execVal(`abs(/p1/p2)`, x, "3.5", nil, t)
+	execVal(`abs(0 div 0)`, x, "NaN", nil, t)
+	execSeq(`abs(())`, x, []string{}, t)
+	execVal(`round-half-to-even(0.5)`, x, "0", nil, t)
+	execVal(`round-half-to-even(1.5)`, x, "2", nil, t)
+	execVal(`round-half-to-even(2.5)`, x, "2", nil, t)
+	execVal(`round-half-to-even(3.567812, 2)`, x, "3.57", nil, t)
+	execVal(`round-half-to-even(4.7564, -1)`, x, "0", nil, t)
+	execVal(`round-half-to-even(35612.25, -2)`, x, "35600", nil, t)
+	execVal(`round-half-to-even(/p1/p2)`, x, "-4", nil, t)
+	execVal(`round-half-to-even(1 div 0)`, x, "Infinity", nil, t)
+	execSeq(`round-half-to-even(())`, x, []string{}, t)
+}
+
+func TestID(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE book [
+  <!ELEMENT book ANY>
+  <!-- <!ATTLIST para id ID #IMPLIED> -->
+  <!ATTLIST chapter
+    id ID #REQUIRED
+    status (draft|final) "draft"
+    label CDATA #FIXED "ch">
+  <!ATTLIST link linkend IDREF #IMPLIED>
+  <!ATTLIST db:sect ref ID #IMPLIED>
+]>
+<book>
+  <chapter id="c1"><para id="p1" xml:id="x1">one</para><link linkend="c2"/></chapter>
+  <chapter id="c2"><para xml:id=" x2 ">two</para></chapter>
+  <sect xmlns:db="http://docbook.org/ns/docbook" ref="s1"/>
+  <para xml:id="c1">duplicate</para>
+</book>`
+	execPath(`id('c1')`, x, []string{`<chapter id="c1"><para id="p1" xml:id="x1">one</para><link linkend="c2"></link></chapter>`}, nil, t)
+	execVal(`id('x1')`, x, "one", nil, t)
+	execVal(`id('x2')`, x, "two", nil, t)
+	execPath(`id('p1')`, x, []string{}, nil, t)
+	execVal(`count(id('c2  x1 c1 missing'))`, x, "3", nil, t)
+	execVal(`name(id(' x1 c2 ')[1])`, x, "para", nil, t)
+	execVal(`name(id(' x1 c2 ')[2])`, x, "chapter", nil, t)
+	execVal(`id(//link/@linkend)/para`, x, "two", nil, t)
+	execVal(`count(id(('c1', 'c2')))`, x, "2", nil, t)
+	execVal(`count(id('s1'))`, x, "1", nil, t)
+	execVal(`count(//para[id('c2')])`, x, "3", nil, t)
+	execPath(`id('')`, x, []string{}, nil, t)
+	execVal(`count(id('x1 x2'))`, `<a><b xml:id="x1"/><c><d xml:id="x2"/></c></a>`, "2", nil, t)
+}
@@ -1,33 +1,174 @@
 package goxpath
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
+	"time"
 
+	"github.com/ChrisTrenkamp/goxpath/ast"
 	"github.com/ChrisTrenkamp/goxpath/internal/execxp"
 	"github.com/ChrisTrenkamp/goxpath/parser"
 	"github.com/ChrisTrenkamp/goxpath/tree"
 )
 
 //Opts defines namespace mappings and custom functions for XPath expressions.
+//Funcs and Vars are keyed by the expanded names of the functions and
+//variables.  The prefixes of function calls and variable references, such as
+//$cfg:timeout, are resolved with NS.  Now is the clock used by
+//current-dateTime(), which defaults to time.Now.  FuncResolver and VarResolver
+//resolve the functions and variables that are not in Funcs and Vars when the
+//expression references them.  MaxNodes, MaxSteps and MaxDepth limit the size
+//of the node-sets and sequences, the number of evaluation steps and the depth
+//of the nested expressions of an execution.  Zero is unlimited.  MaxDepth does
+//not apply to parsing, which has its own fixed limit on how deeply expressions
+//can be nested.
 type Opts struct {
-	NS    map[string]string
-	Funcs map[xml.Name]tree.Wrap
-	Vars  map[string]tree.Result
+	NS           map[string]string
+	Funcs        map[xml.Name]tree.Wrap
+	Vars         map[xml.Name]tree.Result
+	FuncResolver FunctionResolver
+	VarResolver  VariableResolver
+	Now          func() time.Time
+	MaxNodes     int
+	MaxSteps     int
+	MaxDepth     int
 }
 
+//FunctionResolver resolves functions by their expanded name and number of
+//arguments.  It is called when a function that is not built in, or in
+//Opts.Funcs, is called, and false is returned if the function does not exist.
+//Each function is only resolved once per execution.
+type FunctionResolver interface {
+	ResolveFunction(name xml.Name, arity int) (tree.Wrap, bool)
+}
+
+//FunctionResolverFunc is a function that implements FunctionResolver.
+type FunctionResolverFunc func(name xml.Name, arity int) (tree.Wrap, bool)
+
+//ResolveFunction calls fn.
+func (fn FunctionResolverFunc) ResolveFunction(name xml.Name, arity int) (tree.Wrap, bool) {
+	return fn(name, arity)
+}
+
+//VariableResolver resolves variables by their expanded name.  It is called
+//when a variable that is not in Opts.Vars is referenced, and a nil result is
+//returned if the variable does not exist.  Each variable is only resolved once
+//per execution.
+type VariableResolver interface {
+	ResolveVariable(name xml.Name) (tree.Result, error)
+}
+
+//VariableResolverFunc is a function that implements VariableResolver.
+type VariableResolverFunc func(name xml.Name) (tree.Result, error)
+
+//ResolveVariable calls fn.
+func (fn VariableResolverFunc) ResolveVariable(name xml.Name) (tree.Result, error) {
+	return fn(name)
+}
+
+//LimitError is returned when an execution exceeds one of the limits in Opts.
+//It is wrapped in a *parser.Error, so use errors.As to find it.
+type LimitError = execxp.LimitError
+
+//LimitKind is the limit that a LimitError exceeded.
+type LimitKind = execxp.LimitKind
+
+const (
+	//LimitNodes is exceeded when a node-set or sequence has more than
+	//Opts.MaxNodes items
+	LimitNodes = execxp.LimitNodes
+	//LimitSteps is exceeded when the execution takes more than Opts.MaxSteps
+	//steps
+	LimitSteps = execxp.LimitSteps
+	//LimitDepth is exceeded when the expressions are nested deeper than
+	//Opts.MaxDepth
+	LimitDepth = execxp.LimitDepth
+)
+
 //FuncOpts is a function wrapper for Opts.
 type FuncOpts func(*Opts)
 
-//XPathExec is the XPath executor, compiled from an XPath string
+//XPathExec is the XPath executor, compiled from an XPath string.
+//The expression is optimized for execution, but the AST and String methods
+//return the expression as it was written.
 type XPathExec struct {
-	n *parser.Node
+	n     *parser.Node
+	opt   *parser.Node
+	notes []string
+	src   string
 }
 
-//Parse parses the XPath expression, xp, returning an XPath executor.
+//Parse parses the XPath expression, xp, returning an XPath executor.  Syntax
+//errors are returned as a *parser.Error.
 func Parse(xp string) (XPathExec, error) {
 	n, err := parser.Parse(xp)
-	return XPathExec{n: n}, err
+	if err != nil {
+		return XPathExec{n: n, opt: n, src: xp}, err
+	}
+
+	opt, notes := execxp.Optimize(n)
+	return XPathExec{n: n, opt: opt, notes: notes, src: xp}, nil
+}
+
+func newOpts(opts []FuncOpts) *Opts {
+	o := &Opts{
+		NS:    make(map[string]string),
+		Funcs: make(map[xml.Name]tree.Wrap),
+		Vars:  make(map[xml.Name]tree.Result),
+		Now:   time.Now,
+	}
+	for _, i := range opts {
+		i(o)
+	}
+	return o
+}
+
+func (o *Opts) exec() execxp.Opts {
+	return execxp.Opts{
+		NS:           o.NS,
+		Funcs:        o.Funcs,
+		Vars:         o.Vars,
+		FuncResolver: o.FuncResolver,
+		VarResolver:  o.VarResolver,
+		Now:          o.Now(),
+		Limits:       execxp.Limits{MaxNodes: o.MaxNodes, MaxSteps: o.MaxSteps, MaxDepth: o.MaxDepth},
+	}
+}
+
+//Compile is like Parse, but it also checks the expression against the
+//options that it will be executed with.  Unknown functions, invalid numbers of
+//arguments, undeclared namespace prefixes and undeclared variables are
+//returned together as a parser.ErrorList.  Only the names of the variables in
+//Opts.Vars are used, so their values can be nil.  Opts.FuncResolver is used to
+//look up the functions, but Opts.VarResolver is not called, so unknown
+//variables are not reported if it is set.
+func Compile(xp string, opts ...FuncOpts) (XPathExec, error) {
+	ret, err := Parse(xp)
+	if err != nil {
+		return ret, err
+	}
+
+	o := newOpts(opts)
+	errs := execxp.Check(ret.n, o.exec())
+	if len(errs) == 0 {
+		return ret, nil
+	}
+
+	for i, e := range errs {
+		errs[i] = parser.NewError(xp, e.Offset, e.Token, e.Expected, e.Err)
+	}
+
+	return ret, errs
+}
+
+//MustCompile is like Compile, but panics instead of returning an error.
+func MustCompile(xp string, opts ...FuncOpts) XPathExec {
+	ret, err := Compile(xp, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return ret
 }
 
 //MustParse is like Parse, but panics instead of returning an error.
@@ -39,18 +180,68 @@ func MustParse(xp string) XPathExec {
 	return ret
 }
 
+//AST returns the typed AST of the compiled expression.
+func (xp XPathExec) AST() ast.Expr {
+	return ast.FromNode(xp.n)
+}
+
+//String returns the expression in its canonical form, with abbreviated steps.
+func (xp XPathExec) String() string {
+	return xp.Format(true)
+}
+
+//Format returns the expression in its canonical form.  If abbreviate is false,
+//every step is written with its axis.
+func (xp XPathExec) Format(abbreviate bool) string {
+	return ast.Format(xp.AST(), abbreviate)
+}
+
 //Exec executes the XPath expression, xp, against the tree, t, with the
-//namespace mappings, ns, and returns the result as a stringer.
+//namespace mappings, ns, and returns the result as a stringer.  Errors are
+//returned as a *parser.Error, which holds the position of the part of the
+//expression that failed.
 func (xp XPathExec) Exec(t tree.Node, opts ...FuncOpts) (tree.Result, error) {
-	o := &Opts{
-		NS:    make(map[string]string),
-		Funcs: make(map[xml.Name]tree.Wrap),
-		Vars:  make(map[string]tree.Result),
-	}
-	for _, i := range opts {
-		i(o)
+	return xp.ExecContext(context.Background(), t, opts...)
+}
+
+//ExecContext is like Exec, but the execution stops with the context's error
+//when ctx is done.  The error is wrapped in a *parser.Error, so use errors.Is
+//to check for context.Canceled or context.DeadlineExceeded.
+func (xp XPathExec) ExecContext(ctx context.Context, t tree.Node, opts ...FuncOpts) (tree.Result, error) {
+	o := newOpts(opts)
+	res, err := execxp.Exec(ctx, xp.opt, t, o.exec())
+	return res, xp.posErr(err)
+}
+
+//posErr fills in the XPath expression of an execution error.
+func (xp XPathExec) posErr(err error) error {
+	if e, ok := err.(*parser.Error); ok {
+		return parser.NewError(xp.src, e.Offset, e.Token, e.Expected, e.Err)
 	}
-	return execxp.Exec(xp.n, t, o.NS, o.Funcs, o.Vars)
+
+	return err
+}
+
+//Explain returns the evaluation plan of the expression.  Each line is a step,
+//predicate, function call, operator or value of the optimized expression,
+//indented under the expression that contains it, and the optimizations that
+//were applied are listed at the end.  The namespace mappings and custom
+//functions in the options are used to resolve the function calls.
+func (xp XPathExec) Explain(opts ...FuncOpts) string {
+	o := newOpts(opts)
+	return execxp.Explain(xp.opt, o.exec(), xp.notes, nil)
+}
+
+//ExplainExec executes the expression against the tree, t, and returns its
+//evaluation plan.  Each line of the plan is followed by the number of times it
+//was evaluated, the total number of items it returned, and the time it took.
+//If the execution fails, the plan is returned with the statistics up to the
+//error.
+func (xp XPathExec) ExplainExec(t tree.Node, opts ...FuncOpts) (string, error) {
+	o := newOpts(opts)
+	eo := o.exec()
+	_, prof, err := execxp.ExecProfile(context.Background(), xp.opt, t, eo)
+	return execxp.Explain(xp.opt, eo, xp.notes, prof), xp.posErr(err)
 }
 
 //ExecBool is like Exec, except it will attempt to convert the result to its boolean value.
@@ -90,12 +281,27 @@ func (xp XPathExec) ExecNode(t tree.Node, opts ...FuncOpts) (tree.NodeSet, error
 		return nil, err
 	}
 
-	n, ok := res.(tree.NodeSet)
-	if !ok {
-		return nil, fmt.Errorf("Cannot convert result to a node-set")
+	switch n := res.(type) {
+	case tree.NodeSet:
+		return n, nil
+	case tree.Sequence:
+		if ret, ok := n.NodeSet(); ok {
+			return ret, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Cannot convert result to a node-set")
+}
+
+//ExecSeq is like Exec, except it returns the result as a sequence.  Node-sets
+//become a sequence of nodes, and other values become a single-item sequence.
+func (xp XPathExec) ExecSeq(t tree.Node, opts ...FuncOpts) (tree.Sequence, error) {
+	res, err := xp.Exec(t, opts...)
+	if err != nil {
+		return nil, err
 	}
 
-	return n, nil
+	return tree.Items(res), nil
 }
 
 //MustExec is like Exec, but panics instead of returning an error.
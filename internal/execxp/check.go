@@ -0,0 +1,108 @@
+package execxp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+
+	"github.com/ChrisTrenkamp/goxpath/lexer"
+	"github.com/ChrisTrenkamp/goxpath/parser"
+)
+
+type checker struct {
+	o    Opts
+	res  *resolver
+	errs parser.ErrorList
+}
+
+//Check finds the unknown functions, invalid numbers of arguments, undeclared
+//namespace prefixes and unknown variables in the expression without executing
+//it.  Only the names of the variables are used, and unknown variables are not
+//reported if there is a variable resolver, since they are resolved when they
+//are referenced.  The errors are sorted by position, and the XPath expression
+//is filled in by the caller.
+func Check(n *parser.Node, o Opts) parser.ErrorList {
+	c := &checker{o: o, res: newResolver(o)}
+	c.check(n, map[xml.Name]bool{})
+	sort.Stable(c.errs)
+	return c.errs
+}
+
+func (c *checker) errorf(n *parser.Node, tok string, format string, args ...interface{}) {
+	c.errs = append(c.errs, &parser.Error{Offset: n.Pos, Token: tok, Err: fmt.Errorf(format, args...)})
+}
+
+//check walks the expression.  bound holds the variables of the enclosing for,
+//let, some and every expressions.
+func (c *checker) check(n *parser.Node, bound map[xml.Name]bool) {
+	if n == nil {
+		return
+	}
+
+	switch n.Val.Typ {
+	case lexer.XItemFunction:
+		c.checkFunc(n)
+	case lexer.XItemVariable:
+		c.checkVar(n, bound)
+	case lexer.XItemNCName:
+		if _, ok := nsPrefix(n.Val.Val, c.o.NS); !ok && n.Val.Val != "*" {
+			c.errorf(n, n.Val.Val, "Unknown namespace prefix: %s", n.Val.Val)
+		}
+	case lexer.XItemFor, lexer.XItemLet, lexer.XItemQuantifier:
+		inner := make(map[xml.Name]bool)
+		for k := range bound {
+			inner[k] = true
+		}
+
+		for b := n.Left; b != nil; b = b.Right {
+			c.check(b.Left, inner)
+
+			name, err := expandName(b.Val.Val, c.o.NS)
+			if err != nil {
+				c.errorf(b, "$"+b.Val.Val, "%s", err.Error())
+			}
+			inner[name] = true
+		}
+
+		c.check(n.Right, inner)
+		return
+	}
+
+	c.check(n.Left, bound)
+	c.check(n.Right, bound)
+}
+
+func (c *checker) checkVar(n *parser.Node, bound map[xml.Name]bool) {
+	name, err := expandName(n.Val.Val, c.o.NS)
+	if err != nil {
+		c.errorf(n, "$"+n.Val.Val, "%s", err.Error())
+		return
+	}
+
+	if _, ok := c.o.Vars[name]; !ok && !bound[name] && c.o.VarResolver == nil {
+		c.errorf(n, "$"+n.Val.Val, "Invalid variable '%s'", n.Val.Val)
+	}
+}
+
+func (c *checker) checkFunc(n *parser.Node) {
+	name, err := expandName(n.Val.Val, c.o.NS)
+	if err != nil {
+		c.errorf(n, n.Val.Val, "%s", err.Error())
+		return
+	}
+
+	nArgs := 0
+	for arg := n.Left; arg != nil; arg = arg.Right {
+		nArgs++
+	}
+
+	fn, ok := findFunc(name, nArgs, c.o.Funcs, c.res)
+	if !ok {
+		c.errorf(n, n.Val.Val, "Unknown function: %s", n.Val.Val)
+		return
+	}
+
+	if !fn.ValidArgs(nArgs) {
+		c.errorf(n, n.Val.Val, "Invalid number of arguments for %s: %d", n.Val.Val, nArgs)
+	}
+}
@@ -1,27 +1,105 @@
 package execxp
 
 import (
-	"encoding/xml"
+	"context"
+	"time"
 
+	"github.com/ChrisTrenkamp/goxpath/lexer"
 	"github.com/ChrisTrenkamp/goxpath/parser"
 	"github.com/ChrisTrenkamp/goxpath/tree"
 )
 
 //Exec executes the XPath expression, xp, against the tree, t, with the
-//namespace mappings, ns.
-func Exec(n *parser.Node, t tree.Node, ns map[string]string, fns map[xml.Name]tree.Wrap, v map[string]tree.Result) (tree.Result, error) {
-	f := xpFilt{
+//options, o.  The execution stops when ctx is done, or when it exceeds one of
+//the limits.  Errors are returned as a *parser.Error with the position of the
+//node that failed.
+func Exec(ctx context.Context, n *parser.Node, t tree.Node, o Opts) (tree.Result, error) {
+	f := newFilt(ctx, t, o)
+	return exec(&f, n)
+}
+
+func newFilt(ctx context.Context, t tree.Node, o Opts) xpFilt {
+	return xpFilt{
 		t:         t,
-		ns:        ns,
+		ns:        o.NS,
 		ctx:       tree.NodeSet{t},
-		fns:       fns,
-		variables: v,
+		fns:       o.Funcs,
+		variables: o.Vars,
+		now:       o.Now,
+		lim:       newLimiter(ctx, o.Limits),
+		res:       newResolver(o),
 	}
+}
 
-	return exec(&f, n)
+//Profile holds the statistics of the nodes of an expression that were
+//evaluated by ExecProfile.
+type Profile map[*parser.Node]*Stat
+
+//Stat is the number of times a node was evaluated, the total number of items
+//that it returned, and the total time it took, including the nodes inside of
+//it.
+type Stat struct {
+	Calls int
+	Items int
+	Time  time.Duration
+}
+
+//ExecProfile is like Exec, but it also returns the statistics of each node of
+//the expression.
+func ExecProfile(ctx context.Context, n *parser.Node, t tree.Node, o Opts) (tree.Result, Profile, error) {
+	f := newFilt(ctx, t, o)
+	f.prof = make(Profile)
+
+	res, err := exec(&f, n)
+	return res, f.prof, err
+}
+
+func (p Profile) exec(f *xpFilt, n *parser.Node) (*parser.Node, error) {
+	start := time.Now()
+	next, err := xfNode(f, n)
+
+	s, ok := p[n]
+	if !ok {
+		s = &Stat{}
+		p[n] = s
+	}
+
+	s.Calls++
+	s.Time += time.Since(start)
+	if err != nil {
+		return next, err
+	}
+
+	if nodes, ok := f.ctx.(tree.NodeSet); ok {
+		s.Items += len(nodes)
+	} else {
+		s.Items += len(tree.Items(f.ctx))
+	}
+
+	return next, err
 }
 
 func exec(f *xpFilt, n *parser.Node) (tree.Result, error) {
 	err := xfExec(f, n)
 	return f.ctx, err
 }
+
+//posErr adds the position of the node to err, unless a node inside of it
+//already added its position.  The XPath expression is filled in by the caller
+//of Exec.
+func posErr(n *parser.Node, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := err.(*parser.Error); ok {
+		return err
+	}
+
+	tok := n.Val.Val
+	if n.Val.Typ == lexer.XItemVariable {
+		tok = "$" + tok
+	}
+
+	return &parser.Error{Offset: n.Pos, Token: tok, Err: err}
+}
@@ -0,0 +1,277 @@
+package execxp
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChrisTrenkamp/goxpath/ast"
+	"github.com/ChrisTrenkamp/goxpath/internal/execxp/intfns"
+	"github.com/ChrisTrenkamp/goxpath/lexer"
+	"github.com/ChrisTrenkamp/goxpath/parser"
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/xconst"
+)
+
+type planner struct {
+	o    Opts
+	res  *resolver
+	prof Profile
+	buf  bytes.Buffer
+}
+
+//Explain returns the evaluation plan of the optimized expression, n, with the
+//options, o.  Each line is a step, predicate, function call, operator or
+//value, indented under the expression that contains it.  The optimizations,
+//notes, are listed at the end.  If prof is not nil, the lines are followed by
+//the statistics of the nodes.
+func Explain(n *parser.Node, o Opts, notes []string, prof Profile) string {
+	p := &planner{o: o, res: newResolver(o), prof: prof}
+	p.expr(n, 0)
+
+	if len(notes) > 0 {
+		p.buf.WriteString("optimizations\n")
+		for _, i := range notes {
+			p.buf.WriteString("  " + i + "\n")
+		}
+	}
+
+	return strings.TrimSuffix(p.buf.String(), "\n")
+}
+
+//line writes a line of the plan.  The statistics are taken from the nodes,
+//stat, that make up the line.  The time is the sum of the nodes, and the number
+//of calls and items are taken from the last one.
+func (p *planner) line(depth int, stat []*parser.Node, format string, args ...interface{}) {
+	p.buf.WriteString(strings.Repeat("  ", depth))
+	fmt.Fprintf(&p.buf, format, args...)
+
+	if p.prof != nil && len(stat) > 0 {
+		var dur time.Duration
+		for _, i := range stat {
+			if s, ok := p.prof[i]; ok {
+				dur += s.Time
+			}
+		}
+
+		if s, ok := p.prof[stat[len(stat)-1]]; ok {
+			fmt.Fprintf(&p.buf, "  [calls=%d items=%d time=%s]", s.Calls, s.Items, dur)
+		} else {
+			p.buf.WriteString("  [not evaluated]")
+		}
+	}
+
+	p.buf.WriteString("\n")
+}
+
+func (p *planner) expr(n *parser.Node, depth int) {
+	if n == nil {
+		p.line(depth, nil, "empty sequence")
+		return
+	}
+
+	switch n.Val.Typ {
+	case lexer.XItemAbsLocPath, lexer.XItemAbbrAbsLocPath:
+		p.line(depth, nil, "absolute path")
+		p.steps(n, depth+1)
+	case lexer.XItemRelLocPath, lexer.XItemAbbrRelLocPath:
+		p.line(depth, nil, "relative path")
+		p.steps(n, depth+1)
+	case lexer.XItemFunction, parser.Empty:
+		p.continued(n, depth)
+	case lexer.XItemOperator:
+		if n.Left == nil {
+			p.line(depth, []*parser.Node{n}, "negate")
+		} else {
+			p.line(depth, []*parser.Node{n}, "operator %s", n.Val.Val)
+			p.expr(n.Left, depth+1)
+		}
+		p.expr(n.Right, depth+1)
+	case lexer.XItemStrLit:
+		p.line(depth, []*parser.Node{n}, "string %s", ast.Format(ast.FromNode(n), true))
+	case lexer.XItemNumLit:
+		p.line(depth, []*parser.Node{n}, "number %s", n.Val.Val)
+	case lexer.XItemVariable:
+		p.line(depth, []*parser.Node{n}, "variable $%s%s", n.Val.Val, p.resolved(n.Val.Val))
+	case lexer.XItemFor, lexer.XItemLet, lexer.XItemQuantifier:
+		p.line(depth, []*parser.Node{n}, "%s", n.Val.Val)
+
+		bind, ret := "in", "return"
+		if n.Val.Typ == lexer.XItemLet {
+			bind = ":="
+		} else if n.Val.Typ == lexer.XItemQuantifier {
+			ret = "satisfies"
+		}
+
+		for b := n.Left; b != nil; b = b.Right {
+			p.line(depth+1, nil, "$%s %s", b.Val.Val, bind)
+			p.expr(b.Left, depth+2)
+		}
+
+		p.line(depth+1, nil, "%s", ret)
+		p.expr(n.Right, depth+2)
+	case lexer.XItemIf:
+		p.line(depth, []*parser.Node{n}, "if")
+		p.expr(n.Left, depth+1)
+		p.line(depth+1, nil, "then")
+		p.expr(n.Right.Left, depth+2)
+		p.line(depth+1, nil, "else")
+		p.expr(n.Right.Right, depth+2)
+	}
+}
+
+//continued writes a function call or parenthesized expression, and the
+//predicates and steps that follow it.
+func (p *planner) continued(n *parser.Node, depth int) {
+	rest := n.Right
+	for rest != nil && rest.Val.Typ == lexer.XItemPredicate {
+		rest = rest.Right
+	}
+
+	d := depth
+	if rest != nil {
+		p.line(depth, nil, "path")
+		d++
+	}
+
+	if n.Right != nil && n.Right.Val.Typ == lexer.XItemPredicate {
+		p.line(d, nil, "filter")
+		p.primary(n, d+1)
+		for pred := n.Right; pred != rest; pred = pred.Right {
+			p.predicate(pred, d+1)
+		}
+	} else {
+		p.primary(n, d)
+	}
+
+	if rest != nil {
+		p.steps(rest, depth+1)
+	}
+}
+
+func (p *planner) primary(n *parser.Node, depth int) {
+	if n.Val.Typ == lexer.XItemFunction {
+		p.line(depth, []*parser.Node{n}, "function %s", p.function(n))
+		for arg := n.Left; arg != nil; arg = arg.Right {
+			p.expr(arg.Left, depth+1)
+		}
+	} else if n.Left == nil {
+		p.line(depth, []*parser.Node{n}, "empty sequence")
+	} else {
+		p.expr(n.Left, depth)
+	}
+}
+
+//function describes the function that a call resolves to.
+func (p *planner) function(n *parser.Node) string {
+	nArgs := 0
+	for arg := n.Left; arg != nil; arg = arg.Right {
+		nArgs++
+	}
+
+	args := fmt.Sprintf("%d args", nArgs)
+	if nArgs == 1 {
+		args = "1 arg"
+	}
+
+	name, ok := qName(n.Val.Val, p.o.NS)
+	if !ok {
+		return fmt.Sprintf("%s (unknown namespace prefix, %s)", n.Val.Val, args)
+	}
+
+	resolved := ""
+	if name.Space != "" {
+		resolved = fmt.Sprintf("resolved to {%s}%s, ", name.Space, name.Local)
+	}
+
+	kind := "unknown function"
+	if _, ok := intfns.BuiltIn[name]; ok {
+		kind = "built-in"
+	} else if _, ok := findFunc(name, nArgs, p.o.Funcs, p.res); ok {
+		kind = "custom"
+	}
+
+	return fmt.Sprintf("%s (%s%s, %s)", n.Val.Val, resolved, kind, args)
+}
+
+//resolved describes the namespace of a prefixed variable.
+func (p *planner) resolved(qname string) string {
+	name, err := expandName(qname, p.o.NS)
+	if err != nil {
+		return " (unknown namespace prefix)"
+	}
+
+	if name.Space == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" (resolved to {%s}%s)", name.Space, name.Local)
+}
+
+func (p *planner) predicate(n *parser.Node, depth int) {
+	if _, ok := constPos(n.Left, 0); ok {
+		p.line(depth, []*parser.Node{n}, "predicate by position")
+	} else {
+		p.line(depth, []*parser.Node{n}, "predicate")
+	}
+
+	p.expr(n.Left, depth+1)
+}
+
+//steps writes the steps of a location path.  Steps are chained on the left,
+//except predicates, which continue on the right.
+func (p *planner) steps(n *parser.Node, depth int) {
+	axis, prefix := "", ""
+	var stat []*parser.Node
+
+	step := func(test string) {
+		if axis == "" {
+			axis = xconst.AxisChild
+		}
+
+		p.line(depth, stat, "step %s::%s", axis, test)
+		axis, prefix, stat = "", "", nil
+	}
+
+	for n != nil {
+		next := n.Left
+		stat = append(stat, n)
+
+		switch n.Val.Typ {
+		case lexer.XItemAbbrAbsLocPath, lexer.XItemAbbrRelLocPath:
+			axis = xconst.AxisDescendentOrSelf
+			step(xconst.NodeTypeNode + "()")
+		case lexer.XItemAbsLocPath, lexer.XItemRelLocPath:
+			stat = nil
+		case lexer.XItemAxis:
+			axis = n.Val.Val
+		case lexer.XItemAbbrAxis:
+			axis = xconst.AxisAttribute
+		case lexer.XItemNCName:
+			prefix = n.Val.Val + ":"
+		case lexer.XItemQName:
+			test := prefix + n.Val.Val
+			if axis == "" && prefix == "" && n.Val.Val == "." {
+				axis, test = xconst.AxisSelf, xconst.NodeTypeNode+"()"
+			} else if axis == "" && prefix == "" && n.Val.Val == ".." {
+				axis, test = xconst.AxisParent, xconst.NodeTypeNode+"()"
+			}
+			step(test)
+		case lexer.XItemNodeType:
+			test := n.Val.Val + "()"
+			if next != nil && next.Val.Typ == lexer.XItemProcLit {
+				test = fmt.Sprintf("%s(%s)", n.Val.Val, ast.Format(&ast.Literal{Value: tree.String(next.Val.Val)}, true))
+				stat = append(stat, next)
+				next = next.Left
+			}
+			step(test)
+		case lexer.XItemPredicate:
+			stat = nil
+			p.predicate(n, depth+1)
+			next = n.Right
+		}
+
+		n = next
+	}
+}
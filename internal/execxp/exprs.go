@@ -0,0 +1,145 @@
+package execxp
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/ChrisTrenkamp/goxpath/lexer"
+	"github.com/ChrisTrenkamp/goxpath/parser"
+	"github.com/ChrisTrenkamp/goxpath/tree"
+)
+
+type exprFn func(*xpFilt, *parser.Node) error
+
+//exprFns is set in init, since the expressions call back into xfExec.
+var exprFns map[lexer.XItemType]exprFn
+
+func init() {
+	exprFns = map[lexer.XItemType]exprFn{
+		lexer.XItemFor:        xfFor,
+		lexer.XItemLet:        xfLet,
+		lexer.XItemQuantifier: xfQuantifier,
+		lexer.XItemIf:         xfIf,
+	}
+}
+
+//bind returns a filter for evaluating n with the variable, name, set to val.
+func (f *xpFilt) bind(name xml.Name, val tree.Result) xpFilt {
+	vars := make(map[xml.Name]tree.Result, len(f.variables)+1)
+	for k, v := range f.variables {
+		vars[k] = v
+	}
+	vars[name] = val
+
+	ret := f.sub()
+	ret.variables = vars
+	return ret
+}
+
+func ebv(res tree.Result) (bool, error) {
+	b, ok := res.(tree.IsBool)
+	if !ok {
+		return false, fmt.Errorf("Cannot convert argument to boolean")
+	}
+
+	return bool(b.Bool()), nil
+}
+
+//forEach evaluates the bindings, b, and calls fn with a filter in which all of
+//the binding variables are set.  Iteration stops if fn returns false.
+func forEach(f *xpFilt, b *parser.Node, iterate bool, fn func(*xpFilt) (bool, error)) (bool, error) {
+	if b == nil {
+		return fn(f)
+	}
+
+	name, err := expandName(b.Val.Val, f.ns)
+	if err != nil {
+		return false, err
+	}
+
+	bf := f.sub()
+	res, err := exec(&bf, b.Left)
+	if err != nil {
+		return false, err
+	}
+
+	if !iterate {
+		vf := f.bind(name, res)
+		return forEach(&vf, b.Right, iterate, fn)
+	}
+
+	for _, i := range tree.Items(res) {
+		vf := f.bind(name, i)
+		cont, err := forEach(&vf, b.Right, iterate, fn)
+		if err != nil || !cont {
+			return cont, err
+		}
+	}
+
+	return true, nil
+}
+
+func xfFor(f *xpFilt, n *parser.Node) error {
+	ret := tree.Sequence{}
+
+	_, err := forEach(f, n.Left, true, func(vf *xpFilt) (bool, error) {
+		rf := vf.sub()
+		res, err := exec(&rf, n.Right)
+		ret = append(ret, tree.Items(res)...)
+		return true, err
+	})
+
+	f.ctx = ret
+	return err
+}
+
+func xfLet(f *xpFilt, n *parser.Node) error {
+	_, err := forEach(f, n.Left, false, func(vf *xpFilt) (bool, error) {
+		rf := vf.sub()
+		res, err := exec(&rf, n.Right)
+		f.ctx = res
+		return true, err
+	})
+
+	return err
+}
+
+func xfQuantifier(f *xpFilt, n *parser.Node) error {
+	every := n.Val.Val == "every"
+
+	cont, err := forEach(f, n.Left, true, func(vf *xpFilt) (bool, error) {
+		rf := vf.sub()
+		res, err := exec(&rf, n.Right)
+		if err != nil {
+			return false, err
+		}
+
+		b, err := ebv(res)
+		return b == every, err
+	})
+
+	f.ctx = tree.Bool(cont == every)
+	return err
+}
+
+func xfIf(f *xpFilt, n *parser.Node) error {
+	cf := f.sub()
+	cond, err := exec(&cf, n.Left)
+	if err != nil {
+		return err
+	}
+
+	b, err := ebv(cond)
+	if err != nil {
+		return err
+	}
+
+	branch := n.Right.Left
+	if !b {
+		branch = n.Right.Right
+	}
+
+	bf := f.sub()
+	f.ctx, err = exec(&bf, branch)
+	return err
+}
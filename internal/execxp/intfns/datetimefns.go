@@ -0,0 +1,224 @@
+package intfns
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+)
+
+//singleItem returns the only item of a function argument.  false is returned
+//if the argument is the empty sequence.
+func singleItem(arg tree.Result) (tree.Result, bool, error) {
+	items := tree.Items(arg)
+	if len(items) == 0 {
+		return nil, false, nil
+	}
+
+	if len(items) > 1 {
+		return nil, false, fmt.Errorf("Expected a single item, but got %d", len(items))
+	}
+
+	return items[0], true, nil
+}
+
+func toDateTime(arg tree.Result) (tree.Result, bool, error) {
+	item, ok, err := singleItem(arg)
+	if !ok {
+		return nil, false, err
+	}
+
+	switch t := item.(type) {
+	case tree.DateTime:
+		return t, true, nil
+	case tree.Date:
+		return tree.DateTime(t), true, nil
+	case tree.Time, tree.Duration, tree.Num, tree.Bool:
+		return nil, false, fmt.Errorf("Cannot convert object to xs:dateTime")
+	}
+
+	ret, err := tree.ParseDateTime(item.String())
+	return ret, err == nil, err
+}
+
+func toDate(arg tree.Result) (tree.Result, bool, error) {
+	item, ok, err := singleItem(arg)
+	if !ok {
+		return nil, false, err
+	}
+
+	switch t := item.(type) {
+	case tree.Date:
+		return t, true, nil
+	case tree.DateTime:
+		return tree.NewDate(t.Time, t.TZ), true, nil
+	case tree.Time, tree.Duration, tree.Num, tree.Bool:
+		return nil, false, fmt.Errorf("Cannot convert object to xs:date")
+	}
+
+	ret, err := tree.ParseDate(item.String())
+	return ret, err == nil, err
+}
+
+func toTime(arg tree.Result) (tree.Result, bool, error) {
+	item, ok, err := singleItem(arg)
+	if !ok {
+		return nil, false, err
+	}
+
+	switch t := item.(type) {
+	case tree.Time:
+		return t, true, nil
+	case tree.DateTime:
+		return tree.NewTime(t.Time, t.TZ), true, nil
+	case tree.Date, tree.Duration, tree.Num, tree.Bool:
+		return nil, false, fmt.Errorf("Cannot convert object to xs:time")
+	}
+
+	ret, err := tree.ParseTime(item.String())
+	return ret, err == nil, err
+}
+
+func toDuration(arg tree.Result) (tree.Result, bool, error) {
+	item, ok, err := singleItem(arg)
+	if !ok {
+		return nil, false, err
+	}
+
+	switch t := item.(type) {
+	case tree.Duration:
+		return t, true, nil
+	case tree.DateTime, tree.Date, tree.Time, tree.Num, tree.Bool:
+		return nil, false, fmt.Errorf("Cannot convert object to xs:duration")
+	}
+
+	ret, err := tree.ParseDuration(item.String())
+	return ret, err == nil, err
+}
+
+//constructor wraps the conversion functions as XPath functions.  The empty
+//sequence is returned for an empty argument.
+func constructor(conv func(tree.Result) (tree.Result, bool, error)) tree.Fn {
+	return func(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+		ret, ok, err := conv(args[0])
+		if !ok {
+			return tree.Sequence{}, err
+		}
+
+		return ret, nil
+	}
+}
+
+func currentDateTime(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	return tree.DateTime{Time: c.Now, TZ: true}, nil
+}
+
+func currentDate(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	return tree.NewDate(c.Now, true), nil
+}
+
+func currentTime(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	return tree.NewTime(c.Now, true), nil
+}
+
+func timezone(t time.Time, tz bool) tree.Result {
+	if !tz {
+		return tree.Sequence{}
+	}
+
+	_, offset := t.Zone()
+	return tree.Duration{Dur: time.Duration(offset) * time.Second}
+}
+
+//calendarTime returns the time of a dateTime, date or time value.
+func calendarTime(r tree.Result) (time.Time, bool) {
+	switch t := r.(type) {
+	case tree.DateTime:
+		return t.Time, t.TZ
+	case tree.Date:
+		return t.Time, t.TZ
+	case tree.Time:
+		return t.Time, t.TZ
+	}
+
+	return time.Time{}, false
+}
+
+//component creates a function that converts its argument with conv and
+//returns a component of the time with get.
+func component(conv func(tree.Result) (tree.Result, bool, error), get func(t time.Time, tz bool) tree.Result) tree.Wrap {
+	return tree.Wrap{
+		Fn: func(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+			val, ok, err := conv(args[0])
+			if !ok {
+				return tree.Sequence{}, err
+			}
+
+			return get(calendarTime(val)), nil
+		},
+		NArgs: 1,
+	}
+}
+
+func year(t time.Time, tz bool) tree.Result {
+	return tree.Num(t.Year())
+}
+
+func month(t time.Time, tz bool) tree.Result {
+	return tree.Num(t.Month())
+}
+
+func day(t time.Time, tz bool) tree.Result {
+	return tree.Num(t.Day())
+}
+
+func hours(t time.Time, tz bool) tree.Result {
+	return tree.Num(t.Hour())
+}
+
+func minutes(t time.Time, tz bool) tree.Result {
+	return tree.Num(t.Minute())
+}
+
+func seconds(t time.Time, tz bool) tree.Result {
+	return tree.Num(float64(t.Second()) + float64(t.Nanosecond())/1e9)
+}
+
+//durComponent creates a function that returns a component of an xs:duration.
+func durComponent(get func(d tree.Duration) tree.Num) tree.Wrap {
+	return tree.Wrap{
+		Fn: func(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+			val, ok, err := toDuration(args[0])
+			if !ok {
+				return tree.Sequence{}, err
+			}
+
+			return get(val.(tree.Duration)), nil
+		},
+		NArgs: 1,
+	}
+}
+
+func durYears(d tree.Duration) tree.Num {
+	return tree.Num(d.Months / 12)
+}
+
+func durMonths(d tree.Duration) tree.Num {
+	return tree.Num(d.Months % 12)
+}
+
+func durDays(d tree.Duration) tree.Num {
+	return tree.Num(d.Dur / (24 * time.Hour))
+}
+
+func durHours(d tree.Duration) tree.Num {
+	return tree.Num(d.Dur % (24 * time.Hour) / time.Hour)
+}
+
+func durMinutes(d tree.Duration) tree.Num {
+	return tree.Num(d.Dur % time.Hour / time.Minute)
+}
+
+func durSeconds(d tree.Duration) tree.Num {
+	return tree.Num((d.Dur % time.Minute).Seconds())
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 
 	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/xconst"
 )
 
 //BuiltIn contains the list of built-in XPath functions
@@ -19,6 +20,21 @@ var BuiltIn = map[xml.Name]tree.Wrap{
 	{Local: "string-length"}:    {Fn: stringLength, NArgs: 1, LastArgOpt: tree.Optional},
 	{Local: "normalize-space"}:  {Fn: normalizeSpace, NArgs: 1, LastArgOpt: tree.Optional},
 	{Local: "translate"}:        {Fn: translate, NArgs: 3},
+	//XPath 2.0 string functions
+	{Local: "upper-case"}:           {Fn: upperCase, NArgs: 1},
+	{Local: "lower-case"}:           {Fn: lowerCase, NArgs: 1},
+	{Local: "ends-with"}:            {Fn: endsWith, NArgs: 3, LastArgOpt: tree.Optional},
+	{Local: "string-join"}:          {Fn: stringJoin, NArgs: 2, LastArgOpt: tree.Optional},
+	{Local: "compare"}:              {Fn: compare, NArgs: 3, LastArgOpt: tree.Optional},
+	{Local: "codepoints-to-string"}: {Fn: codepointsToString, NArgs: 1},
+	{Local: "string-to-codepoints"}: {Fn: stringToCodepoints, NArgs: 1},
+	{Local: "normalize-unicode"}:    {Fn: normalizeUnicode, NArgs: 2, LastArgOpt: tree.Optional},
+	{Local: "encode-for-uri"}:       {Fn: encodeForURI, NArgs: 1},
+	{Local: "escape-html-uri"}:      {Fn: escapeHTMLURI, NArgs: 1},
+	//Regular expression functions
+	{Local: "matches"}:  {Fn: matches, NArgs: 3, LastArgOpt: tree.Optional},
+	{Local: "replace"}:  {Fn: replace, NArgs: 4, LastArgOpt: tree.Optional},
+	{Local: "tokenize"}: {Fn: tokenize, NArgs: 3, LastArgOpt: tree.Optional},
 	//Node set functions
 	{Local: "last"}:          {Fn: last},
 	{Local: "position"}:      {Fn: position},
@@ -26,6 +42,7 @@ var BuiltIn = map[xml.Name]tree.Wrap{
 	{Local: "local-name"}:    {Fn: localName, NArgs: 1, LastArgOpt: tree.Optional},
 	{Local: "namespace-uri"}: {Fn: namespaceURI, NArgs: 1, LastArgOpt: tree.Optional},
 	{Local: "name"}:          {Fn: name, NArgs: 1, LastArgOpt: tree.Optional},
+	{Local: "id"}:            {Fn: id, NArgs: 1},
 	//boolean functions
 	{Local: "boolean"}: {Fn: boolean, NArgs: 1},
 	{Local: "not"}:     {Fn: not, NArgs: 1},
@@ -38,4 +55,40 @@ var BuiltIn = map[xml.Name]tree.Wrap{
 	{Local: "floor"}:   {Fn: floor, NArgs: 1},
 	{Local: "ceiling"}: {Fn: ceiling, NArgs: 1},
 	{Local: "round"}:   {Fn: round, NArgs: 1},
+	//Aggregate and XPath 2.0 number functions
+	{Local: "min"}:                {Fn: _min, NArgs: 1},
+	{Local: "max"}:                {Fn: _max, NArgs: 1},
+	{Local: "avg"}:                {Fn: avg, NArgs: 1},
+	{Local: "abs"}:                {Fn: abs, NArgs: 1},
+	{Local: "distinct-values"}:    {Fn: distinctValues, NArgs: 1},
+	{Local: "round-half-to-even"}: {Fn: roundHalfToEven, NArgs: 2, LastArgOpt: tree.Optional},
+	//date and time functions
+	{Space: xconst.NSXS, Local: "dateTime"}: {Fn: constructor(toDateTime), NArgs: 1},
+	{Space: xconst.NSXS, Local: "date"}:     {Fn: constructor(toDate), NArgs: 1},
+	{Space: xconst.NSXS, Local: "time"}:     {Fn: constructor(toTime), NArgs: 1},
+	{Space: xconst.NSXS, Local: "duration"}: {Fn: constructor(toDuration), NArgs: 1},
+	{Local: "current-dateTime"}:             {Fn: currentDateTime},
+	{Local: "current-date"}:                 {Fn: currentDate},
+	{Local: "current-time"}:                 {Fn: currentTime},
+	{Local: "year-from-dateTime"}:           component(toDateTime, year),
+	{Local: "month-from-dateTime"}:          component(toDateTime, month),
+	{Local: "day-from-dateTime"}:            component(toDateTime, day),
+	{Local: "hours-from-dateTime"}:          component(toDateTime, hours),
+	{Local: "minutes-from-dateTime"}:        component(toDateTime, minutes),
+	{Local: "seconds-from-dateTime"}:        component(toDateTime, seconds),
+	{Local: "timezone-from-dateTime"}:       component(toDateTime, timezone),
+	{Local: "year-from-date"}:               component(toDate, year),
+	{Local: "month-from-date"}:              component(toDate, month),
+	{Local: "day-from-date"}:                component(toDate, day),
+	{Local: "timezone-from-date"}:           component(toDate, timezone),
+	{Local: "hours-from-time"}:              component(toTime, hours),
+	{Local: "minutes-from-time"}:            component(toTime, minutes),
+	{Local: "seconds-from-time"}:            component(toTime, seconds),
+	{Local: "timezone-from-time"}:           component(toTime, timezone),
+	{Local: "years-from-duration"}:          durComponent(durYears),
+	{Local: "months-from-duration"}:         durComponent(durMonths),
+	{Local: "days-from-duration"}:           durComponent(durDays),
+	{Local: "hours-from-duration"}:          durComponent(durHours),
+	{Local: "minutes-from-duration"}:        durComponent(durMinutes),
+	{Local: "seconds-from-duration"}:        durComponent(durSeconds),
 }
@@ -3,6 +3,7 @@ package intfns
 import (
 	"encoding/xml"
 	"fmt"
+	"strings"
 
 	"github.com/ChrisTrenkamp/goxpath/tree"
 )
@@ -16,12 +17,14 @@ func position(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
 }
 
 func count(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
-	n, ok := args[0].(tree.NodeSet)
-	if !ok {
-		return nil, fmt.Errorf("Cannot convert object to a node-set")
+	switch n := args[0].(type) {
+	case tree.NodeSet:
+		return tree.Num(len(n)), nil
+	case tree.Sequence:
+		return tree.Num(len(tree.Items(n))), nil
 	}
 
-	return tree.Num(len(n)), nil
+	return nil, fmt.Errorf("Cannot convert object to a node-set")
 }
 
 func localName(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
@@ -129,3 +132,68 @@ func name(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
 
 	return tree.String(ret), nil
 }
+
+func id(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	if len(c.NodeSet) == 0 {
+		return nil, fmt.Errorf("Cannot find the document without a context node")
+	}
+
+	ids := make(map[string]bool)
+	for _, i := range tree.Items(args[0]) {
+		for _, j := range strings.Fields(i.String()) {
+			ids[j] = true
+		}
+	}
+
+	var root tree.Elem
+	if elem, ok := c.NodeSet[0].(tree.Elem); ok && elem.GetNodeType() == tree.NtRoot {
+		root = elem
+	} else {
+		root = c.NodeSet[0].GetParent()
+		for root.GetNodeType() != tree.NtRoot {
+			root = root.GetParent()
+		}
+	}
+
+	ret := tree.NodeSet{}
+	if len(ids) > 0 {
+		idElem, _ := root.(tree.IDElem)
+		findIDs(root, idElem, ids, &ret)
+	}
+
+	return ret, nil
+}
+
+//findIDs searches the elements in document order for the ID's, removing them
+//from ids when they are found so only the first element with an ID is used.
+func findIDs(n tree.Elem, idElem tree.IDElem, ids map[string]bool, ret *tree.NodeSet) {
+	if n.GetNodeType() == tree.NtElem {
+		name := n.GetToken().(xml.StartElement).Name
+		found := false
+
+		for _, i := range n.GetAttrs() {
+			attr := i.GetToken().(xml.Attr)
+			isID := (attr.Name.Space == tree.XMLSpace && attr.Name.Local == "id") ||
+				(idElem != nil && idElem.IsIDAttr(name, attr.Name))
+
+			if val := strings.TrimSpace(attr.Value); isID && ids[val] {
+				delete(ids, val)
+				found = true
+			}
+		}
+
+		if found {
+			*ret = append(*ret, n)
+		}
+	}
+
+	for _, i := range n.GetChildren() {
+		if len(ids) == 0 {
+			return
+		}
+
+		if elem, ok := i.(tree.Elem); ok {
+			findIDs(elem, idElem, ids, ret)
+		}
+	}
+}
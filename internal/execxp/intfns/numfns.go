@@ -16,14 +16,215 @@ func number(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
 }
 
 func sum(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
-	n, ok := args[0].(tree.NodeSet)
-	if !ok {
+	nums, err := numItems(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ret := 0.0
+	for _, i := range nums {
+		ret += float64(i)
+	}
+
+	return tree.Num(ret), nil
+}
+
+//numItems returns the number values of the node-set's or sequence's items.
+//Nodes are converted to numbers, but strings are not.
+func numItems(r tree.Result) ([]tree.Num, error) {
+	switch r.(type) {
+	case tree.NodeSet, tree.Sequence:
+	default:
 		return nil, fmt.Errorf("Cannot convert object to a node-set")
 	}
 
+	items := tree.Items(r)
+	ret := make([]tree.Num, len(items))
+
+	for i := range items {
+		if tree.IsNode(items[i]) {
+			ret[i] = tree.GetNodeNum(items[i].(tree.NodeSet)[0])
+			continue
+		}
+
+		n, ok := items[i].(tree.Num)
+		if !ok {
+			return nil, fmt.Errorf("Cannot convert object to a number")
+		}
+		ret[i] = n
+	}
+
+	return ret, nil
+}
+
+func avg(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	nums, err := numItems(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nums) == 0 {
+		return tree.Sequence{}, nil
+	}
+
 	ret := 0.0
-	for _, i := range n {
-		ret += float64(tree.GetNodeNum(i))
+	for _, i := range nums {
+		ret += float64(i)
+	}
+
+	return tree.Num(ret / float64(len(nums))), nil
+}
+
+//minMax returns the smallest item if less is true, or else the largest.
+//Strings are compared as strings if all of the items are strings, and
+//anything else is compared as a number.  NaN is returned if any number is NaN.
+func minMax(arg tree.Result, less bool) (tree.Result, error) {
+	items := tree.Items(arg)
+	if len(items) == 0 {
+		return tree.Sequence{}, nil
+	}
+
+	strs := make([]string, 0, len(items))
+	for _, i := range items {
+		if s, ok := i.(tree.String); ok {
+			strs = append(strs, string(s))
+		}
+	}
+
+	if len(strs) == len(items) {
+		ret := strs[0]
+		for _, i := range strs[1:] {
+			if (i < ret) == less && i != ret {
+				ret = i
+			}
+		}
+		return tree.String(ret), nil
+	}
+
+	if len(strs) > 0 {
+		return nil, fmt.Errorf("Cannot compare strings with numbers")
+	}
+
+	nums, err := numItems(tree.Sequence(items))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := nums[0]
+	for _, i := range nums {
+		if math.IsNaN(float64(i)) {
+			return i, nil
+		}
+		if (i < ret) == less && i != ret {
+			ret = i
+		}
+	}
+
+	return ret, nil
+}
+
+func _min(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	return minMax(args[0], true)
+}
+
+func _max(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	return minMax(args[0], false)
+}
+
+func distinctValues(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	ret := tree.Sequence{}
+	strs := make(map[string]bool)
+	nums := make(map[tree.Num]bool)
+	bools := make(map[tree.Bool]bool)
+	nan := false
+
+	for _, i := range tree.Items(args[0]) {
+		if tree.IsNode(i) {
+			i = tree.String(i.String())
+		}
+
+		switch t := i.(type) {
+		case tree.Num:
+			if math.IsNaN(float64(t)) {
+				if nan {
+					continue
+				}
+				nan = true
+			} else if nums[t] {
+				continue
+			}
+			nums[t] = true
+		case tree.Bool:
+			if bools[t] {
+				continue
+			}
+			bools[t] = true
+		default:
+			if strs[i.String()] {
+				continue
+			}
+			strs[i.String()] = true
+		}
+
+		ret = append(ret, i)
+	}
+
+	return ret, nil
+}
+
+//optNum returns the number value of a function's optional argument.  false is
+//returned if the argument is the empty sequence.
+func optNum(arg tree.Result) (tree.Num, bool, error) {
+	if len(tree.Items(arg)) == 0 {
+		return 0, false, nil
+	}
+
+	n, ok := arg.(tree.IsNum)
+	if !ok {
+		return 0, false, fmt.Errorf("Cannot convert object to a number")
+	}
+
+	return n.Num(), true, nil
+}
+
+func abs(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	n, ok, err := optNum(args[0])
+	if !ok {
+		return tree.Sequence{}, err
+	}
+
+	return tree.Num(math.Abs(float64(n))), nil
+}
+
+func roundHalfToEven(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	n, ok, err := optNum(args[0])
+	if !ok {
+		return tree.Sequence{}, err
+	}
+
+	if math.IsNaN(float64(n)) || math.IsInf(float64(n), 0) || n == 0 {
+		return n, nil
+	}
+
+	prec := 0.0
+	if len(args) == 2 {
+		p, ok := args[1].(tree.IsNum)
+		if !ok {
+			return nil, fmt.Errorf("Cannot convert object to a number")
+		}
+		prec = math.Trunc(float64(p.Num()))
+	}
+
+	var ret float64
+	if prec >= 0 {
+		scale := math.Pow(10, prec)
+		ret = math.RoundToEven(float64(n)*scale) / scale
+	} else {
+		scale := math.Pow(10, -prec)
+		ret = math.RoundToEven(float64(n)/scale) * scale
+	}
+	if math.IsInf(ret, 0) || math.IsNaN(ret) {
+		return n, nil
 	}
 
 	return tree.Num(ret), nil
@@ -0,0 +1,107 @@
+package intfns
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+)
+
+func regexpArg(pattern tree.Result, flags []tree.Result) (*regexp.Regexp, error) {
+	f := ""
+	if len(flags) > 0 {
+		f = flags[0].String()
+	}
+
+	return compileRegexp(pattern.String(), f)
+}
+
+func matches(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	re, err := regexpArg(args[1], args[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	return tree.Bool(re.MatchString(args[0].String())), nil
+}
+
+func replace(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	re, err := regexpArg(args[1], args[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	if re.MatchString("") {
+		return nil, fmt.Errorf("Regular expression matches the zero-length string")
+	}
+
+	repl := []rune(args[2].String())
+	str := args[0].String()
+	ret := ""
+	prev := 0
+
+	for _, m := range re.FindAllStringSubmatchIndex(str, -1) {
+		ret += str[prev:m[0]]
+		prev = m[1]
+
+		for i := 0; i < len(repl); i++ {
+			switch repl[i] {
+			case '\\':
+				if i+1 == len(repl) || (repl[i+1] != '\\' && repl[i+1] != '$') {
+					return nil, fmt.Errorf("Invalid replacement string: %s", string(repl))
+				}
+				i++
+				ret += string(repl[i])
+			case '$':
+				if i+1 == len(repl) || repl[i+1] < '0' || repl[i+1] > '9' {
+					return nil, fmt.Errorf("Invalid replacement string: %s", string(repl))
+				}
+
+				//Further digits are only part of the group number if it
+				//stays valid.  Otherwise, they're literal characters.
+				i++
+				grp := int(repl[i] - '0')
+				for i+1 < len(repl) && repl[i+1] >= '0' && repl[i+1] <= '9' {
+					next := grp*10 + int(repl[i+1]-'0')
+					if next > re.NumSubexp() {
+						break
+					}
+					grp = next
+					i++
+				}
+
+				if grp <= re.NumSubexp() && m[grp*2] != -1 {
+					ret += str[m[grp*2]:m[grp*2+1]]
+				}
+			default:
+				ret += string(repl[i])
+			}
+		}
+	}
+
+	return tree.String(ret + str[prev:]), nil
+}
+
+func tokenize(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	re, err := regexpArg(args[1], args[2:])
+	if err != nil {
+		return nil, err
+	}
+
+	if re.MatchString("") {
+		return nil, fmt.Errorf("Regular expression matches the zero-length string")
+	}
+
+	str := args[0].String()
+	ret := tree.Sequence{}
+
+	if str == "" {
+		return ret, nil
+	}
+
+	for _, i := range re.Split(str, -1) {
+		ret = append(ret, tree.String(i))
+	}
+
+	return ret, nil
+}
@@ -1,11 +1,14 @@
 package intfns
 
 import (
+	"fmt"
 	"math"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/ChrisTrenkamp/goxpath/tree"
+	"golang.org/x/text/unicode/norm"
 )
 
 func _string(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
@@ -53,7 +56,7 @@ func substringAfter(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
 }
 
 func substring(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
-	str := args[0].String()
+	str := []rune(args[0].String())
 
 	bNum, bErr := round(c, args[1])
 	if bErr != nil {
@@ -71,7 +74,7 @@ func substring(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
 			b = 1
 		}
 
-		return tree.String(str[int(b)-1:]), nil
+		return tree.String(string(str[int(b)-1:])), nil
 	}
 
 	eNum, eErr := round(c, args[2])
@@ -94,7 +97,7 @@ func substring(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
 		e = tree.Num(len(str)) - b + 1
 	}
 
-	return tree.String(str[int(b)-1 : int(b+e)-1]), nil
+	return tree.String(string(str[int(b)-1 : int(b+e)-1])), nil
 }
 
 func stringLength(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
@@ -105,7 +108,7 @@ func stringLength(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
 		str = c.NodeSet.String()
 	}
 
-	return tree.Num(len(str)), nil
+	return tree.Num(utf8.RuneCountInString(str)), nil
 }
 
 var spaceTrim = regexp.MustCompile(`\s+`)
@@ -139,3 +142,154 @@ func translate(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
 
 	return tree.String(ret), nil
 }
+
+func upperCase(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	return tree.String(strings.ToUpper(args[0].String())), nil
+}
+
+func lowerCase(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	return tree.String(strings.ToLower(args[0].String())), nil
+}
+
+//codepointCollation is the only collation supported by the string functions.
+const codepointCollation = "http://www.w3.org/2005/xpath-functions/collation/codepoint"
+
+func checkCollation(args []tree.Result) error {
+	if len(args) > 0 && args[0].String() != codepointCollation {
+		return fmt.Errorf("Unsupported collation: %s", args[0].String())
+	}
+
+	return nil
+}
+
+func endsWith(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	if err := checkCollation(args[2:]); err != nil {
+		return nil, err
+	}
+
+	return tree.Bool(strings.HasSuffix(args[0].String(), args[1].String())), nil
+}
+
+func stringJoin(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	sep := ""
+	if len(args) == 2 {
+		sep = args[1].String()
+	}
+
+	items := tree.Items(args[0])
+	strs := make([]string, len(items))
+	for i := range items {
+		strs[i] = items[i].String()
+	}
+
+	return tree.String(strings.Join(strs, sep)), nil
+}
+
+func compare(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	if err := checkCollation(args[2:]); err != nil {
+		return nil, err
+	}
+
+	if len(tree.Items(args[0])) == 0 || len(tree.Items(args[1])) == 0 {
+		return tree.Sequence{}, nil
+	}
+
+	return tree.Num(strings.Compare(args[0].String(), args[1].String())), nil
+}
+
+func codepointsToString(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	ret := ""
+
+	for _, i := range tree.Items(args[0]) {
+		n, ok := i.(tree.IsNum)
+		if !ok {
+			return nil, fmt.Errorf("Cannot convert object to a number")
+		}
+
+		r := rune(n.Num())
+		if float64(r) != float64(n.Num()) || !isXMLChar(r) {
+			return nil, fmt.Errorf("Invalid XML character: %s", i.String())
+		}
+
+		ret += string(r)
+	}
+
+	return tree.String(ret), nil
+}
+
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		(r >= 0x20 && r <= 0xD7FF) ||
+		(r >= 0xE000 && r <= 0xFFFD) ||
+		(r >= 0x10000 && r <= 0x10FFFF)
+}
+
+func stringToCodepoints(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	ret := tree.Sequence{}
+
+	for _, i := range args[0].String() {
+		ret = append(ret, tree.Num(i))
+	}
+
+	return ret, nil
+}
+
+var normForms = map[string]norm.Form{
+	"NFC":  norm.NFC,
+	"NFD":  norm.NFD,
+	"NFKC": norm.NFKC,
+	"NFKD": norm.NFKD,
+}
+
+func normalizeUnicode(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	str := args[0].String()
+	form := "NFC"
+	if len(args) == 2 {
+		form = strings.ToUpper(strings.TrimSpace(args[1].String()))
+	}
+
+	if form == "" {
+		return tree.String(str), nil
+	}
+
+	f, ok := normForms[form]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported normalization form: %s", form)
+	}
+
+	return tree.String(f.String(str)), nil
+}
+
+//escapeURI percent-encodes the UTF-8 bytes of every character that the keep
+//function rejects.
+func escapeURI(str string, keep func(r rune) bool) string {
+	ret := ""
+
+	for _, i := range str {
+		if keep(i) {
+			ret += string(i)
+			continue
+		}
+
+		buf := make([]byte, utf8.RuneLen(i))
+		utf8.EncodeRune(buf, i)
+		for _, j := range buf {
+			ret += fmt.Sprintf("%%%02X", j)
+		}
+	}
+
+	return ret
+}
+
+func encodeForURI(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	return tree.String(escapeURI(args[0].String(), func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') ||
+			r == '-' || r == '_' || r == '.' || r == '~'
+	})), nil
+}
+
+func escapeHTMLURI(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+	return tree.String(escapeURI(args[0].String(), func(r rune) bool {
+		return r >= 32 && r <= 126
+	})), nil
+}
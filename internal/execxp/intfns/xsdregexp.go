@@ -0,0 +1,361 @@
+package intfns
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//runeSet is a sorted list of non-overlapping rune ranges, stored in pairs
+//like syntax.Regexp's Rune field.
+type runeSet []rune
+
+func (r runeSet) Len() int {
+	return len(r) / 2
+}
+
+func (r runeSet) Swap(i, j int) {
+	r[i*2], r[j*2], r[i*2+1], r[j*2+1] = r[j*2], r[i*2], r[j*2+1], r[i*2+1]
+}
+
+func (r runeSet) Less(i, j int) bool {
+	return r[i*2] < r[j*2]
+}
+
+func (r runeSet) normalize() runeSet {
+	sort.Sort(r)
+	ret := runeSet{}
+
+	for i := 0; i < len(r); i += 2 {
+		if len(ret) > 0 && r[i] <= ret[len(ret)-1]+1 {
+			if r[i+1] > ret[len(ret)-1] {
+				ret[len(ret)-1] = r[i+1]
+			}
+		} else {
+			ret = append(ret, r[i], r[i+1])
+		}
+	}
+
+	return ret
+}
+
+func (r runeSet) negate() runeSet {
+	ret := runeSet{}
+	next := rune(0)
+
+	for i := 0; i < len(r); i += 2 {
+		if r[i] > next {
+			ret = append(ret, next, r[i]-1)
+		}
+		next = r[i+1] + 1
+	}
+
+	if next <= unicode.MaxRune {
+		ret = append(ret, next, unicode.MaxRune)
+	}
+
+	return ret
+}
+
+func (r runeSet) subtract(s runeSet) runeSet {
+	//A - B is the same as not(not(A) or B)
+	return append(r.negate(), s...).normalize().negate()
+}
+
+func (r runeSet) String() string {
+	if len(r) == 0 {
+		return `[^\x{0}-\x{10FFFF}]`
+	}
+
+	ret := "["
+	for i := 0; i < len(r); i += 2 {
+		ret += fmt.Sprintf(`\x{%x}`, r[i])
+		if r[i+1] != r[i] {
+			ret += fmt.Sprintf(`-\x{%x}`, r[i+1])
+		}
+	}
+
+	return ret + "]"
+}
+
+//goClass uses Go's regular expression parser to find the runes of a
+//character class.
+func goClass(class string) runeSet {
+	re, err := syntax.Parse(class, syntax.Perl)
+	if err != nil || re.Op != syntax.OpCharClass {
+		return runeSet{}
+	}
+
+	return append(runeSet{}, re.Rune...)
+}
+
+//XML Schema's multi-character escapes.  \i and \c are approximated with the
+//Unicode categories that make up XML 1.0's name characters.
+var xsdEscapes = map[rune]string{
+	's': `[\x{20}\t\n\r]`,
+	'i': `[\p{L}\p{Nl}_:]`,
+	'c': `[\p{L}\p{Nl}\p{Nd}\p{Mn}\p{Mc}\p{Lm}\x{B7}._:-]`,
+	'd': `[\p{Nd}]`,
+	'w': `[^\p{P}\p{Z}\p{C}]`,
+}
+
+//xsdRegexp translates the XML Schema regular expression syntax used by the
+//XPath functions to Go's syntax and compiles it.
+type xsdRegexp struct {
+	pattern []rune
+	pos     int
+	flags   string
+}
+
+func compileRegexp(pattern, flags string) (*regexp.Regexp, error) {
+	for _, i := range flags {
+		if !strings.ContainsRune("imsx", i) {
+			return nil, fmt.Errorf("Invalid regular expression flags: %s", flags)
+		}
+	}
+
+	x := &xsdRegexp{pattern: []rune(pattern), flags: flags}
+	str, err := x.translate()
+	if err != nil {
+		return nil, fmt.Errorf("Invalid regular expression: %s", err.Error())
+	}
+
+	goFlags := ""
+	for _, i := range "ims" {
+		if strings.ContainsRune(flags, i) {
+			goFlags += string(i)
+		}
+	}
+	if goFlags != "" {
+		str = "(?" + goFlags + ")" + str
+	}
+
+	ret, err := regexp.Compile(str)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid regular expression: %s", err.Error())
+	}
+
+	return ret, nil
+}
+
+func (x *xsdRegexp) more() bool {
+	return x.pos < len(x.pattern)
+}
+
+func (x *xsdRegexp) next() rune {
+	r := x.pattern[x.pos]
+	x.pos++
+	return r
+}
+
+func (x *xsdRegexp) peek() rune {
+	if !x.more() {
+		return -1
+	}
+	return x.pattern[x.pos]
+}
+
+func (x *xsdRegexp) skipWS() {
+	if !strings.ContainsRune(x.flags, 'x') {
+		return
+	}
+
+	for x.more() && strings.ContainsRune(" \t\n\r", x.peek()) {
+		x.pos++
+	}
+}
+
+func (x *xsdRegexp) translate() (string, error) {
+	ret := ""
+
+	for x.skipWS(); x.more(); x.skipWS() {
+		r := x.next()
+
+		switch r {
+		case '\\':
+			set, str, err := x.escape()
+			if err != nil {
+				return "", err
+			}
+			if set != nil {
+				str = set.String()
+			}
+			ret += str
+		case '[':
+			set, err := x.class()
+			if err != nil {
+				return "", err
+			}
+			ret += set.String()
+		case '.':
+			if strings.ContainsRune(x.flags, 's') {
+				ret += "."
+			} else {
+				ret += `[^\n\r]`
+			}
+		case ']':
+			return "", fmt.Errorf("Unexpected ]")
+		default:
+			ret += string(r)
+		}
+	}
+
+	return ret, nil
+}
+
+//escape translates an escape sequence.  Multi-character escapes, such as \d,
+//are returned as a runeSet.  Otherwise, the translated string is returned.
+func (x *xsdRegexp) escape() (runeSet, string, error) {
+	if !x.more() {
+		return nil, "", fmt.Errorf("Missing character after \\")
+	}
+
+	r := x.next()
+
+	if class, ok := xsdEscapes[unicode.ToLower(r)]; ok {
+		set := goClass(class)
+		if unicode.IsUpper(r) {
+			set = set.negate()
+		}
+		return set, "", nil
+	}
+
+	switch r {
+	case 'p', 'P':
+		start := x.pos
+		for x.more() && x.peek() != '}' {
+			x.pos++
+		}
+		if !x.more() || start == x.pos || x.pattern[start] != '{' {
+			return nil, "", fmt.Errorf("Invalid character property")
+		}
+		x.pos++
+		prop := string(x.pattern[start:x.pos])
+		if strings.HasPrefix(prop, "{Is") {
+			return nil, "", fmt.Errorf("Unicode blocks are not supported: %s", prop)
+		}
+		set := goClass(`[\p` + prop + `]`)
+		if len(set) == 0 {
+			return nil, "", fmt.Errorf("Invalid character property: %s", prop)
+		}
+		if r == 'P' {
+			set = set.negate()
+		}
+		return set, "", nil
+	case 'n', 'r', 't':
+		return nil, `\` + string(r), nil
+	}
+
+	if r >= '0' && r <= '9' {
+		return nil, "", fmt.Errorf("Back-references are not supported")
+	}
+
+	if strings.ContainsRune(`\|.-^?*+{}()[]$`, r) {
+		return nil, `\` + string(r), nil
+	}
+
+	return nil, "", fmt.Errorf("Invalid escape \\%s", string(r))
+}
+
+//classChar returns a single character in a character class, or a set if it
+//is a multi-character escape.
+func (x *xsdRegexp) classChar() (rune, runeSet, error) {
+	r := x.next()
+	if r != '\\' {
+		return r, nil, nil
+	}
+
+	set, str, err := x.escape()
+	if err != nil || set != nil {
+		return 0, set, err
+	}
+
+	switch str {
+	case `\n`:
+		return '\n', nil, nil
+	case `\r`:
+		return '\r', nil, nil
+	case `\t`:
+		return '\t', nil, nil
+	}
+
+	return []rune(str)[1], nil, nil
+}
+
+//class translates a character class, including class subtraction, e.g.
+//[a-z-[aeiou]], into a runeSet.  The opening [ has already been read.
+func (x *xsdRegexp) class() (runeSet, error) {
+	set := runeSet{}
+	neg := false
+
+	if x.peek() == '^' {
+		x.next()
+		neg = true
+	}
+
+	first := true
+	for {
+		if !x.more() {
+			return nil, fmt.Errorf("Missing ] at end of character class")
+		}
+
+		if x.peek() == ']' && !first {
+			x.next()
+			break
+		}
+
+		if x.peek() == '-' && x.pos+1 < len(x.pattern) && x.pattern[x.pos+1] == '[' {
+			x.pos += 2
+			sub, err := x.class()
+			if err != nil {
+				return nil, err
+			}
+			if x.peek() != ']' {
+				return nil, fmt.Errorf("Class subtraction must be last in a character class")
+			}
+			x.next()
+
+			set = set.normalize()
+			if neg {
+				set = set.negate()
+			}
+			return set.subtract(sub.normalize()), nil
+		}
+
+		first = false
+		lo, loSet, err := x.classChar()
+		if err != nil {
+			return nil, err
+		}
+
+		if loSet != nil {
+			set = append(set, loSet...)
+			continue
+		}
+
+		if x.peek() == '-' && x.pos+1 < len(x.pattern) && x.pattern[x.pos+1] != ']' && x.pattern[x.pos+1] != '[' {
+			x.next()
+			hi, hiSet, err := x.classChar()
+			if err != nil {
+				return nil, err
+			}
+			if hiSet != nil || hi < lo {
+				return nil, fmt.Errorf("Invalid character range %s-%s", strconv.QuoteRune(lo), strconv.QuoteRune(hi))
+			}
+			set = append(set, lo, hi)
+		} else {
+			set = append(set, lo, lo)
+		}
+	}
+
+	set = set.normalize()
+	if neg {
+		set = set.negate()
+	}
+
+	return set, nil
+}
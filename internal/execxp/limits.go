@@ -0,0 +1,130 @@
+package execxp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+)
+
+//LimitKind is a resource limit of an execution.
+type LimitKind string
+
+const (
+	//LimitNodes is the maximum number of nodes in a node-set, or items in a
+	//sequence
+	LimitNodes LimitKind = "maximum node-set size"
+	//LimitSteps is the maximum number of evaluation steps
+	LimitSteps LimitKind = "maximum step count"
+	//LimitDepth is the maximum depth of nested expressions
+	LimitDepth LimitKind = "maximum recursion depth"
+)
+
+//LimitError is returned when an execution exceeds one of its limits.
+type LimitError struct {
+	Kind LimitKind
+	Max  int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("Exceeded the %s of %d", e.Kind, e.Max)
+}
+
+//Limits are the resource limits of an execution.  Zero values are unlimited.
+type Limits struct {
+	MaxNodes int
+	MaxSteps int
+	MaxDepth int
+}
+
+//cancelInterval is the number of items that loops which do not evaluate any
+//steps, such as ranges, create between checks of the context.
+const cancelInterval = 4096
+
+//limiter tracks the resources used by an execution.  It is shared by all of
+//the filters of the execution.  A nil limiter is unlimited and can't be
+//cancelled.
+type limiter struct {
+	Limits
+	ctx   context.Context
+	steps int
+	depth int
+}
+
+func newLimiter(ctx context.Context, lim Limits) *limiter {
+	return &limiter{Limits: lim, ctx: ctx}
+}
+
+//canceled returns the context's error if it is done.
+func (l *limiter) canceled() error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case <-l.ctx.Done():
+		return l.ctx.Err()
+	default:
+		return nil
+	}
+}
+
+//step counts an evaluation step.
+func (l *limiter) step() error {
+	if l == nil {
+		return nil
+	}
+
+	l.steps++
+	if l.MaxSteps > 0 && l.steps > l.MaxSteps {
+		return &LimitError{Kind: LimitSteps, Max: l.MaxSteps}
+	}
+
+	return l.canceled()
+}
+
+//enter is called when a nested expression is evaluated, and exit is called
+//when it is finished.
+func (l *limiter) enter() error {
+	if l == nil {
+		return nil
+	}
+
+	l.depth++
+	if l.MaxDepth > 0 && l.depth > l.MaxDepth {
+		return &LimitError{Kind: LimitDepth, Max: l.MaxDepth}
+	}
+
+	return nil
+}
+
+func (l *limiter) exit() {
+	if l != nil {
+		l.depth--
+	}
+}
+
+//items checks the size of a node-set or sequence.
+func (l *limiter) items(n int) error {
+	if l != nil && l.MaxNodes > 0 && n > l.MaxNodes {
+		return &LimitError{Kind: LimitNodes, Max: l.MaxNodes}
+	}
+
+	return nil
+}
+
+//result checks the size of a result.
+func (l *limiter) result(r tree.Result) error {
+	if l == nil || l.MaxNodes <= 0 {
+		return nil
+	}
+
+	switch t := r.(type) {
+	case tree.NodeSet:
+		return l.items(len(t))
+	case tree.Sequence:
+		return l.items(len(t))
+	}
+
+	return nil
+}
@@ -3,7 +3,10 @@ package execxp
 import (
 	"fmt"
 	"math"
+	"strings"
+	"time"
 
+	"github.com/ChrisTrenkamp/goxpath/internal/xsort"
 	"github.com/ChrisTrenkamp/goxpath/tree"
 )
 
@@ -80,6 +83,10 @@ func rightNodeOperator(left tree.Result, right tree.NodeSet, f *xpFilt, op strin
 }
 
 func equalsOperator(left, right tree.Result, f *xpFilt, op string) error {
+	if isTemporal(left) || isTemporal(right) {
+		return temporalOperator(left, right, f, op)
+	}
+
 	_, lOK := left.(tree.Bool)
 	_, rOK := right.(tree.Bool)
 
@@ -118,6 +125,10 @@ func equalsOperator(left, right tree.Result, f *xpFilt, op string) error {
 }
 
 func numberOperator(left, right tree.Result, f *xpFilt, op string) error {
+	if isTemporal(left) || isTemporal(right) {
+		return temporalOperator(left, right, f, op)
+	}
+
 	lt, lOK := left.(tree.IsNum)
 	rt, rOK := right.(tree.IsNum)
 	if !lOK || !rOK {
@@ -186,27 +197,495 @@ func andOrOperator(left, right tree.Result, f *xpFilt, op string) error {
 }
 
 func unionOperator(left, right tree.Result, f *xpFilt, op string) error {
-	lNode, lOK := left.(tree.NodeSet)
-	rNode, rOK := right.(tree.NodeSet)
+	lNode, lOK := toNodeSet(left)
+	rNode, rOK := toNodeSet(right)
 
 	if !lOK || !rOK {
 		return fmt.Errorf("Cannot convert data type to node-set")
 	}
 
-	uniq := make(map[int]tree.Node)
-	for _, i := range lNode {
-		uniq[i.Pos()] = i
+	res := make(tree.NodeSet, 0, len(lNode)+len(rNode))
+	res = append(res, lNode...)
+	f.ctx = docOrder(append(res, rNode...))
+
+	return nil
+}
+
+func intersectExceptOperator(left, right tree.Result, f *xpFilt, op string) error {
+	lNode, lOK := toNodeSet(left)
+	rNode, rOK := toNodeSet(right)
+
+	if !lOK || !rOK {
+		return fmt.Errorf("Cannot convert data type to node-set")
 	}
+
+	inRight := make(map[int]bool)
 	for _, i := range rNode {
-		uniq[i.Pos()] = i
+		inRight[i.Pos()] = true
 	}
 
-	res := make(tree.NodeSet, 0, len(uniq))
-	for _, v := range uniq {
-		res = append(res, v)
+	res := make(tree.NodeSet, 0, len(lNode))
+	for _, i := range lNode {
+		if inRight[i.Pos()] == (op == "intersect") {
+			res = append(res, i)
+		}
+	}
+
+	f.ctx = docOrder(res)
+
+	return nil
+}
+
+//docOrder removes duplicate nodes and puts the node-set in document order.
+func docOrder(n tree.NodeSet) tree.NodeSet {
+	uniq := make(map[int]bool)
+	res := make(tree.NodeSet, 0, len(n))
+
+	for _, i := range n {
+		if !uniq[i.Pos()] {
+			uniq[i.Pos()] = true
+			res = append(res, i)
+		}
+	}
+
+	xsort.SortNodes(res)
+
+	return res
+}
+
+func rangeOperator(left, right tree.Result, f *xpFilt, op string) error {
+	l, lOK, err := rangeInt(left)
+	if err != nil {
+		return err
+	}
+
+	r, rOK, err := rangeInt(right)
+	if err != nil {
+		return err
+	}
+
+	res := tree.Sequence{}
+	if lOK && rOK && l <= r {
+		//The size is counted in floating point, since it can overflow an int
+		if err := f.lim.items(int(math.Min(r-l+1, maxRangeInt))); err != nil {
+			return err
+		}
+
+		if math.Abs(l) > maxRangeInt || math.Abs(r) > maxRangeInt {
+			return fmt.Errorf("The range %s to %s is too large", tree.Num(l).String(), tree.Num(r).String())
+		}
+
+		for i := int(l); i <= int(r); i++ {
+			if (i-int(l))%cancelInterval == 0 {
+				if err := f.lim.canceled(); err != nil {
+					return err
+				}
+			}
+
+			res = append(res, tree.Num(i))
+		}
 	}
 
 	f.ctx = res
 
 	return nil
 }
+
+//maxRangeInt is the largest operand of a range, 2^53, since larger numbers
+//are not exact integers.
+const maxRangeInt = 1 << 53
+
+//rangeInt returns the integer value of a range operand.  false is returned if
+//the operand is the empty sequence.
+func rangeInt(r tree.Result) (float64, bool, error) {
+	items := tree.Items(r)
+	if len(items) == 0 {
+		return 0, false, nil
+	}
+
+	if len(items) > 1 {
+		return 0, false, fmt.Errorf("Expected a single item, but got %d", len(items))
+	}
+
+	item := items[0]
+	n, ok := item.(tree.IsNum)
+	if !ok {
+		return 0, false, fmt.Errorf("Cannot convert data type to number")
+	}
+
+	num := float64(n.Num())
+	if num != math.Trunc(num) || math.IsInf(num, 0) {
+		return 0, false, fmt.Errorf("Cannot convert %s to an integer", item.String())
+	}
+
+	return num, true, nil
+}
+
+func sequenceOperator(left, right tree.Result, f *xpFilt, op string) error {
+	res := append(tree.Sequence{}, tree.Items(left)...)
+	f.ctx = append(res, tree.Items(right)...)
+
+	return nil
+}
+
+func seqCompOperator(left, right tree.Result, f *xpFilt, op string) error {
+	for _, l := range tree.Items(left) {
+		for _, r := range tree.Items(right) {
+			if err := xfOperator(l, r, f, op); err != nil {
+				return err
+			}
+
+			if f.ctx.String() == tree.True {
+				return nil
+			}
+		}
+	}
+
+	f.ctx = tree.Bool(false)
+
+	return nil
+}
+
+//isTemporal returns true for date, time and duration values.
+func isTemporal(r tree.Result) bool {
+	switch r.(type) {
+	case tree.DateTime, tree.Date, tree.Time, tree.Duration:
+		return true
+	}
+
+	return false
+}
+
+//castTemporal converts strings to the same type as the other operand, so
+//node values can be compared with dates, times and durations.
+func castTemporal(r, other tree.Result) (tree.Result, error) {
+	if _, ok := r.(tree.String); !ok {
+		return r, nil
+	}
+
+	switch other.(type) {
+	case tree.DateTime:
+		return tree.ParseDateTime(r.String())
+	case tree.Date:
+		return tree.ParseDate(r.String())
+	case tree.Time:
+		return tree.ParseTime(r.String())
+	case tree.Duration:
+		return tree.ParseDuration(r.String())
+	}
+
+	return r, nil
+}
+
+//calendarTime returns the time of dateTime, date and time values.
+func calendarTime(r tree.Result) (time.Time, bool) {
+	switch t := r.(type) {
+	case tree.DateTime:
+		return t.Time, true
+	case tree.Date:
+		return t.Time, true
+	case tree.Time:
+		return t.Time, true
+	}
+
+	return time.Time{}, false
+}
+
+func temporalOperator(left, right tree.Result, f *xpFilt, op string) (err error) {
+	if left, err = castTemporal(left, right); err != nil {
+		return
+	}
+	if right, err = castTemporal(right, left); err != nil {
+		return
+	}
+
+	if booleanOps[op] {
+		return temporalCompOperator(left, right, f, op)
+	}
+
+	lt, lCal := calendarTime(left)
+	rt, rCal := calendarTime(right)
+	lDur, lIsDur := left.(tree.Duration)
+	rDur, rIsDur := right.(tree.Duration)
+	lNum, lIsNum := left.(tree.IsNum)
+	rNum, rIsNum := right.(tree.IsNum)
+
+	switch {
+	case op == "+" && lCal && rIsDur:
+		f.ctx = addTemporal(left, lt, rDur)
+	case op == "+" && lIsDur && rCal:
+		f.ctx = addTemporal(right, rt, lDur)
+	case op == "-" && lCal && rIsDur:
+		f.ctx = addTemporal(left, lt, rDur.Negate())
+	case op == "-" && lCal && rCal && sameType(left, right):
+		//Sub saturates instead of overflowing
+		dur := lt.Sub(rt)
+		if !rt.Add(dur).Equal(lt) {
+			return fmt.Errorf("Duration is out of range")
+		}
+		f.ctx = tree.Duration{Dur: dur}
+	case op == "+" && lIsDur && rIsDur:
+		return addDurations(lDur, rDur, f)
+	case op == "-" && lIsDur && rIsDur:
+		return addDurations(lDur, rDur.Negate(), f)
+	case op == "*" && lIsDur && rIsNum:
+		return scaleDuration(lDur, float64(rNum.Num()), f)
+	case op == "*" && lIsNum && rIsDur:
+		return scaleDuration(rDur, float64(lNum.Num()), f)
+	case op == "div" && lIsDur && rIsNum:
+		return scaleDuration(lDur, 1/float64(rNum.Num()), f)
+	case op == "div" && lIsDur && rIsDur:
+		if rDur.Months == 0 && lDur.Months == 0 && rDur.Dur != 0 {
+			f.ctx = tree.Num(float64(lDur.Dur) / float64(rDur.Dur))
+		} else if rDur.Dur == 0 && lDur.Dur == 0 && rDur.Months != 0 {
+			f.ctx = tree.Num(float64(lDur.Months) / float64(rDur.Months))
+		} else {
+			return fmt.Errorf("Cannot divide durations")
+		}
+	default:
+		return fmt.Errorf("Invalid operator %s for date, time and duration values", op)
+	}
+
+	return nil
+}
+
+func sameType(left, right tree.Result) bool {
+	return fmt.Sprintf("%T", left) == fmt.Sprintf("%T", right)
+}
+
+//addTemporal adds the duration to t, returning the same type as r.  Times
+//only use the day and time components of the duration, and wrap at midnight.
+func addTemporal(r tree.Result, t time.Time, d tree.Duration) tree.Result {
+	switch v := r.(type) {
+	case tree.DateTime:
+		return tree.DateTime{Time: tree.AddDuration(t, d), TZ: v.TZ}
+	case tree.Date:
+		return tree.NewDate(tree.AddDuration(t, d), v.TZ)
+	}
+
+	return tree.NewTime(t.Add(d.Dur), r.(tree.Time).TZ)
+}
+
+//addDurations adds the durations.  The months and the days of the result must
+//have the same sign, since there is no duration between them.
+func addDurations(l, r tree.Duration, f *xpFilt) error {
+	months := float64(l.Months) + float64(r.Months)
+	dur := float64(l.Dur) + float64(r.Dur)
+	if math.Abs(months) > math.MaxInt32 || math.Abs(dur) >= math.MaxInt64 {
+		return fmt.Errorf("Duration is out of range")
+	}
+
+	ret := tree.Duration{Months: int(months), Dur: l.Dur + r.Dur}
+	if ret.Months < 0 && ret.Dur > 0 || ret.Months > 0 && ret.Dur < 0 {
+		return fmt.Errorf("The months and days of the resulting duration have different signs")
+	}
+
+	f.ctx = ret
+	return nil
+}
+
+func scaleDuration(d tree.Duration, n float64, f *xpFilt) error {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return fmt.Errorf("Cannot multiply a duration by NaN or infinity")
+	}
+
+	months := math.Floor(float64(d.Months)*n + 0.5)
+	dur := math.Floor(float64(d.Dur)*n + 0.5)
+	if math.Abs(months) > math.MaxInt32 || math.Abs(dur) >= math.MaxInt64 {
+		return fmt.Errorf("Duration is out of range")
+	}
+
+	f.ctx = tree.Duration{
+		Months: int(months),
+		Dur:    time.Duration(dur),
+	}
+
+	return nil
+}
+
+func temporalCompOperator(left, right tree.Result, f *xpFilt, op string) error {
+	if !sameType(left, right) {
+		return fmt.Errorf("Cannot compare %s with %s", left.String(), right.String())
+	}
+
+	cmp := 0
+
+	if lt, ok := calendarTime(left); ok {
+		rt, _ := calendarTime(right)
+		if lt.Before(rt) {
+			cmp = -1
+		} else if lt.After(rt) {
+			cmp = 1
+		}
+	} else {
+		l, r := left.(tree.Duration), right.(tree.Duration)
+		if eqOps[op] {
+			if l != r {
+				cmp = 1
+			}
+		} else if l.Months == 0 && r.Months == 0 {
+			cmp = compareInts(int64(l.Dur), int64(r.Dur))
+		} else if l.Dur == 0 && r.Dur == 0 {
+			cmp = compareInts(int64(l.Months), int64(r.Months))
+		} else {
+			return fmt.Errorf("Cannot order durations with both year-month and day-time components")
+		}
+	}
+
+	switch op {
+	case "=":
+		f.ctx = tree.Bool(cmp == 0)
+	case "!=":
+		f.ctx = tree.Bool(cmp != 0)
+	case "<":
+		f.ctx = tree.Bool(cmp < 0)
+	case "<=":
+		f.ctx = tree.Bool(cmp <= 0)
+	case ">":
+		f.ctx = tree.Bool(cmp > 0)
+	case ">=":
+		f.ctx = tree.Bool(cmp >= 0)
+	}
+
+	return nil
+}
+
+func compareInts(l, r int64) int {
+	if l < r {
+		return -1
+	} else if l > r {
+		return 1
+	}
+	return 0
+}
+
+//singleItem returns the only item of a value or node comparison's operand.
+//false is returned if the operand is the empty sequence.
+func singleItem(r tree.Result) (tree.Result, bool, error) {
+	items := tree.Items(r)
+	if len(items) == 0 {
+		return nil, false, nil
+	}
+
+	if len(items) > 1 {
+		return nil, false, fmt.Errorf("Cannot compare a sequence of more than one item")
+	}
+
+	return items[0], true, nil
+}
+
+//castUntyped converts a node's value to the type of the other operand in a
+//value comparison.  The values of two nodes are compared as strings.
+func castUntyped(r, other tree.Result) (tree.Result, error) {
+	if !tree.IsNode(r) {
+		return r, nil
+	}
+
+	str := tree.String(r.String())
+
+	switch other.(type) {
+	case tree.Num:
+		return str.Num(), nil
+	case tree.Bool:
+		switch strings.TrimSpace(string(str)) {
+		case "true", "1":
+			return tree.Bool(true), nil
+		case "false", "0":
+			return tree.Bool(false), nil
+		}
+		return nil, fmt.Errorf("Cannot convert '%s' to boolean", str)
+	}
+
+	return castTemporal(str, other)
+}
+
+//valueCompOperator compares two single items.  The result is the empty
+//sequence if either operand is empty.  op is the equivalent general
+//comparison operator.
+func valueCompOperator(left, right tree.Result, f *xpFilt, op string) error {
+	l, lOK, err := singleItem(left)
+	if err != nil {
+		return err
+	}
+
+	r, rOK, err := singleItem(right)
+	if err != nil {
+		return err
+	}
+
+	if !lOK || !rOK {
+		f.ctx = tree.Sequence{}
+		return nil
+	}
+
+	if l, err = castUntyped(l, r); err != nil {
+		return err
+	}
+
+	if r, err = castUntyped(r, l); err != nil {
+		return err
+	}
+
+	switch lt := l.(type) {
+	case tree.Num:
+		if _, ok := r.(tree.Num); ok {
+			return numberOperator(l, r, f, op)
+		}
+	case tree.Bool:
+		if rt, ok := r.(tree.Bool); ok {
+			return numberOperator(boolNum(lt), boolNum(rt), f, op)
+		}
+	case tree.String:
+		if rt, ok := r.(tree.String); ok {
+			return numberOperator(tree.Num(strings.Compare(string(lt), string(rt))), tree.Num(0), f, op)
+		}
+	default:
+		if isTemporal(l) {
+			return temporalOperator(l, r, f, op)
+		}
+	}
+
+	return fmt.Errorf("Cannot compare %s with %s", l.String(), r.String())
+}
+
+func boolNum(b tree.Bool) tree.Num {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+//nodeCompOperator compares the identity or document order of two nodes.
+func nodeCompOperator(left, right tree.Result, f *xpFilt, op string) error {
+	l, lOK, err := singleItem(left)
+	if err != nil {
+		return err
+	}
+
+	r, rOK, err := singleItem(right)
+	if err != nil {
+		return err
+	}
+
+	if !lOK || !rOK {
+		f.ctx = tree.Sequence{}
+		return nil
+	}
+
+	if !tree.IsNode(l) || !tree.IsNode(r) {
+		return fmt.Errorf("Cannot convert data type to node")
+	}
+
+	lPos, rPos := l.(tree.NodeSet)[0].Pos(), r.(tree.NodeSet)[0].Pos()
+
+	switch op {
+	case "is":
+		f.ctx = tree.Bool(lPos == rPos)
+	case "<<":
+		f.ctx = tree.Bool(lPos < rPos)
+	case ">>":
+		f.ctx = tree.Bool(lPos > rPos)
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+package execxp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ChrisTrenkamp/goxpath/ast"
+	"github.com/ChrisTrenkamp/goxpath/lexer"
+	"github.com/ChrisTrenkamp/goxpath/parser"
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/xconst"
+)
+
+//pureFns are the built-in functions that only depend on their arguments, so
+//they can be evaluated before execution if all of their arguments are constant.
+var pureFns = map[string]bool{
+	"concat":          true,
+	"string":          true,
+	"number":          true,
+	"boolean":         true,
+	"not":             true,
+	"floor":           true,
+	"ceiling":         true,
+	"round":           true,
+	"string-length":   true,
+	"substring":       true,
+	"contains":        true,
+	"starts-with":     true,
+	"ends-with":       true,
+	"normalize-space": true,
+	"translate":       true,
+	"upper-case":      true,
+	"lower-case":      true,
+}
+
+//boolFns are the built-in functions that return a boolean.
+var boolFns = map[string]bool{
+	"not":         true,
+	"boolean":     true,
+	"true":        true,
+	"false":       true,
+	"contains":    true,
+	"starts-with": true,
+	"ends-with":   true,
+	"matches":     true,
+	"lang":        true,
+}
+
+//Optimize returns an optimized copy of the expression, and a description of
+//each optimization that was applied.  Constant sub-expressions are evaluated,
+//and //name is rewritten to a single descendant::name step if the step's
+//predicates do not depend on the context position or size.
+func Optimize(n *parser.Node) (*parser.Node, []string) {
+	o := &optimizer{orig: make(map[*parser.Node]*parser.Node), folded: make(map[*parser.Node]note)}
+	ret := o.fold(o.copyNode(n, nil))
+	o.rewritePaths(ret)
+	o.positional(ret)
+
+	for _, i := range o.folded {
+		o.notes = append(o.notes, i)
+	}
+
+	sort.Sort(o.notes)
+	msgs := make([]string, len(o.notes))
+	for i, j := range o.notes {
+		msgs[i] = j.msg
+	}
+
+	return ret, msgs
+}
+
+type note struct {
+	pos int
+	msg string
+}
+
+type notes []note
+
+func (n notes) Len() int      { return len(n) }
+func (n notes) Swap(i, j int) { n[i], n[j] = n[j], n[i] }
+func (n notes) Less(i, j int) bool {
+	if n[i].pos == n[j].pos {
+		return n[i].msg < n[j].msg
+	}
+	return n[i].pos < n[j].pos
+}
+
+//optimizer holds the nodes of the original expression, so the optimizations
+//can be described as they were written.  The notes of folded expressions are
+//kept separately, since they are replaced when the expression that contains
+//them is folded.
+type optimizer struct {
+	orig   map[*parser.Node]*parser.Node
+	folded map[*parser.Node]note
+	notes  notes
+}
+
+func (o *optimizer) copyNode(n, parent *parser.Node) *parser.Node {
+	if n == nil {
+		return nil
+	}
+
+	ret := &parser.Node{Val: n.Val, Pos: n.Pos, Parent: parent}
+	o.orig[ret] = n
+	ret.Left = o.copyNode(n.Left, ret)
+	ret.Right = o.copyNode(n.Right, ret)
+	return ret
+}
+
+func isConst(n *parser.Node) bool {
+	if n == nil {
+		return false
+	}
+
+	switch n.Val.Typ {
+	case lexer.XItemNumLit, lexer.XItemStrLit:
+		return true
+	case lexer.XItemFunction:
+		return (n.Val.Val == "true" || n.Val.Val == "false") && n.Left == nil && n.Right == nil
+	}
+
+	return false
+}
+
+//fold evaluates the operators and pure functions whose operands are constant.
+//If the evaluation fails, the node is kept so the error is reported when the
+//expression is executed.
+func (o *optimizer) fold(n *parser.Node) *parser.Node {
+	if n == nil {
+		return nil
+	}
+
+	n.Left = o.fold(n.Left)
+	n.Right = o.fold(n.Right)
+
+	switch n.Val.Typ {
+	case lexer.XItemOperator:
+		//Sequences are not folded, since they are not limited until execution
+		if (n.Left != nil && !isConst(n.Left)) || !isConst(n.Right) || n.Val.Val == "to" || n.Val.Val == "," {
+			return n
+		}
+	case lexer.XItemFunction:
+		//Functions such as string() use the context node if they do not have arguments
+		if !pureFns[n.Val.Val] || n.Left == nil || n.Right != nil {
+			return n
+		}
+
+		for arg := n.Left; arg != nil; arg = arg.Right {
+			if !isConst(arg.Left) {
+				return n
+			}
+		}
+	default:
+		return n
+	}
+
+	f := xpFilt{ctx: tree.NodeSet{}}
+	res, err := exec(&f, n)
+	if err != nil {
+		return n
+	}
+
+	ret := &parser.Node{Pos: n.Pos, Parent: n.Parent}
+
+	switch t := res.(type) {
+	case tree.Num:
+		ret.Val = lexer.XItem{Typ: lexer.XItemNumLit, Val: strconv.FormatFloat(float64(t), 'g', -1, 64)}
+	case tree.String:
+		ret.Val = lexer.XItem{Typ: lexer.XItemStrLit, Val: string(t)}
+	case tree.Bool:
+		ret.Val = lexer.XItem{Typ: lexer.XItemFunction, Val: t.String()}
+	default:
+		return n
+	}
+
+	delete(o.folded, n.Left)
+	delete(o.folded, n.Right)
+	if n.Val.Typ == lexer.XItemFunction {
+		for arg := n.Left; arg != nil; arg = arg.Right {
+			delete(o.folded, arg.Left)
+		}
+	}
+
+	o.folded[ret] = note{pos: n.Pos, msg: fmt.Sprintf("%s folded to %s", ast.Format(ast.FromNode(o.orig[n]), true), ast.Format(ast.FromNode(ret), true))}
+
+	return ret
+}
+
+//rewritePaths rewrites //name to descendant::name.  descendant-or-self::node()
+//followed by a child step selects the same nodes as the descendant axis, but
+//the proximity positions of the nodes are different, so it is only done if
+//the predicates do not use them.
+func (o *optimizer) rewritePaths(n *parser.Node) {
+	if n == nil {
+		return
+	}
+
+	if n.Val.Typ == lexer.XItemAbbrAbsLocPath || n.Val.Typ == lexer.XItemAbbrRelLocPath {
+		o.rewriteAbbrStep(n)
+	}
+
+	o.rewritePaths(n.Left)
+	o.rewritePaths(n.Right)
+}
+
+func (o *optimizer) rewriteAbbrStep(n *parser.Node) {
+	step := n.Left
+	if step == nil {
+		return
+	}
+
+	var axis *parser.Node
+	if step.Val.Typ == lexer.XItemAxis {
+		if step.Val.Val != xconst.AxisChild {
+			return
+		}
+		axis = step
+		step = step.Left
+	}
+
+	test := ""
+	if step != nil && step.Val.Typ == lexer.XItemNCName {
+		test = step.Val.Val + ":"
+		step = step.Left
+	}
+
+	if step == nil || (step.Val.Typ != lexer.XItemQName && step.Val.Typ != lexer.XItemNodeType) {
+		return
+	}
+
+	if step.Val.Val == "." || step.Val.Val == ".." {
+		return
+	}
+
+	test += step.Val.Val
+	if step.Left != nil && step.Left.Val.Typ == lexer.XItemProcLit {
+		test += "(" + ast.Format(&ast.Literal{Value: tree.String(step.Left.Val.Val)}, true) + ")"
+		step = step.Left
+	} else if step.Val.Typ == lexer.XItemNodeType {
+		test += "()"
+	}
+
+	for pred := step.Left; pred != nil && pred.Val.Typ == lexer.XItemPredicate; pred = pred.Right {
+		if !positionless(pred.Left) {
+			return
+		}
+	}
+
+	if axis == nil {
+		axis = &parser.Node{Val: lexer.XItem{Typ: lexer.XItemAxis}, Pos: n.Left.Pos, Parent: n, Left: n.Left}
+		n.Left.Parent = axis
+		n.Left = axis
+	}
+	axis.Val.Val = xconst.AxisDescendent
+
+	if n.Val.Typ == lexer.XItemAbbrAbsLocPath {
+		n.Val.Typ = lexer.XItemAbsLocPath
+	} else {
+		n.Val.Typ = lexer.XItemRelLocPath
+	}
+
+	o.notes = append(o.notes, note{pos: n.Pos, msg: fmt.Sprintf("//%s rewritten to descendant::%s", test, test)})
+}
+
+//positional notes the [number] and [last()] predicates, which select the
+//nodes by their position without evaluating the predicate for each node.
+func (o *optimizer) positional(n *parser.Node) {
+	if n == nil {
+		return
+	}
+
+	if n.Val.Typ == lexer.XItemPredicate {
+		if _, ok := constPos(n.Left, 0); ok {
+			o.notes = append(o.notes, note{pos: n.Pos, msg: fmt.Sprintf("[%s] selects by position", ast.Format(ast.FromNode(n.Left), true))})
+		}
+	}
+
+	o.positional(n.Left)
+	o.positional(n.Right)
+}
+
+//positionless returns true if the predicate is a boolean that does not depend
+//on the context position or size.
+func positionless(n *parser.Node) bool {
+	switch n.Val.Typ {
+	case lexer.XItemOperator:
+		if !booleanOps[n.Val.Val] && !andOrOps[n.Val.Val] && !nodeOps[n.Val.Val] && valueOps[n.Val.Val] == "" {
+			return false
+		}
+	case lexer.XItemFunction:
+		if !boolFns[n.Val.Val] {
+			return false
+		}
+	case lexer.XItemAbsLocPath, lexer.XItemAbbrAbsLocPath, lexer.XItemRelLocPath, lexer.XItemAbbrRelLocPath:
+	default:
+		return false
+	}
+
+	return !usesPosition(n)
+}
+
+//usesPosition returns true if position() or last() are called in the context
+//of n.  Predicates are skipped, since they have their own context.
+func usesPosition(n *parser.Node) bool {
+	if n == nil {
+		return false
+	}
+
+	if n.Val.Typ == lexer.XItemFunction && (n.Val.Val == "position" || n.Val.Val == "last") {
+		return true
+	}
+
+	if n.Val.Typ == lexer.XItemPredicate {
+		return usesPosition(n.Right)
+	}
+
+	return usesPosition(n.Left) || usesPosition(n.Right)
+}
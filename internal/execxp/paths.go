@@ -3,11 +3,11 @@ package execxp
 import (
 	"encoding/xml"
 	"fmt"
+	"sort"
 	"strconv"
-	"strings"
+	"time"
 
 	"github.com/ChrisTrenkamp/goxpath/internal/execxp/findutil"
-	"github.com/ChrisTrenkamp/goxpath/internal/execxp/intfns"
 	"github.com/ChrisTrenkamp/goxpath/internal/xsort"
 	"github.com/ChrisTrenkamp/goxpath/lexer"
 	"github.com/ChrisTrenkamp/goxpath/parser"
@@ -24,11 +24,17 @@ type xpFilt struct {
 	ctxPos    int
 	ctxSize   int
 	proxPos   map[int]int
+	proxSize  map[int]int
+	proxGroup map[int]int
+	prof      Profile
+	lim       *limiter
+	res       *resolver
 	fns       map[xml.Name]tree.Wrap
-	variables map[string]tree.Result
+	variables map[xml.Name]tree.Result
+	now       time.Time
 }
 
-type xpExecFn func(*xpFilt, string)
+type xpExecFn func(*xpFilt, string) error
 
 var xpFns = map[lexer.XItemType]xpExecFn{
 	lexer.XItemAbsLocPath:     xfAbsLocPath,
@@ -41,85 +47,239 @@ var xpFns = map[lexer.XItemType]xpExecFn{
 	lexer.XItemQName:          xfQName,
 	lexer.XItemNodeType:       xfNodeType,
 	lexer.XItemProcLit:        xfProcInstLit,
-	lexer.XItemStrLit:         xfStrLit,
-	lexer.XItemNumLit:         xfNumLit,
 }
 
 func xfExec(f *xpFilt, n *parser.Node) (err error) {
+	defer func() {
+		err = posErr(n, err)
+	}()
+
+	if err = f.lim.enter(); err != nil {
+		return
+	}
+	defer f.lim.exit()
+
 	for n != nil {
-		if fn, ok := xpFns[n.Val.Typ]; ok {
-			fn(f, n.Val.Val)
-			n = n.Left
-		} else if n.Val.Typ == lexer.XItemPredicate {
-			if err = xfPredicate(f, n.Left); err != nil {
-				return
-			}
+		if err = f.lim.step(); err != nil {
+			return
+		}
 
-			n = n.Right
-		} else if n.Val.Typ == lexer.XItemFunction {
-			if err = xfFunction(f, n); err != nil {
-				return
-			}
+		var next *parser.Node
+		if f.prof != nil {
+			next, err = f.prof.exec(f, n)
+		} else {
+			next, err = xfNode(f, n)
+		}
 
-			n = n.Right
-		} else if n.Val.Typ == lexer.XItemOperator {
-			lf := xpFilt{
-				t:         f.t,
-				ns:        f.ns,
-				ctx:       f.ctx,
-				ctxPos:    f.ctxPos,
-				ctxSize:   f.ctxSize,
-				proxPos:   f.proxPos,
-				fns:       f.fns,
-				variables: f.variables,
-			}
-			left, err := exec(&lf, n.Left)
-			if err != nil {
-				return err
-			}
+		if err == nil {
+			err = f.lim.result(f.ctx)
+		}
 
-			rf := xpFilt{
-				t:         f.t,
-				ns:        f.ns,
-				ctx:       f.ctx,
-				fns:       f.fns,
-				variables: f.variables,
-			}
-			right, err := exec(&rf, n.Right)
+		if err != nil {
+			return
+		}
+
+		n = next
+	}
+
+	return
+}
+
+//xfNode evaluates a node of the expression, and returns the node that
+//continues the expression, if any.
+func xfNode(f *xpFilt, n *parser.Node) (*parser.Node, error) {
+	if fn, ok := xpFns[n.Val.Typ]; ok {
+		return n.Left, fn(f, n.Val.Val)
+	} else if n.Val.Typ == lexer.XItemPredicate {
+		return n.Right, xfPredicate(f, n.Left)
+	} else if n.Val.Typ == lexer.XItemFunction {
+		if err := xfFunction(f, n); err != nil {
+			return nil, err
+		}
+
+		filtCtx(f)
+		return n.Right, nil
+	} else if n.Val.Typ == lexer.XItemOperator {
+		if n.Left == nil {
+			return nil, xfNegate(f, n)
+		}
+
+		lf := f.sub()
+		left, err := exec(&lf, n.Left)
+		if err != nil {
+			return nil, err
+		}
+
+		rf := f.sub()
+		right, err := exec(&rf, n.Right)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, xfOperator(left, right, f, n.Val.Val)
+	} else if fn, ok := exprFns[n.Val.Typ]; ok {
+		return nil, fn(f, n)
+	} else if n.Val.Typ == lexer.XItemVariable {
+		name, err := expandName(n.Val.Val, f.ns)
+		if err != nil {
+			return nil, err
+		}
+
+		if res, ok := f.variables[name]; ok {
+			f.ctx = res
+			return nil, nil
+		}
+
+		res, ok, err := f.res.variable(name)
+		if ok {
+			f.ctx = res
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("Invalid variable '%s'", n.Val.Val)
+	} else if n.Val.Typ == lexer.XItemStrLit {
+		f.ctx = tree.String(n.Val.Val)
+		return nil, nil
+	} else if n.Val.Typ == lexer.XItemNumLit {
+		num, _ := strconv.ParseFloat(n.Val.Val, 64)
+		f.ctx = tree.Num(num)
+		return nil, nil
+	} else if n.Val.Typ == parser.Empty {
+		if n.Left == nil {
+			f.ctx = tree.Sequence{}
+		} else {
+			pf := f.sub()
+			res, err := exec(&pf, n.Left)
 			if err != nil {
-				return err
+				return nil, err
 			}
+			f.ctx = res
+		}
 
-			return xfOperator(left, right, f, n.Val.Val)
-		} else if n.Val.Typ == lexer.XItemVariable {
-			if res, ok := f.variables[n.Val.Val]; ok {
-				f.ctx = res
-				return nil
-			}
-			return fmt.Errorf("Invalid variable '%s'", n.Val.Val)
-		} else if string(n.Val.Typ) == "" {
-			n = n.Left
-			//} else {
-			//	return fmt.Errorf("Cannot process " + string(n.Val.Typ))
+		filtCtx(f)
+		return n.Right, nil
+		//} else {
+		//	return fmt.Errorf("Cannot process " + string(n.Val.Typ))
+	}
+
+	return nil, nil
+}
+
+//sub creates a filter for evaluating a sub-expression in the same context as f.
+func (f *xpFilt) sub() xpFilt {
+	return xpFilt{
+		t:         f.t,
+		ns:        f.ns,
+		ctx:       f.ctx,
+		ctxPos:    f.ctxPos,
+		ctxSize:   f.ctxSize,
+		proxPos:   f.proxPos,
+		proxSize:  f.proxSize,
+		proxGroup: f.proxGroup,
+		prof:      f.prof,
+		lim:       f.lim,
+		res:       f.res,
+		fns:       f.fns,
+		variables: f.variables,
+		now:       f.now,
+	}
+}
+
+//filtCtx prepares the result of a primary expression for any predicates that
+//follow it.  Node-sets are put in document order, and the proximity positions
+//are taken from that order.
+func filtCtx(f *xpFilt) {
+	f.proxPos = make(map[int]int)
+	f.proxSize = nil
+	f.proxGroup = nil
+
+	if res, ok := f.ctx.(tree.NodeSet); ok {
+		sorted := make(tree.NodeSet, len(res))
+		copy(sorted, res)
+		xsort.SortNodes(sorted)
+
+		for i, j := range sorted {
+			f.proxPos[j.Pos()] = i + 1
 		}
+
+		f.ctx = sorted
+		f.ctxSize = len(sorted)
+		return
 	}
 
-	return
+	f.ctxSize = len(tree.Items(f.ctx))
+}
+
+//toNodeSet converts node-sets, and sequences that only contain nodes, to a node-set.
+func toNodeSet(r tree.Result) (tree.NodeSet, bool) {
+	switch t := r.(type) {
+	case tree.NodeSet:
+		return t, true
+	case tree.Sequence:
+		return t.NodeSet()
+	}
+
+	return nil, false
+}
+
+//constPos returns the position selected by a [number] or [last()] predicate.
+//These predicates select nodes by their position without evaluating the
+//predicate for each node.
+func constPos(n *parser.Node, size int) (float64, bool) {
+	if n.Val.Typ == lexer.XItemNumLit {
+		num, _ := strconv.ParseFloat(n.Val.Val, 64)
+		return num, true
+	}
+
+	if n.Val.Typ == lexer.XItemFunction && n.Val.Val == "last" && n.Left == nil && n.Right == nil {
+		return float64(size), true
+	}
+
+	return 0, false
 }
 
 func xfPredicate(f *xpFilt, n *parser.Node) (err error) {
-	res := f.ctx.(tree.NodeSet)
+	res, ok := f.ctx.(tree.NodeSet)
+	if !ok {
+		return seqPredicate(f, n)
+	}
+
 	newRes := make(tree.NodeSet, 0, len(res))
 
 	for i := range res {
+		//The context position and size are relative to the node's context
+		//node, if the nodes were selected by a step.
+		ctxPos := i
+		if p, ok := f.proxPos[res[i].Pos()]; ok {
+			ctxPos = p - 1
+		}
+
+		size, found := f.proxSize[res[i].Pos()]
+		if !found {
+			size = f.ctxSize
+		}
+
+		if pos, isConst := constPos(n, size); isConst {
+			if float64(f.proxPos[res[i].Pos()]) == pos {
+				newRes = append(newRes, res[i])
+			}
+			continue
+		}
+
 		pf := xpFilt{
 			t:         f.t,
 			ns:        f.ns,
-			ctxPos:    i,
-			ctxSize:   f.ctxSize,
+			ctxPos:    ctxPos,
+			ctxSize:   size,
 			ctx:       tree.NodeSet{res[i]},
 			fns:       f.fns,
 			variables: f.variables,
+			now:       f.now,
+			prof:      f.prof,
+			lim:       f.lim,
+			res:       f.res,
 		}
 
 		predRes, err := exec(&pf, n)
@@ -137,10 +297,7 @@ func xfPredicate(f *xpFilt, n *parser.Node) (err error) {
 		}
 	}
 
-	f.proxPos = make(map[int]int)
-	for pos, j := range newRes {
-		f.proxPos[j.Pos()] = pos + 1
-	}
+	renumberProx(f, newRes)
 
 	f.ctx = newRes
 	f.ctxSize = len(newRes)
@@ -148,6 +305,89 @@ func xfPredicate(f *xpFilt, n *parser.Node) (err error) {
 	return
 }
 
+//renumberProx sets the proximity positions of the nodes that are left after a
+//predicate, so the predicates that follow it are evaluated against the same
+//context nodes.  The nodes that were selected from each context node are
+//numbered separately, in the order of their previous positions.
+func renumberProx(f *xpFilt, res tree.NodeSet) {
+	groups := make(map[int][]tree.Node)
+	for _, i := range res {
+		group := f.proxGroup[i.Pos()]
+		groups[group] = append(groups[group], i)
+	}
+
+	proxPos := make(map[int]int)
+	proxSize := make(map[int]int)
+
+	for _, nodes := range groups {
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return f.proxPos[nodes[i].Pos()] < f.proxPos[nodes[j].Pos()]
+		})
+
+		for pos, i := range nodes {
+			proxPos[i.Pos()] = pos + 1
+			proxSize[i.Pos()] = len(nodes)
+		}
+	}
+
+	f.proxPos = proxPos
+	f.proxSize = proxSize
+}
+
+//seqPredicate filters the items of a sequence.  The context position is the
+//item's position in the sequence.
+func seqPredicate(f *xpFilt, n *parser.Node) error {
+	items := tree.Items(f.ctx)
+	newRes := make(tree.Sequence, 0, len(items))
+	pos, isConst := constPos(n, len(items))
+
+	for i := range items {
+		if isConst {
+			if float64(i+1) == pos {
+				newRes = append(newRes, items[i])
+			}
+			continue
+		}
+
+		pf := xpFilt{
+			t:         f.t,
+			ns:        f.ns,
+			ctxPos:    i,
+			ctxSize:   len(items),
+			ctx:       items[i],
+			fns:       f.fns,
+			variables: f.variables,
+			now:       f.now,
+			prof:      f.prof,
+			lim:       f.lim,
+			res:       f.res,
+		}
+
+		predRes, err := exec(&pf, n)
+		if err != nil {
+			return err
+		}
+
+		ok := false
+		if num, isNum := predRes.(tree.Num); isNum {
+			ok = float64(i+1) == float64(num)
+		} else if b, isBool := predRes.(tree.IsBool); isBool {
+			ok = bool(b.Bool())
+		} else {
+			return fmt.Errorf("Cannot convert argument to boolean")
+		}
+
+		if ok {
+			newRes = append(newRes, items[i])
+		}
+	}
+
+	f.ctx = newRes
+	f.ctxSize = len(newRes)
+
+	return nil
+}
+
 func checkPredRes(ret tree.Result, f *xpFilt, node tree.Node) (bool, error) {
 	if num, ok := ret.(tree.Num); ok {
 		if float64(f.proxPos[node.Pos()]) == float64(num) {
@@ -164,19 +404,14 @@ func checkPredRes(ret tree.Result, f *xpFilt, node tree.Node) (bool, error) {
 }
 
 func xfFunction(f *xpFilt, n *parser.Node) error {
-	spl := strings.Split(n.Val.Val, ":")
-	var name xml.Name
-	if len(spl) == 1 {
-		name.Local = spl[0]
-	} else {
-		name.Space = f.ns[spl[0]]
-		name.Local = spl[1]
-	}
-	fn, ok := intfns.BuiltIn[name]
-	if !ok {
-		fn, ok = f.fns[name]
+	nArgs := 0
+	for arg := n.Left; arg != nil; arg = arg.Right {
+		nArgs++
 	}
 
+	name, _ := qName(n.Val.Val, f.ns)
+	fn, ok := findFunc(name, nArgs, f.fns, f.res)
+
 	if ok {
 		args := []tree.Result{}
 		param := n.Left
@@ -190,6 +425,10 @@ func xfFunction(f *xpFilt, n *parser.Node) error {
 				ctxSize:   f.ctxSize,
 				fns:       f.fns,
 				variables: f.variables,
+				now:       f.now,
+				prof:      f.prof,
+				lim:       f.lim,
+				res:       f.res,
 			}
 			res, err := exec(&pf, param.Left)
 			if err != nil {
@@ -200,7 +439,8 @@ func xfFunction(f *xpFilt, n *parser.Node) error {
 			param = param.Right
 		}
 
-		filt, err := fn.Call(tree.Ctx{NodeSet: f.ctx.(tree.NodeSet), Size: f.ctxSize, Pos: f.ctxPos + 1}, args...)
+		ctx, _ := toNodeSet(f.ctx)
+		filt, err := fn.Call(tree.Ctx{NodeSet: ctx, Size: f.ctxSize, Pos: f.ctxPos + 1, Now: f.now}, args...)
 		f.ctx = filt
 		return err
 	}
@@ -236,13 +476,56 @@ var numOps = map[string]bool{
 	">=":  true,
 }
 
+//valueOps maps the value comparison operators to their general comparison
+//counterparts.
+var valueOps = map[string]string{
+	"eq": "=",
+	"ne": "!=",
+	"lt": "<",
+	"le": "<=",
+	"gt": ">",
+	"ge": ">=",
+}
+
+var nodeOps = map[string]bool{
+	"is": true,
+	"<<": true,
+	">>": true,
+}
+
 var andOrOps = map[string]bool{
 	"and": true,
 	"or":  true,
 }
 
 func xfOperator(left, right tree.Result, f *xpFilt, op string) error {
+	if op == "," {
+		return sequenceOperator(left, right, f, op)
+	}
+
+	if genOp, ok := valueOps[op]; ok {
+		return valueCompOperator(left, right, f, genOp)
+	}
+
+	if nodeOps[op] {
+		return nodeCompOperator(left, right, f, op)
+	}
+
+	if op == "to" {
+		return rangeOperator(left, right, f, op)
+	}
+
+	if op == "intersect" || op == "except" {
+		return intersectExceptOperator(left, right, f, op)
+	}
+
 	if booleanOps[op] {
+		_, lSeq := left.(tree.Sequence)
+		_, rSeq := right.(tree.Sequence)
+		if lSeq || rSeq {
+			return seqCompOperator(left, right, f, op)
+		}
+
 		lNode, lOK := left.(tree.NodeSet)
 		rNode, rOK := right.(tree.NodeSet)
 		if lOK && rOK {
@@ -277,55 +560,60 @@ func xfOperator(left, right tree.Result, f *xpFilt, op string) error {
 	//return fmt.Errorf("Unknown operator " + op)
 }
 
-func xfAbsLocPath(f *xpFilt, val string) {
+func xfAbsLocPath(f *xpFilt, val string) error {
 	i := f.t
 	for i.GetNodeType() != tree.NtRoot {
 		i = i.GetParent()
 	}
 	f.ctx = tree.NodeSet{i}
+	return nil
 }
 
-func xfAbbrAbsLocPath(f *xpFilt, val string) {
+func xfAbbrAbsLocPath(f *xpFilt, val string) error {
 	i := f.t
 	for i.GetNodeType() != tree.NtRoot {
 		i = i.GetParent()
 	}
 	f.ctx = tree.NodeSet{i}
 	f.expr = abbrPathExpr()
-	find(f)
+	return find(f)
 }
 
-func xfRelLocPath(f *xpFilt, val string) {
+func xfRelLocPath(f *xpFilt, val string) error {
+	return nil
 }
 
-func xfAbbrRelLocPath(f *xpFilt, val string) {
+func xfAbbrRelLocPath(f *xpFilt, val string) error {
 	f.expr = abbrPathExpr()
-	find(f)
+	return find(f)
 }
 
-func xfAxis(f *xpFilt, val string) {
+func xfAxis(f *xpFilt, val string) error {
 	f.expr.Axis = val
+	return nil
 }
 
-func xfAbbrAxis(f *xpFilt, val string) {
+func xfAbbrAxis(f *xpFilt, val string) error {
 	f.expr.Axis = xconst.AxisAttribute
+	return nil
 }
 
-func xfNCName(f *xpFilt, val string) {
+func xfNCName(f *xpFilt, val string) error {
 	f.expr.Name.Space = val
+	return nil
 }
 
-func xfQName(f *xpFilt, val string) {
+func xfQName(f *xpFilt, val string) error {
 	f.expr.Name.Local = val
-	find(f)
+	return find(f)
 }
 
-func xfNodeType(f *xpFilt, val string) {
+func xfNodeType(f *xpFilt, val string) error {
 	f.expr.NodeType = val
-	find(f)
+	return find(f)
 }
 
-func xfProcInstLit(f *xpFilt, val string) {
+func xfProcInstLit(f *xpFilt, val string) error {
 	filt := tree.NodeSet{}
 	for _, i := range f.ctx.(tree.NodeSet) {
 		if i.GetToken().(xml.ProcInst).Target == val {
@@ -333,15 +621,22 @@ func xfProcInstLit(f *xpFilt, val string) {
 		}
 	}
 	f.ctx = filt
+	return nil
 }
 
-func xfStrLit(f *xpFilt, val string) {
-	f.ctx = tree.String(val)
-}
+func xfNegate(f *xpFilt, n *parser.Node) error {
+	rf := f.sub()
+	right, err := exec(&rf, n.Right)
+	if err != nil {
+		return err
+	}
+
+	if dur, ok := right.(tree.Duration); ok {
+		f.ctx = dur.Negate()
+		return nil
+	}
 
-func xfNumLit(f *xpFilt, val string) {
-	num, _ := strconv.ParseFloat(val, 64)
-	f.ctx = tree.Num(num)
+	return numberOperator(tree.Num(0), right, f, n.Val.Val)
 }
 
 func abbrPathExpr() pathexpr.PathExpr {
@@ -352,9 +647,11 @@ func abbrPathExpr() pathexpr.PathExpr {
 	}
 }
 
-func find(f *xpFilt) {
+func find(f *xpFilt) error {
 	dupFilt := make(map[int]tree.Node)
 	f.proxPos = make(map[int]int)
+	f.proxSize = make(map[int]int)
+	f.proxGroup = make(map[int]int)
 
 	if f.expr.Axis == "" && f.expr.NodeType == "" && f.expr.Name.Space == "" {
 		if f.expr.Name.Local == "." {
@@ -375,11 +672,39 @@ func find(f *xpFilt) {
 	}
 
 	f.expr.NS = f.ns
+	if _, ok := f.ns[f.expr.Name.Space]; !ok {
+		//The node test uses a predeclared prefix
+		if space, ok := nsPrefix(f.expr.Name.Space, f.ns); ok {
+			f.expr.NS = map[string]string{f.expr.Name.Space: space}
+		}
+	}
 
-	for _, i := range f.ctx.(tree.NodeSet) {
-		for pos, j := range findutil.Find(i, f.expr) {
+	ctx, ok := toNodeSet(f.ctx)
+	if !ok {
+		if f.expr.Axis == xconst.AxisSelf && f.expr.NodeType == xconst.NodeTypeNode {
+			//The context item is an atomic value from a sequence.
+			f.expr = pathexpr.PathExpr{}
+			return nil
+		}
+
+		return fmt.Errorf("Cannot convert data type to node-set")
+	}
+
+	for group, i := range ctx {
+		if err := f.lim.canceled(); err != nil {
+			return err
+		}
+
+		found := findutil.Find(i, f.expr)
+		for pos, j := range found {
 			dupFilt[j.Pos()] = j
 			f.proxPos[j.Pos()] = pos + 1
+			f.proxSize[j.Pos()] = len(found)
+			f.proxGroup[j.Pos()] = group
+		}
+
+		if err := f.lim.items(len(dupFilt)); err != nil {
+			return err
 		}
 	}
 
@@ -393,4 +718,6 @@ func find(f *xpFilt) {
 	f.expr = pathexpr.PathExpr{}
 	f.ctxSize = len(res)
 	f.ctx = res
+
+	return nil
 }
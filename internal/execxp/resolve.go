@@ -0,0 +1,144 @@
+package execxp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ChrisTrenkamp/goxpath/internal/execxp/intfns"
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/xconst"
+)
+
+//FunctionResolver resolves the functions that are not built in, or in the
+//function map, when they are called.
+type FunctionResolver interface {
+	ResolveFunction(name xml.Name, arity int) (tree.Wrap, bool)
+}
+
+//VariableResolver resolves the variables that are not in the variable map
+//when they are referenced.  A nil result means the variable does not exist.
+type VariableResolver interface {
+	ResolveVariable(name xml.Name) (tree.Result, error)
+}
+
+//Opts are the options of an execution.  Now is the current date and time of
+//the execution.
+type Opts struct {
+	NS           map[string]string
+	Funcs        map[xml.Name]tree.Wrap
+	Vars         map[xml.Name]tree.Result
+	FuncResolver FunctionResolver
+	VarResolver  VariableResolver
+	Now          time.Time
+	Limits
+}
+
+type fnKey struct {
+	name  xml.Name
+	arity int
+}
+
+//resolver calls the resolvers of an execution.  The results are cached, so
+//each function and variable is only resolved once.  It is shared by all of the
+//filters of the execution.
+type resolver struct {
+	fns      FunctionResolver
+	vars     VariableResolver
+	fnCache  map[fnKey]tree.Wrap
+	varCache map[xml.Name]tree.Result
+}
+
+func newResolver(o Opts) *resolver {
+	return &resolver{
+		fns:      o.FuncResolver,
+		vars:     o.VarResolver,
+		fnCache:  make(map[fnKey]tree.Wrap),
+		varCache: make(map[xml.Name]tree.Result),
+	}
+}
+
+func (r *resolver) function(name xml.Name, arity int) (tree.Wrap, bool) {
+	if r == nil || r.fns == nil {
+		return tree.Wrap{}, false
+	}
+
+	key := fnKey{name: name, arity: arity}
+	if fn, ok := r.fnCache[key]; ok {
+		return fn, true
+	}
+
+	fn, ok := r.fns.ResolveFunction(name, arity)
+	if ok {
+		r.fnCache[key] = fn
+	}
+
+	return fn, ok
+}
+
+//variable resolves a variable.  false is returned if there isn't a variable
+//resolver, or if it does not have the variable.
+func (r *resolver) variable(name xml.Name) (tree.Result, bool, error) {
+	if r == nil || r.vars == nil {
+		return nil, false, nil
+	}
+
+	if res, ok := r.varCache[name]; ok {
+		return res, true, nil
+	}
+
+	res, err := r.vars.ResolveVariable(name)
+	if err != nil || res == nil {
+		return nil, false, err
+	}
+
+	r.varCache[name] = res
+	return res, true, nil
+}
+
+//qName resolves a qualified name.  false is returned if the prefix is not
+//declared.
+func qName(val string, ns map[string]string) (xml.Name, bool) {
+	spl := strings.Split(val, ":")
+	if len(spl) == 1 {
+		return xml.Name{Local: spl[0]}, true
+	}
+
+	space, ok := nsPrefix(spl[0], ns)
+	return xml.Name{Space: space, Local: spl[1]}, ok
+}
+
+//nsPrefix returns the namespace that prefix is bound to in ns, or in the
+//predeclared prefixes.  false is returned if the prefix is not declared.
+func nsPrefix(prefix string, ns map[string]string) (string, bool) {
+	space, ok := ns[prefix]
+	if !ok {
+		space, ok = xconst.PredeclaredNS[prefix]
+	}
+
+	return space, ok
+}
+
+//expandName is like qName, but returns an error if the prefix is not declared.
+func expandName(val string, ns map[string]string) (xml.Name, error) {
+	name, ok := qName(val, ns)
+	if !ok {
+		return name, fmt.Errorf("Unknown namespace prefix: %s", val[:strings.Index(val, ":")])
+	}
+
+	return name, nil
+}
+
+//findFunc looks up a function in the built-in functions, the function map and
+//the function resolver, in that order.
+func findFunc(name xml.Name, arity int, fns map[xml.Name]tree.Wrap, r *resolver) (tree.Wrap, bool) {
+	fn, ok := intfns.BuiltIn[name]
+	if !ok {
+		fn, ok = fns[name]
+	}
+	if !ok {
+		fn, ok = r.function(name, arity)
+	}
+	return fn, ok
+}
@@ -0,0 +1,170 @@
+package lexer
+
+import (
+	"fmt"
+	"unicode"
+)
+
+//findExprState looks for the keywords that start a for, let, some, every or
+//if expression.  To avoid confusing them with element names, the keyword must
+//be followed by a variable reference, or a parenthesis for if expressions.
+func findExprState(l *Lexer) stateFn {
+	switch {
+	case exprKeyword(l, "for", "$"):
+		return forState
+	case exprKeyword(l, "let", "$"):
+		return letState
+	case exprKeyword(l, "some", "$"), exprKeyword(l, "every", "$"):
+		return quantifierState
+	case exprKeyword(l, "if", "("):
+		return ifState
+	}
+
+	return nil
+}
+
+func exprKeyword(l *Lexer, kw, follow string) bool {
+	if !keywordPeek(kw, l) {
+		return false
+	}
+
+	i := len(kw) + 1
+	for unicode.IsSpace(l.peekAt(i)) {
+		i++
+	}
+
+	return string(l.peekAt(i)) == follow
+}
+
+//getKeyword emits the keyword if it is next in the expression.
+func getKeyword(l *Lexer, kw string, tok XItemType) bool {
+	l.skipWS(true)
+	if !keywordPeek(kw, l) {
+		return false
+	}
+
+	for range kw {
+		l.next()
+	}
+	l.emit(tok)
+	l.skipWS(true)
+
+	return true
+}
+
+//getVarName emits the name of a variable.  The item's position includes the $.
+func getVarName(l *Lexer, tok XItemType) error {
+	l.next()
+	r := l.peek()
+	for unicode.Is(first, r) || unicode.Is(second, r) {
+		l.next()
+		r = l.peek()
+	}
+	if l.start+1 == l.pos {
+		return fmt.Errorf("Empty variable name")
+	}
+	l.emitVal(tok, l.input[l.start+1:l.pos])
+	return nil
+}
+
+//getExprSingle lexes an expression that ends at the first top-level comma.
+func getExprSingle(l *Lexer) {
+	l.pushComma(false)
+	for state := startState; state != nil; {
+		state = state(l)
+	}
+	l.popComma()
+	l.skipWS(true)
+}
+
+//getBindings lexes the variable bindings of a for, let, some or every
+//expression.  sep is "in", or ":=" for let expressions.
+func getBindings(l *Lexer, sep string) error {
+	for {
+		l.skipWS(true)
+		if string(l.peek()) != "$" {
+			return missing(fmt.Sprintf("Missing variable in %s binding", sep), "$")
+		}
+
+		if err := getVarName(l, XItemBinding); err != nil {
+			return err
+		}
+
+		l.skipWS(true)
+		if (sep == "in" && !keywordPeek(sep, l)) || (sep != "in" && !strPeek(sep, l)) {
+			return missing(fmt.Sprintf("Missing '%s' in variable binding", sep), sep)
+		}
+		l.skip(len(sep))
+
+		getExprSingle(l)
+
+		if string(l.peek()) != "," {
+			return nil
+		}
+		l.skip(1)
+	}
+}
+
+func forState(l *Lexer) stateFn {
+	return bindingState(l, "for", XItemFor, "in", "return", XItemReturn)
+}
+
+func letState(l *Lexer) stateFn {
+	return bindingState(l, "let", XItemLet, ":=", "return", XItemReturn)
+}
+
+func quantifierState(l *Lexer) stateFn {
+	kw := "some"
+	if !strPeek(kw, l) {
+		kw = "every"
+	}
+
+	return bindingState(l, kw, XItemQuantifier, "in", "satisfies", XItemSatisfies)
+}
+
+func bindingState(l *Lexer, kw string, tok XItemType, sep, ret string, retTok XItemType) stateFn {
+	getKeyword(l, kw, tok)
+
+	if err := getBindings(l, sep); err != nil {
+		return l.fail(err)
+	}
+
+	if !getKeyword(l, ret, retTok) {
+		return l.fail(missing(fmt.Sprintf("Missing '%s' in %s expression", ret, kw), ret))
+	}
+
+	getExprSingle(l)
+
+	return findOperatorState
+}
+
+func ifState(l *Lexer) stateFn {
+	getKeyword(l, "if", XItemIf)
+
+	l.next()
+	l.emit(XItemOperator)
+	l.pushComma(true)
+	for state := startState; state != nil; {
+		state = state(l)
+	}
+	l.popComma()
+	l.skipWS(true)
+	if string(l.next()) != ")" {
+		return l.fail(missing("Missing end )", ")"))
+	}
+	l.emit(XItemOperator)
+
+	if !getKeyword(l, "then", XItemThen) {
+		return l.fail(missing("Missing 'then' in if expression", "then"))
+	}
+
+	getExprSingle(l)
+
+	if !getKeyword(l, "else", XItemElse) {
+		return l.fail(missing("Missing 'else' in if expression", "else"))
+	}
+
+	getExprSingle(l)
+
+	return findOperatorState
+}
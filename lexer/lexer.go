@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"fmt"
+	"io"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -50,6 +51,24 @@ const (
 	XItemOperator = "operator"
 	//XItemVariable marks a variable reference
 	XItemVariable = "variable"
+	//XItemFor marks a for expression
+	XItemFor = "for"
+	//XItemLet marks a let expression
+	XItemLet = "let"
+	//XItemQuantifier marks a some or every expression
+	XItemQuantifier = "quantifier"
+	//XItemBinding marks a variable binding in a for, let, some or every expression
+	XItemBinding = "variable binding"
+	//XItemReturn marks the return expression of a for or let expression
+	XItemReturn = "return"
+	//XItemSatisfies marks the test expression of a some or every expression
+	XItemSatisfies = "satisfies"
+	//XItemIf marks an if expression
+	XItemIf = "if"
+	//XItemThen marks the then branch of an if expression
+	XItemThen = "then"
+	//XItemElse marks the else branch of an if expression
+	XItemElse = "else"
 )
 
 const (
@@ -65,46 +84,138 @@ type XItem struct {
 	Val string
 }
 
+//Token is an XItem with its position in the XPath expression.  Pos and End
+//are the byte offsets of the start and end of the item's text.
+type Token struct {
+	XItem
+	Pos int
+	End int
+}
+
+//Error is an error in the XPath expression that was found by the lexer.  Pos
+//and End are the byte offsets of the offending text, and Expected holds the
+//tokens that were expected instead, if they are known.
+type Error struct {
+	Msg      string
+	Pos      int
+	End      int
+	Expected []string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
 type stateFn func(*Lexer) stateFn
 
-//Lexer lexes out XPath expressions
+//MaxDepth is the deepest that expressions can be nested in parentheses,
+//predicates, function calls and other expressions.  Deeper expressions are
+//rejected instead of overflowing the stack.
+const MaxDepth = 1000
+
+//Lexer lexes out XPath expressions.  The items are lexed as they are
+//requested with Next.
 type Lexer struct {
-	input string
-	start int
-	pos   int
-	width int
-	items chan XItem
+	input    string
+	start    int
+	pos      int
+	width    int
+	state    stateFn
+	items    []Token
+	err      *Error
+	done     bool
+	commaCtx []bool
 }
 
-//Lex an XPath expresion on the io.Reader
-func Lex(xpath string) chan XItem {
-	l := &Lexer{
+//NewLexer creates a lexer for the XPath expression.
+func NewLexer(xpath string) *Lexer {
+	return &Lexer{
 		input: xpath,
-		items: make(chan XItem),
+		state: startState,
 	}
-	go l.run()
-	return l.items
 }
 
-func (l *Lexer) run() {
-	for state := startState; state != nil; {
-		state = state(l)
+//Next returns the next item of the expression.  io.EOF is returned at the end
+//of the expression, and lexing errors are returned as an *Error.  No more
+//items are returned after an error.
+func (l *Lexer) Next() (XItem, error) {
+	tok, err := l.NextToken()
+	return tok.XItem, err
+}
+
+//NextToken is like Next, but includes the position of the item.  At the end of
+//the expression, and on errors, the position is the position of the end or the
+//error.
+func (l *Lexer) NextToken() (Token, error) {
+	for len(l.items) == 0 && !l.done {
+		l.step()
+	}
+
+	if len(l.items) > 0 {
+		ret := l.items[0]
+		l.items = l.items[1:]
+		return ret, nil
+	}
+
+	if l.err != nil {
+		return Token{XItem: XItem{XItemError, l.err.Msg}, Pos: l.err.Pos, End: l.err.End}, l.err
+	}
+
+	return Token{Pos: len(l.input), End: len(l.input)}, io.EOF
+}
+
+//step runs the current state, which emits zero or more items.
+func (l *Lexer) step() {
+	if l.state != nil {
+		l.state = l.state(l)
+		return
 	}
 
-	if l.peek() != eof {
+	if l.err == nil && l.peek() != eof {
 		l.errorf("Malformed XPath expression")
 	}
 
-	close(l.items)
+	l.done = true
+}
+
+//Lex an XPath expresion on the io.Reader.  The expression is tokenized before
+//Lex returns, and the channel is closed after the last item.
+//
+//Deprecated: Use NewLexer and Next, which don't allocate a channel.
+func Lex(xpath string) chan XItem {
+	var items []XItem
+	l := NewLexer(xpath)
+
+	for {
+		i, err := l.Next()
+		if err == io.EOF {
+			break
+		}
+
+		items = append(items, i)
+
+		if err != nil {
+			break
+		}
+	}
+
+	ret := make(chan XItem, len(items))
+	for _, i := range items {
+		ret <- i
+	}
+	close(ret)
+
+	return ret
 }
 
 func (l *Lexer) emit(t XItemType) {
-	l.items <- XItem{t, l.input[l.start:l.pos]}
-	l.start = l.pos
+	l.emitVal(t, l.input[l.start:l.pos])
 }
 
 func (l *Lexer) emitVal(t XItemType, val string) {
-	l.items <- XItem{t, val}
+	if l.err == nil {
+		l.items = append(l.items, Token{XItem: XItem{t, val}, Pos: l.start, End: l.pos})
+	}
 	l.start = l.pos
 }
 
@@ -145,13 +256,12 @@ func (l *Lexer) peekAt(n int) rune {
 	var ret rune
 
 	for count := 0; count < n; count++ {
-		r, s := utf8.DecodeRuneInString(l.input[l.pos+width:])
-		width += s
-
-		if l.pos+width > len(l.input) {
+		if l.pos+width >= len(l.input) {
 			return eof
 		}
 
+		r, s := utf8.DecodeRuneInString(l.input[l.pos+width:])
+		width += s
 		ret = r
 	}
 
@@ -196,12 +306,74 @@ func (l *Lexer) skipWS(ig bool) {
 	}
 }
 
+//pushComma sets whether the comma operator is allowed in the expression that
+//is about to be lexed.  Function arguments, for example, use the comma as a
+//separator instead.
+func (l *Lexer) pushComma(allowed bool) {
+	l.commaCtx = append(l.commaCtx, allowed)
+}
+
+func (l *Lexer) popComma() {
+	l.commaCtx = l.commaCtx[:len(l.commaCtx)-1]
+}
+
+//lexNested lexes an expression inside of parentheses, a predicate or a
+//function call.  It returns false if the expression is nested too deeply.
+func (l *Lexer) lexNested(commaAllowed bool) bool {
+	if len(l.commaCtx) >= MaxDepth {
+		l.errorf("Expression is nested more than %d levels deep", MaxDepth)
+		return false
+	}
+
+	l.pushComma(commaAllowed)
+	for state := startState; state != nil; {
+		state = state(l)
+	}
+	l.popComma()
+
+	return true
+}
+
+func (l *Lexer) commaAllowed() bool {
+	if len(l.commaCtx) == 0 {
+		return true
+	}
+	return l.commaCtx[len(l.commaCtx)-1]
+}
+
 func (l *Lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- XItem{
-		XItemError,
-		fmt.Sprintf(format, args...),
+	return l.fail(fmt.Errorf(format, args...))
+}
+
+//missingErr is an error for a token that is missing from the expression.
+type missingErr struct {
+	msg      string
+	expected []string
+}
+
+func (m missingErr) Error() string {
+	return m.msg
+}
+
+func missing(msg string, expected ...string) error {
+	return missingErr{msg: msg, expected: expected}
+}
+
+//fail stops the lexer with an error at the current item.  The items that
+//were emitted before the error are returned first.
+func (l *Lexer) fail(err error) stateFn {
+	if l.err != nil {
+		return nil
 	}
 
+	l.err = &Error{Msg: err.Error(), Pos: l.start, End: l.pos}
+
+	if m, ok := err.(missingErr); ok {
+		l.err.Expected = m.expected
+	}
+
+	l.done = true
+
 	return nil
 }
 
@@ -227,36 +399,25 @@ func startState(l *Lexer) stateFn {
 		return absLocPathState
 	} else if string(l.peek()) == `'` || string(l.peek()) == `"` {
 		if err := getStrLit(l, XItemStrLit); err != nil {
-			return l.errorf(err.Error())
+			return l.fail(err)
 		}
 
-		if l.peek() != eof {
-			return startState
-		}
+		return filterState
 	} else if getNumLit(l) {
-		l.skipWS(true)
-		if l.peek() != eof {
-			return startState
-		}
+		return filterState
 	} else if string(l.peek()) == "$" {
-		l.next()
-		l.ignore()
-		r := l.peek()
-		for unicode.Is(first, r) || unicode.Is(second, r) {
-			l.next()
-			r = l.peek()
-		}
-		tok := l.input[l.start:l.pos]
-		if len(tok) == 0 {
-			return l.errorf("Empty variable name")
-		}
-		l.emit(XItemVariable)
-		l.skipWS(true)
-		if l.peek() != eof {
-			return startState
+		if err := getVarName(l, XItemVariable); err != nil {
+			return l.fail(err)
 		}
-	} else if st := findOperatorState(l); st != nil {
+		return filterState
+	} else if st := findExprState(l); st != nil {
 		return st
+	} else if string(l.peek()) == "(" {
+		return parenState
+	} else if string(l.peek()) == "-" {
+		l.next()
+		l.emit(XItemOperator)
+		return startState
 	} else {
 		if isElemChar(l.peek()) {
 			colons := 0
@@ -276,25 +437,10 @@ func startState(l *Lexer) stateFn {
 				tok := l.input[l.start:l.pos]
 				err := procFunc(l, tok)
 				if err != nil {
-					return l.errorf(err.Error())
+					return l.fail(err)
 				}
 
-				l.skipWS(true)
-
-				if string(l.peek()) == "/" {
-					l.next()
-					l.ignore()
-
-					if string(l.next()) == "/" {
-						l.ignore()
-						return abbrRelLocPathState
-					}
-
-					l.backup()
-					return relLocPathState
-				}
-
-				return startState
+				return filterState
 			}
 
 			l.pos = l.start
@@ -307,6 +453,48 @@ func startState(l *Lexer) stateFn {
 	return nil
 }
 
+//parenState lexes a parenthesized expression, or an empty sequence.
+func parenState(l *Lexer) stateFn {
+	l.next()
+	l.emit(XItemOperator)
+	if !l.lexNested(true) {
+		return nil
+	}
+	l.skipWS(true)
+	if string(l.next()) != ")" {
+		return l.fail(missing("Missing end )", ")"))
+	}
+	l.emit(XItemOperator)
+	return filterState
+}
+
+//filterState lexes the predicates and path that can follow a primary
+//expression, such as $var[1]/foo, before moving on to the next operator.
+func filterState(l *Lexer) stateFn {
+	l.skipWS(true)
+
+	for string(l.peek()) == "[" {
+		if err := getPred(l); err != nil {
+			return l.fail(err)
+		}
+	}
+
+	if string(l.peek()) == "/" {
+		l.next()
+		l.ignore()
+
+		if string(l.next()) == "/" {
+			l.ignore()
+			return abbrRelLocPathState
+		}
+
+		l.backup()
+		return relLocPathState
+	}
+
+	return findOperatorState
+}
+
 func strPeek(str string, l *Lexer) bool {
 	for i := 0; i < len(str); i++ {
 		if string(l.peekAt(i+1)) != string(str[i]) {
@@ -316,13 +504,26 @@ func strPeek(str string, l *Lexer) bool {
 	return true
 }
 
+//keywordPeek is like strPeek, but the keyword must not be followed by a
+//character that would make it part of a larger name.
+func keywordPeek(kw string, l *Lexer) bool {
+	if !strPeek(kw, l) {
+		return false
+	}
+
+	r := l.peekAt(len(kw) + 1)
+	return r == eof || !(unicode.Is(first, r) || unicode.Is(second, r))
+}
+
+var keywordOps = []string{"and", "or", "mod", "div", "eq", "ne", "lt", "le", "gt", "ge", "is", "union", "intersect", "except", "to"}
+
 func findOperatorState(l *Lexer) stateFn {
 	l.skipWS(true)
 
 	switch string(l.peek()) {
 	case ">", "<", "!":
-		l.next()
-		if string(l.peek()) == "=" {
+		r := l.next()
+		if next := l.peek(); next == '=' || (next == r && r != '!') {
 			l.next()
 		}
 		l.emit(XItemOperator)
@@ -331,49 +532,23 @@ func findOperatorState(l *Lexer) stateFn {
 		l.next()
 		l.emit(XItemOperator)
 		return startState
-	case "(":
-		l.next()
-		l.emit(XItemOperator)
-		for state := startState; state != nil; {
-			state = state(l)
+	case ",":
+		if !l.commaAllowed() {
+			return nil
 		}
-		l.skipWS(true)
-		if string(l.next()) != ")" {
-			return l.errorf("Missing end )")
-		}
-		l.emit(XItemOperator)
-		return startState
-	}
-
-	if strPeek("and", l) {
-		l.next()
-		l.next()
-		l.next()
-		l.emit(XItemOperator)
-		return startState
-	}
-
-	if strPeek("or", l) {
-		l.next()
 		l.next()
 		l.emit(XItemOperator)
 		return startState
 	}
 
-	if strPeek("mod", l) {
-		l.next()
-		l.next()
-		l.next()
-		l.emit(XItemOperator)
-		return startState
-	}
-
-	if strPeek("div", l) {
-		l.next()
-		l.next()
-		l.next()
-		l.emit(XItemOperator)
-		return startState
+	for _, i := range keywordOps {
+		if keywordPeek(i, l) {
+			for range i {
+				l.next()
+			}
+			l.emit(XItemOperator)
+			return startState
+		}
 	}
 
 	return nil
@@ -388,7 +563,7 @@ func getStrLit(l *Lexer, tok XItemType) error {
 	for r != q {
 		r = l.next()
 		if r == eof {
-			return fmt.Errorf("Unexpected end of string literal.")
+			return missing("Unexpected end of string literal.", string(q))
 		}
 	}
 
@@ -402,7 +577,6 @@ func getStrLit(l *Lexer, tok XItemType) error {
 
 func getNumLit(l *Lexer) bool {
 	const dig = "0123456789"
-	l.accept("-")
 	start := l.pos
 	l.acceptRun(dig)
 
@@ -39,7 +39,7 @@ func stepState(l *Lexer) stateFn {
 
 	state, err := parseSeparators(l, tok)
 	if err != nil {
-		return l.errorf(err.Error())
+		return l.fail(err)
 	}
 
 	return getNextPathState(l, state)
@@ -53,7 +53,7 @@ func parseSeparators(l *Lexer, tok string) (XItemType, error) {
 	if string(r) == ":" && string(l.peekAt(2)) == ":" {
 		var err error
 		if state, err = getAxis(l, tok); err != nil {
-			return state, fmt.Errorf(err.Error())
+			return state, err
 		}
 	} else if string(r) == ":" {
 		state = XItemNCName
@@ -68,7 +68,7 @@ func parseSeparators(l *Lexer, tok string) (XItemType, error) {
 	} else if string(r) == "(" {
 		var err error
 		if state, err = getNT(l, tok); err != nil {
-			return state, fmt.Errorf(err.Error())
+			return state, err
 		}
 	} else if len(tok) > 0 {
 		l.emitVal(state, tok)
@@ -106,7 +106,7 @@ func getNT(l *Lexer, tok string) (XItemType, error) {
 		return procNT(l, tok)
 	}
 
-	return XItemError, fmt.Errorf("Invalid node-type " + tok)
+	return XItemError, fmt.Errorf("Invalid node-type %s", tok)
 }
 
 func procNT(l *Lexer, tok string) (XItemType, error) {
@@ -117,14 +117,14 @@ func procNT(l *Lexer, tok string) (XItemType, error) {
 	n := l.peek()
 	if tok == xconst.NodeTypeProcInst && (string(n) == `"` || string(n) == `'`) {
 		if err := getStrLit(l, XItemProcLit); err != nil {
-			return state, fmt.Errorf(err.Error())
+			return state, err
 		}
 		l.skipWS(true)
 		n = l.next()
 	}
 
 	if string(n) != ")" {
-		return state, fmt.Errorf("Missing ) at end of NodeType declaration.")
+		return state, missing("Missing ) at end of NodeType declaration.", ")")
 	}
 
 	l.skip(1)
@@ -139,8 +139,8 @@ func procFunc(l *Lexer, tok string) error {
 	if string(l.peek()) != ")" {
 		l.emit(XItemArgument)
 		for {
-			for state := startState; state != nil; {
-				state = state(l)
+			if !l.lexNested(false) {
+				return l.err
 			}
 			l.skipWS(true)
 
@@ -151,8 +151,8 @@ func procFunc(l *Lexer, tok string) error {
 				l.emit(XItemEndFunction)
 				l.skip(1)
 				break
-			} else if l.peek() == eof {
-				return fmt.Errorf("Missing ) at end of function declaration.")
+			} else {
+				return missing("Missing ) at end of function declaration.", ",", ")")
 			}
 		}
 	} else {
@@ -170,7 +170,7 @@ func getNextPathState(l *Lexer, state XItemType) stateFn {
 
 	for string(l.peek()) == "[" {
 		if err := getPred(l); err != nil {
-			return l.errorf(err.Error())
+			return l.fail(err)
 		}
 	}
 
@@ -203,13 +203,13 @@ func getPred(l *Lexer) error {
 		return fmt.Errorf("Missing content in predicate.")
 	}
 
-	for state := startState; state != nil; {
-		state = state(l)
+	if !l.lexNested(true) {
+		return l.err
 	}
 
 	l.skipWS(true)
 	if string(l.peek()) != "]" {
-		return fmt.Errorf("Missing ] at end of predicate.")
+		return missing("Missing ] at end of predicate.", "]")
 	}
 	l.skip(1)
 	l.emit(XItemEndPredicate)
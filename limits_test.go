@@ -0,0 +1,116 @@
+package goxpath
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+)
+
+func bigDoc(n int) tree.Node {
+	buf := bytes.NewBufferString(`<?xml version="1.0" encoding="UTF-8"?><root>`)
+	for i := 0; i < n; i++ {
+		buf.WriteString("<a><b/><c/></a>")
+	}
+	buf.WriteString("</root>")
+	return xmltree.MustParseXML(buf)
+}
+
+func execLimit(xp string, kind LimitKind, opts func(*Opts), t *testing.T) {
+	_, err := MustParse(xp).Exec(bigDoc(10), opts)
+
+	var lim *LimitError
+	if !errors.As(err, &lim) {
+		t.Errorf("Expecting a limit error for '%s': %v", xp, err)
+		return
+	}
+
+	if lim.Kind != kind {
+		t.Errorf("Incorrect limit for '%s': %s, expecting %s", xp, lim.Kind, kind)
+	}
+}
+
+func TestExecContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := MustParse(`/root`).ExecContext(ctx, bigDoc(1))
+	if !errors.Is(err, context.Canceled) {
+		t.Error("Expecting a cancellation error:", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = MustParse(`//*[count(//*) > count(//*//*)]`).ExecContext(ctx, bigDoc(2000))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expecting a deadline error:", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Error("The execution was not stopped in time:", time.Since(start))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start = time.Now()
+	_, err = MustParse(`count(1 to 20000000)`).ExecContext(ctx, bigDoc(1))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expecting a deadline error:", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Error("The range was not stopped in time:", time.Since(start))
+	}
+
+	res, err := MustParse(`count(//a)`).ExecContext(context.Background(), bigDoc(3))
+	if err != nil || res.String() != "3" {
+		t.Error("Incorrect result:", res, err)
+	}
+}
+
+func TestMaxNodes(t *testing.T) {
+	max := func(o *Opts) { o.MaxNodes = 10 }
+	execLimit(`//*`, LimitNodes, max, t)
+	execLimit(`/root/a/*`, LimitNodes, max, t)
+	execLimit(`1 to 11`, LimitNodes, max, t)
+	execLimit(`count(-9000000000000000000 to 9000000000000000000)`, LimitNodes, max, t)
+	execLimit(`for $x in 1 to 5 return ($x, $x, $x)`, LimitNodes, max, t)
+
+	_, err := MustParse(`/root/a`).Exec(bigDoc(10), max)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = MustParse(`//*`).Exec(bigDoc(10), func(o *Opts) { o.MaxNodes = 10 })
+	if err == nil || err.Error() != "Exceeded the maximum node-set size of 10" {
+		t.Error("Incorrect error:", err)
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	max := func(o *Opts) { o.MaxSteps = 20 }
+	execLimit(`//a[b]`, LimitSteps, max, t)
+	execLimit(`sum(for $x in 1 to 100 return $x)`, LimitSteps, max, t)
+
+	if _, err := MustParse(`/root/a/b`).Exec(bigDoc(10), max); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	max := func(o *Opts) { o.MaxDepth = 10 }
+	execLimit(strings.Repeat("1, (", 20)+"1"+strings.Repeat(")", 20), LimitDepth, max, t)
+	execLimit(`/root`+strings.Repeat("[.", 20)+strings.Repeat("]", 20), LimitDepth, max, t)
+
+	if _, err := MustParse(`/root/a[b][c]`).Exec(bigDoc(10), max); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,45 @@
+package goxpath
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlele"
+)
+
+func TestLocation(t *testing.T) {
+	x := "<?xml version=\"1.0\"?>\n<r>\n  <a id=\"1\">text</a>\n  <!--c--><b/>\n</r>"
+	n := xmltree.MustParseXML(bytes.NewBufferString(x))
+
+	locs := map[string]tree.Location{
+		`/r`:           {Line: 2, Col: 1, Offset: 22},
+		`/r/a`:         {Line: 3, Col: 3, Offset: 28},
+		`/r/a/@id`:     {Line: 3, Col: 3, Offset: 28},
+		`/r/a/text()`:  {Line: 3, Col: 13, Offset: 38},
+		`/r/comment()`: {Line: 4, Col: 3, Offset: 49},
+		`/r/b`:         {Line: 4, Col: 11, Offset: 57},
+	}
+
+	for xp, exp := range locs {
+		res := MustParse(xp).MustExec(n).(tree.NodeSet)
+		if len(res) != 1 {
+			t.Errorf("Incorrect number of results for %s: %d", xp, len(res))
+			continue
+		}
+
+		loc := res[0].(tree.LocatedNode).GetLocation()
+		if loc != exp {
+			t.Errorf("Incorrect location for %s: %v.  Expecting: %v", xp, loc, exp)
+		}
+		if x[loc.Offset:loc.Offset+1] != "<" && res[0].GetNodeType() != tree.NtChd {
+			t.Errorf("Offset of %s is not the start of a tag: %d", xp, loc.Offset)
+		}
+	}
+
+	b := xmlele.Elem("r").MustBuild().(*xmlele.XMLEle)
+	if loc := b.Children[0].(tree.LocatedNode).GetLocation(); loc != (tree.Location{}) {
+		t.Errorf("Built node has a location: %v", loc)
+	}
+}
@@ -1,27 +1,60 @@
 package goxpath
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/xml"
+	"fmt"
 	"io"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/ChrisTrenkamp/goxpath/tree"
 )
 
+//MarshalOpts are the options of Marshal.  By default, each element declares
+//its namespace as the default namespace, and the prolog of a document is not
+//written.
+type MarshalOpts struct {
+	//Prolog writes the XML declaration and the document type declarations of
+	//a root node that implements tree.PrologElem.  The encoding of the XML
+	//declaration is changed to UTF-8, since the document is written in UTF-8.
+	Prolog bool
+	//Prefixes writes the names of the elements and attributes with the
+	//namespace prefixes declared in the tree, and writes the declarations of
+	//elements that implement tree.NSElem.
+	Prefixes bool
+	//EmptyTags writes elements without children as empty-element tags.
+	EmptyTags bool
+}
+
+//MarshalFuncOpts is a function wrapper for MarshalOpts.
+type MarshalFuncOpts func(*MarshalOpts)
+
 //Marshal prints the result tree, r, in XML form to w.
-func Marshal(n tree.Node, w io.Writer) error {
-	return marshal(n, w)
+func Marshal(n tree.Node, w io.Writer, opts ...MarshalFuncOpts) error {
+	return marshal(n, w, opts)
 }
 
 //MarshalStr is like Marhal, but returns a string.
-func MarshalStr(n tree.Node) (string, error) {
+func MarshalStr(n tree.Node, opts ...MarshalFuncOpts) (string, error) {
 	ret := bytes.NewBufferString("")
-	err := marshal(n, ret)
+	err := marshal(n, ret, opts)
 
 	return ret.String(), err
 }
 
-func marshal(n tree.Node, w io.Writer) error {
+func marshal(n tree.Node, w io.Writer, opts []MarshalFuncOpts) error {
+	o := MarshalOpts{}
+	for _, i := range opts {
+		i(&o)
+	}
+
+	if o != (MarshalOpts{}) {
+		return marshalOpts(n, w, o)
+	}
+
 	e := xml.NewEncoder(w)
 	err := encTok(n, e)
 	if err != nil {
@@ -103,3 +136,224 @@ func encEle(n tree.Elem, e *xml.Encoder) error {
 
 	return e.EncodeToken(ele.End())
 }
+
+//marshaler writes the tags of the elements itself, since xml.Encoder can't
+//write prefixes or empty-element tags.  The other nodes are encoded with e,
+//which writes to the same buffer.
+type marshaler struct {
+	MarshalOpts
+	w *bufio.Writer
+	e *xml.Encoder
+}
+
+func marshalOpts(n tree.Node, w io.Writer, o MarshalOpts) error {
+	m := &marshaler{MarshalOpts: o, w: bufio.NewWriter(w)}
+	m.e = xml.NewEncoder(m.w)
+
+	if err := m.node(n, make(map[xml.Name]string)); err != nil {
+		return err
+	}
+
+	if err := m.e.Flush(); err != nil {
+		return err
+	}
+
+	return m.w.Flush()
+}
+
+//write writes str after the tokens that were encoded with e.
+func (m *marshaler) write(str string) {
+	m.e.Flush()
+	m.w.WriteString(str)
+}
+
+//node writes n.  scope holds the namespace declarations of n's parent.
+func (m *marshaler) node(n tree.Node, scope map[xml.Name]string) error {
+	switch n.GetNodeType() {
+	case tree.NtRoot:
+		return m.root(n.(tree.Elem))
+	case tree.NtElem:
+		return m.elem(n.(tree.Elem), scope)
+	}
+
+	return encTok(n, m.e)
+}
+
+//root writes the children of the root node.  The prolog is written in its
+//original place, but always before the document element.
+func (m *marshaler) root(n tree.Elem) error {
+	var prolog []tree.PrologToken
+	if p, ok := n.(tree.PrologElem); ok && m.Prolog {
+		prolog = p.GetProlog()
+	}
+
+	writeProlog := func(index int) {
+		for len(prolog) > 0 && (index < 0 || prolog[0].Index <= index) {
+			m.write(prologString(prolog[0].Token))
+			prolog = prolog[1:]
+		}
+	}
+
+	for i, c := range n.GetChildren() {
+		if c.GetNodeType() == tree.NtElem {
+			writeProlog(-1)
+		}
+		writeProlog(i)
+
+		if err := m.node(c, make(map[xml.Name]string)); err != nil {
+			return err
+		}
+	}
+
+	writeProlog(-1)
+
+	return nil
+}
+
+var declEncoding = regexp.MustCompile(`(encoding\s*=\s*)(["'])([^"']*)["']`)
+
+func prologString(tok xml.Token) string {
+	if dir, ok := tok.(xml.Directive); ok {
+		return "<!" + string(dir) + ">"
+	}
+
+	inst := declEncoding.ReplaceAllStringFunc(string(tok.(xml.ProcInst).Inst), func(enc string) string {
+		m := declEncoding.FindStringSubmatch(enc)
+		if strings.EqualFold(m[3], "utf-8") {
+			return enc
+		}
+		return m[1] + m[2] + "UTF-8" + m[2]
+	})
+
+	return "<?xml " + inst + "?>"
+}
+
+func (m *marshaler) elem(n tree.Elem, scope map[xml.Name]string) error {
+	inner := make(map[xml.Name]string, len(scope))
+	for k, v := range scope {
+		inner[k] = v
+	}
+
+	//Parsed elements have all of the declarations in their scope, so only the
+	//ones that differ from the parent's scope are written
+	var decls []xml.Name
+	if nsEle, ok := n.(tree.NSElem); ok && m.Prefixes {
+		for k, v := range nsEle.GetNS() {
+			if k.Space == "xmlns" && k.Local == "xml" {
+				continue
+			}
+			if old, ok := scope[k]; ok && old == v || !ok && v == "" {
+				continue
+			}
+			inner[k] = v
+			decls = append(decls, k)
+		}
+	}
+
+	name := elemName(n.GetToken().(xml.StartElement).Name, inner, &decls)
+
+	var attrs []string
+	for _, i := range n.GetAttrs() {
+		attr := i.GetToken().(xml.Attr)
+		attrs = append(attrs, attrName(attr.Name, inner, &decls)+`="`+escapeAttr(attr.Value)+`"`)
+	}
+
+	sort.Slice(decls, func(i, j int) bool {
+		return decls[i].Space < decls[j].Space || decls[i].Space == decls[j].Space && decls[i].Local < decls[j].Local
+	})
+
+	tag := "<" + name
+	for _, i := range decls {
+		qname := "xmlns"
+		if i.Space == "xmlns" {
+			qname += ":" + i.Local
+		}
+		tag += " " + qname + `="` + escapeAttr(inner[i]) + `"`
+	}
+	for _, i := range attrs {
+		tag += " " + i
+	}
+
+	children := n.GetChildren()
+	if len(children) == 0 && m.EmptyTags {
+		m.write(tag + "/>")
+		return nil
+	}
+
+	m.write(tag + ">")
+	for _, c := range children {
+		if err := m.node(c, inner); err != nil {
+			return err
+		}
+	}
+	m.write("</" + name + ">")
+
+	return nil
+}
+
+//elemName returns the qualified name of an element.  The namespace is declared
+//as the default namespace if it is not bound to a prefix.
+func elemName(name xml.Name, scope map[xml.Name]string, decls *[]xml.Name) string {
+	def := xml.Name{Local: "xmlns"}
+
+	if scope[def] == name.Space {
+		return name.Local
+	}
+
+	if prefix, ok := boundPrefix(name.Space, scope); ok {
+		return prefix + ":" + name.Local
+	}
+
+	scope[def] = name.Space
+	*decls = append(*decls, def)
+	return name.Local
+}
+
+//attrName returns the qualified name of an attribute.  A prefix is declared
+//if the namespace is not bound to one.
+func attrName(name xml.Name, scope map[xml.Name]string, decls *[]xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	if prefix, ok := boundPrefix(name.Space, scope); ok {
+		return prefix + ":" + name.Local
+	}
+
+	for i := 1; ; i++ {
+		prefix := xml.Name{Space: "xmlns", Local: fmt.Sprintf("ns%d", i)}
+		if _, ok := scope[prefix]; !ok {
+			scope[prefix] = name.Space
+			*decls = append(*decls, prefix)
+			return prefix.Local + ":" + name.Local
+		}
+	}
+}
+
+//boundPrefix returns the prefix in scope that is bound to url.  If there are
+//several, the first one in alphabetical order is returned.
+func boundPrefix(url string, scope map[xml.Name]string) (string, bool) {
+	if url == tree.XMLSpace {
+		return "xml", true
+	}
+
+	var prefixes []string
+	for k, v := range scope {
+		if k.Space == "xmlns" && v == url {
+			prefixes = append(prefixes, k.Local)
+		}
+	}
+
+	if len(prefixes) == 0 {
+		return "", false
+	}
+
+	sort.Strings(prefixes)
+	return prefixes[0], true
+}
+
+func escapeAttr(val string) string {
+	buf := &bytes.Buffer{}
+	xml.EscapeText(buf, []byte(val))
+	return buf.String()
+}
@@ -0,0 +1,43 @@
+package goxpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlele"
+)
+
+func TestMarshalOpts(t *testing.T) {
+	x := "<?xml version='1.0' encoding='ISO-8859-1'?>\n<!--c-->\n<!DOCTYPE r [<!ATTLIST p:a id ID #IMPLIED>]>\n<r xmlns:p=\"http://p\"><p:a id=\"1\" p:b=\"&quot;\"></p:a><c xmlns=\"http://c\"><d/></c></r>"
+	n := xmltree.MustParseXML(bytes.NewBufferString(x))
+
+	tests := []struct {
+		opts func(*MarshalOpts)
+		exp  string
+	}{
+		{func(o *MarshalOpts) {},
+			"\n<!--c-->\n\n" + `<r><a xmlns="http://p" id="1" xmlns:p="http://p" p:b="&#34;"></a><c xmlns="http://c"><d xmlns="http://c"></d></c></r>`},
+		{func(o *MarshalOpts) { o.Prolog = true },
+			"<?xml version='1.0' encoding='UTF-8'?>\n<!--c-->\n<!DOCTYPE r [<!ATTLIST p:a id ID #IMPLIED>]>\n" + `<r><a xmlns="http://p" xmlns:ns1="http://p" id="1" ns1:b="&#34;"></a><c xmlns="http://c"><d></d></c></r>`},
+		{func(o *MarshalOpts) { o.Prefixes = true; o.EmptyTags = true },
+			"\n<!--c-->\n\n" + `<r xmlns:p="http://p"><p:a id="1" p:b="&#34;"/><c xmlns="http://c"><d/></c></r>`},
+	}
+
+	for _, i := range tests {
+		str, err := MarshalStr(n, i.opts)
+		if err != nil {
+			t.Error(err)
+		}
+		if str != i.exp {
+			t.Errorf("Incorrect result: %s.  Expecting: %s", str, i.exp)
+		}
+	}
+
+	ele := xmlele.NewElem(xml.Name{Space: "http://e", Local: "e"}, xml.Attr{Name: xml.Name{Space: tree.XMLSpace, Local: "lang"}, Value: "en"})
+	if str, _ := MarshalStr(ele, func(o *MarshalOpts) { o.EmptyTags = true }); str != `<e xmlns="http://e" xml:lang="en"/>` {
+		t.Error("Incorrect result:", str)
+	}
+}
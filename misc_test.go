@@ -3,6 +3,10 @@ package goxpath
 import (
 	"bytes"
 	"encoding/xml"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/ChrisTrenkamp/goxpath/lexer"
@@ -107,7 +111,7 @@ func TestVariable(t *testing.T) {
 	xp := MustParse(`/p1/p2`)
 	res := xp.MustExec(x)
 	opt := func(o *Opts) {
-		o.Vars["prev"] = res
+		o.Vars[xml.Name{Local: "prev"}] = res
 	}
 	xp = MustParse(`$prev = 'foo'`)
 	if res, err := xp.ExecBool(x, opt); err != nil || !res {
@@ -121,6 +125,61 @@ func TestVariable(t *testing.T) {
 	}
 }
 
+func TestNamespacedVariable(t *testing.T) {
+	x := xmltree.MustParseXML(bytes.NewBufferString(xml.Header + "<p1/>"))
+	opt := func(o *Opts) {
+		o.NS["a"] = "http://a"
+		o.NS["b"] = "http://b"
+		o.NS["c"] = "http://a"
+		o.Vars[xml.Name{Space: "http://a", Local: "x"}] = tree.Num(1)
+		o.Vars[xml.Name{Space: "http://b", Local: "x"}] = tree.Num(2)
+	}
+
+	vals := map[string]string{
+		`$a:x + $b:x * 10`:                      "21",
+		`$c:x`:                                  "1",
+		`for $c:i in (1, 2) return $a:i + $c:x`: "2 3",
+		`let $b:x := 5 return $a:x + $b:x`:      "6",
+	}
+
+	for xp, exp := range vals {
+		res, err := MustParse(xp).ExecSeq(x, opt)
+		if err != nil {
+			t.Errorf("Error executing '%s': %s", xp, err)
+			continue
+		}
+
+		strs := []string{}
+		for _, i := range res {
+			strs = append(strs, i.String())
+		}
+
+		if strings.Join(strs, " ") != exp {
+			t.Errorf("Incorrect result for '%s': %v", xp, strs)
+		}
+	}
+
+	errs := map[string]string{
+		`$x`:                     "Invalid variable 'x'",
+		`$d:x`:                   "Unknown namespace prefix: d",
+		`for $d:i in 1 return 1`: "Unknown namespace prefix: d",
+	}
+
+	for xp, exp := range errs {
+		if _, err := MustParse(xp).Exec(x, opt); err == nil || err.Error() != exp {
+			t.Errorf("Incorrect error for '%s': %v", xp, err)
+		}
+	}
+
+	if _, err := Compile(`$a:x + $d:x + (for $e:i in 1 return 1)`, opt); err == nil || err.Error() != "1:8: Unknown namespace prefix: d\n1:20: Unknown namespace prefix: e" {
+		t.Error("Incorrect compile error:", err)
+	}
+
+	if res := MustParse(`$c:x`).Explain(opt); res != "variable $c:x (resolved to {http://a}x)" {
+		t.Error("Incorrect plan:", res)
+	}
+}
+
 func TestFunctionInteractions(t *testing.T) {
 	cases := []struct {
 		name     string
@@ -236,3 +295,70 @@ func isEquivalentAST(node1, node2 *parser.Node) bool {
 	}
 	return false
 }
+
+func TestLexerNext(t *testing.T) {
+	l := lexer.NewLexer(`/a[1]`)
+	items := []lexer.XItem{}
+	for {
+		i, err := l.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, i)
+	}
+
+	exp := []lexer.XItem{
+		{Typ: lexer.XItemAbsLocPath},
+		{Typ: lexer.XItemQName, Val: "a"},
+		{Typ: lexer.XItemPredicate},
+		{Typ: lexer.XItemNumLit, Val: "1"},
+		{Typ: lexer.XItemEndPredicate},
+		{Typ: lexer.XItemEndPath},
+	}
+	if !reflect.DeepEqual(items, exp) {
+		t.Error("Incorrect items:", items)
+	}
+
+	l = lexer.NewLexer(`a[1 + "b`)
+	for i := 0; i < 5; i++ {
+		if _, err := l.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := l.Next()
+	e, ok := err.(*lexer.Error)
+	if !ok || e.Msg != "Unexpected end of string literal." || e.Pos != 7 || !reflect.DeepEqual(e.Expected, []string{`"`}) {
+		t.Error("Incorrect error:", err)
+	}
+
+	if _, err = l.Next(); err != e {
+		t.Error("The error was not repeated:", err)
+	}
+}
+
+func TestParseNoGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		Parse(`/a[1 +`)
+		Parse(`) + /a/b/c/d/e`)
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Error("Parse left goroutines running:", after-before)
+	}
+
+	before = runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		if item := <-lexer.Lex(`/a/b/c`); item.Typ != lexer.XItemAbsLocPath {
+			t.Error("Incorrect item:", item)
+		}
+	}
+
+	if after := runtime.NumGoroutine(); after > before {
+		t.Error("Lex left goroutines running:", after-before)
+	}
+}
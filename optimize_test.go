@@ -0,0 +1,72 @@
+package goxpath
+
+import (
+	"testing"
+
+	"github.com/ChrisTrenkamp/goxpath/ast"
+)
+
+func execOpt(xp, exp string, t *testing.T) {
+	res := ast.Format(ast.FromNode(MustParse(xp).opt), false)
+	if res != exp {
+		t.Errorf("Incorrect optimized expression for '%s': %s.  Expecting: %s", xp, res, exp)
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	execOpt(`1 + 2 * 3`, `7`, t)
+	execOpt(`-(1 + 2)`, `-3`, t)
+	execOpt(`concat('a', 'b', 1 + 1)`, `'ab2'`, t)
+	execOpt(`not(1 = 2) and 'a' != 'b'`, `true()`, t)
+	execOpt(`1 div 0`, `+Inf`, t)
+	execOpt(`/a[string-length('abc') + 1]`, `/child::a[4]`, t)
+	execOpt(`$x + 1 * 2`, `$x + 2`, t)
+	execOpt(`string()`, `string()`, t)
+	execOpt(`string-length(a)`, `string-length(child::a)`, t)
+	execOpt(`(1, 2)`, `1, 2`, t)
+	execOpt(`position() + 1`, `position() + 1`, t)
+
+	if MustParse(`1 + 2`).String() != `1 + 2` {
+		t.Error("The original expression was not kept")
+	}
+}
+
+func TestAbbrPathRewrite(t *testing.T) {
+	execOpt(`//a`, `/descendant::a`, t)
+	execOpt(`//p:a/b`, `/descendant::p:a/child::b`, t)
+	execOpt(`a//child::b`, `child::a/descendant::b`, t)
+	execOpt(`//text()`, `/descendant::text()`, t)
+	execOpt(`//a[@id = 'x'][b]`, `/descendant::a[attribute::id = 'x'][child::b]`, t)
+	execOpt(`//a[b[1]]`, `/descendant::a[child::b[1]]`, t)
+	execOpt(`//a[1]`, `/descendant-or-self::node()/child::a[1]`, t)
+	execOpt(`//a[last()]`, `/descendant-or-self::node()/child::a[last()]`, t)
+	execOpt(`//a[position() = 1]`, `/descendant-or-self::node()/child::a[position() = 1]`, t)
+	execOpt(`//a[$x]`, `/descendant-or-self::node()/child::a[$x]`, t)
+	execOpt(`//a[contains(., 'x')][2]`, `/descendant-or-self::node()/child::a[contains(self::node(), 'x')][2]`, t)
+	execOpt(`//@a`, `/descendant-or-self::node()/attribute::a`, t)
+	execOpt(`//.`, `/descendant-or-self::node()/self::node()`, t)
+	execOpt(`//descendant::a`, `/descendant-or-self::node()/descendant::a`, t)
+}
+
+func TestOptimizedResults(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><r><a id="1"><a id="2"/><b/></a><c><a id="3"><b/></a><a id="4"/></c></r>`
+	execPath(`//a[1]/@id`, x, []string{`<?attribute id="1"?>`, `<?attribute id="2"?>`, `<?attribute id="3"?>`}, nil, t)
+	execPath(`//a[last()]/@id`, x, []string{`<?attribute id="1"?>`, `<?attribute id="2"?>`, `<?attribute id="4"?>`}, nil, t)
+	execPath(`//a[position() = last()]/@id`, x, []string{`<?attribute id="1"?>`, `<?attribute id="2"?>`, `<?attribute id="4"?>`}, nil, t)
+	execPath(`//a[position() = 1]/@id`, x, []string{`<?attribute id="1"?>`, `<?attribute id="2"?>`, `<?attribute id="3"?>`}, nil, t)
+	execPath(`(//a)[1]/@id`, x, []string{`<?attribute id="1"?>`}, nil, t)
+	execPath(`(//a)[last()]/@id`, x, []string{`<?attribute id="4"?>`}, nil, t)
+	execPath(`//a[b]/@id`, x, []string{`<?attribute id="1"?>`, `<?attribute id="3"?>`}, nil, t)
+	execPath(`/r//a[@id > 1 + 1]/@id`, x, []string{`<?attribute id="3"?>`, `<?attribute id="4"?>`}, nil, t)
+	execPath(`//a[1 + 1]/@id`, x, []string{`<?attribute id="4"?>`}, nil, t)
+	execPath(`/r/c/a[3 - 2]/@id`, x, []string{`<?attribute id="3"?>`}, nil, t)
+}
+
+func TestChainedPredicates(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><r><c><d>x</d><d>y</d><d>z</d></c><c><d>v</d><d>w</d></c></r>`
+	execPath(`//c/d[position() < 3][1]`, x, []string{`<d>x</d>`, `<d>v</d>`}, nil, t)
+	execPath(`//c/d[position() < 3][last()]`, x, []string{`<d>y</d>`, `<d>w</d>`}, nil, t)
+	execPath(`//c/d[. != 'x'][position() = 1]`, x, []string{`<d>y</d>`, `<d>v</d>`}, nil, t)
+	execPath(`//d[last()]/preceding-sibling::d[. != 'y'][1]`, x, []string{`<d>x</d>`, `<d>v</d>`}, nil, t)
+	execPath(`(//c/d[position() < 3])[last()]`, x, []string{`<d>w</d>`}, nil, t)
+}
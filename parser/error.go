@@ -0,0 +1,96 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+//Error is an error at a position in an XPath expression.  It is returned for
+//syntax errors, and for errors that occur while executing a node of the
+//expression, such as references to unknown variables or functions.  Offset is
+//the byte offset of the error, and Line and Col are its 1-based line and
+//column.  Token is the offending text, and Expected lists the tokens that were
+//expected instead, if they are known.
+type Error struct {
+	Expr     string
+	Offset   int
+	Line     int
+	Col      int
+	Token    string
+	Expected []string
+	Err      error
+}
+
+//NewError creates an Error at the byte offset of the XPath expression, xp.
+func NewError(xp string, offset int, tok string, expected []string, err error) *Error {
+	if offset > len(xp) {
+		offset = len(xp)
+	}
+
+	lineStart := strings.LastIndex(xp[:offset], "\n") + 1
+
+	return &Error{
+		Expr:     xp,
+		Offset:   offset,
+		Line:     strings.Count(xp[:offset], "\n") + 1,
+		Col:      utf8.RuneCountInString(xp[lineStart:offset]) + 1,
+		Token:    tok,
+		Expected: expected,
+		Err:      err,
+	}
+}
+
+//Error returns the error message, without the position.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+//Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+//Caret returns the line of the expression that contains the error, followed
+//by a line with a caret under the error's position.
+func (e *Error) Caret() string {
+	start := strings.LastIndex(e.Expr[:e.Offset], "\n") + 1
+	end := len(e.Expr)
+	if i := strings.Index(e.Expr[e.Offset:], "\n"); i >= 0 {
+		end = e.Offset + i
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(strings.TrimSuffix(e.Expr[start:end], "\r"))
+	buf.WriteString("\n")
+
+	//Tabs are kept so the caret lines up with the text above it
+	for _, r := range e.Expr[start:e.Offset] {
+		if r == '\t' {
+			buf.WriteRune(r)
+		} else {
+			buf.WriteRune(' ')
+		}
+	}
+	buf.WriteString("^")
+
+	return buf.String()
+}
+
+//ErrorList is a list of errors in an XPath expression, sorted by position.
+type ErrorList []*Error
+
+func (e ErrorList) Len() int           { return len(e) }
+func (e ErrorList) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e ErrorList) Less(i, j int) bool { return e[i].Offset < e[j].Offset }
+
+//Error returns the errors on separate lines, prefixed with their line and
+//column.
+func (e ErrorList) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = fmt.Sprintf("%d:%d: %s", err.Line, err.Col, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -1,200 +1,556 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/ChrisTrenkamp/goxpath/lexer"
 )
 
-type stateType int
+//itemEOF is returned by the parser's lookahead at the end of the input, or
+//after the lexer has reported an error.
+const itemEOF lexer.XItemType = "end of expression"
 
+var opPrecedence = map[string]int{
+	"intersect": 1,
+	"except":    1,
+	"|":         2,
+	"union":     2,
+	"*":         3,
+	"div":       3,
+	"mod":       3,
+	"+":         4,
+	"-":         4,
+	"to":        5,
+	"=":         6,
+	"!=":        6,
+	"<":         6,
+	"<=":        6,
+	">":         6,
+	">=":        6,
+	"eq":        6,
+	"ne":        6,
+	"lt":        6,
+	"le":        6,
+	"gt":        6,
+	"ge":        6,
+	"is":        6,
+	"<<":        6,
+	">>":        6,
+	"and":       7,
+	"or":        8,
+	",":         9,
+}
+
+//The precedences used by the parser.  Larger precedences bind more loosely,
+//so operands with a larger precedence than their operator need parentheses.
 const (
-	defState stateType = iota
-	xpathState
-	funcState
-	paramState
-	predState
-	parenState
+	//MaxPrecedence is the precedence of the comma operator, and the
+	//expressions that take the rest of the expression as their operand, such
+	//as for and if.
+	MaxPrecedence = 9
+	//SinglePrecedence is the loosest precedence that can be used where a
+	//comma separates expressions, such as function arguments.
+	SinglePrecedence = 8
+	//CompPrecedence is the precedence of the comparison operators.  They are
+	//not associative.
+	CompPrecedence = 6
+	//UnionPrecedence is the loosest precedence of the operand of a negation.
+	UnionPrecedence = 2
 )
 
+//Precedence returns the precedence of a binary operator, or 0 if op is not a
+//binary operator.
+func Precedence(op string) int {
+	return opPrecedence[op]
+}
+
+var stepTypes = map[lexer.XItemType]bool{
+	lexer.XItemAxis:     true,
+	lexer.XItemAbbrAxis: true,
+	lexer.XItemNCName:   true,
+	lexer.XItemQName:    true,
+	lexer.XItemNodeType: true,
+	lexer.XItemProcLit:  true,
+}
+
+//partialStepTypes are the items that must be followed by a node test.
+var partialStepTypes = map[lexer.XItemType]bool{
+	lexer.XItemAxis:     true,
+	lexer.XItemAbbrAxis: true,
+	lexer.XItemNCName:   true,
+}
+
+var pathTypes = map[lexer.XItemType]bool{
+	lexer.XItemAbsLocPath:     true,
+	lexer.XItemAbbrAbsLocPath: true,
+	lexer.XItemRelLocPath:     true,
+	lexer.XItemAbbrRelLocPath: true,
+}
+
+//parseStack reads the lexer's items and builds the AST with a recursive
+//descent parser.
 type parseStack struct {
-	stack      []*Node
-	stateTypes []stateType
-	cur        *Node
+	input  string
+	lex    *lexer.Lexer
+	cur    lexer.Token
+	peeked bool
+	lexErr error
+	depth  int
+}
+
+func (p *parseStack) peek() lexer.Token {
+	if p.peeked {
+		return p.cur
+	}
+
+	p.peeked = true
+	p.cur = lexer.Token{XItem: lexer.XItem{Typ: itemEOF}, Pos: len(p.input), End: len(p.input)}
+
+	if p.lexErr != nil {
+		return p.cur
+	}
+
+	next, err := p.lex.NextToken()
+	if err == nil {
+		p.cur = next
+	} else if e, ok := err.(*lexer.Error); ok {
+		p.lexErr = p.errorAt(next, e.Expected, errors.New(e.Msg))
+	}
+
+	return p.cur
 }
 
-func (p *parseStack) push(t stateType) {
-	p.stack = append(p.stack, p.cur)
-	p.stateTypes = append(p.stateTypes, t)
+func (p *parseStack) next() lexer.Token {
+	ret := p.peek()
+	p.peeked = false
+	return ret
+}
+
+func (p *parseStack) expect(typ lexer.XItemType, val string) error {
+	i := p.next()
+	if i.Typ != typ || i.Val != val {
+		return p.unexpected(i, val)
+	}
+	return nil
 }
 
-func (p *parseStack) pop() {
-	stackPos := len(p.stack) - 1
+//drain consumes the rest of the lexer's items, keeping the first error.
+func (p *parseStack) drain() {
+	if p.lexErr != nil {
+		return
+	}
+
+	for {
+		next, err := p.lex.NextToken()
+		if e, ok := err.(*lexer.Error); ok {
+			p.lexErr = p.errorAt(next, e.Expected, errors.New(e.Msg))
+		}
 
-	p.cur = p.stack[stackPos]
-	p.stack = p.stack[:stackPos]
-	p.stateTypes = p.stateTypes[:stackPos]
+		if err != nil {
+			return
+		}
+	}
 }
 
-func (p *parseStack) curState() stateType {
-	if len(p.stateTypes) == 0 {
-		return defState
+//errorAt creates an Error for the token.  If the token is empty, the error's
+//token is the character at its position.
+func (p *parseStack) errorAt(i lexer.Token, expected []string, err error) error {
+	tok := strings.TrimSpace(p.input[i.Pos:i.End])
+	if tok == "" && i.Pos < len(p.input) {
+		r, _ := utf8.DecodeRuneInString(p.input[i.Pos:])
+		tok = string(r)
 	}
-	return p.stateTypes[len(p.stateTypes)-1]
+
+	return NewError(p.input, i.Pos, tok, expected, err)
 }
 
-type lexFn func(*parseStack, lexer.XItem)
+func (p *parseStack) unexpected(i lexer.Token, expected ...string) error {
+	if i.Typ == itemEOF {
+		return p.errorAt(i, expected, fmt.Errorf("Unexpected end of XPath expression"))
+	}
 
-var parseMap = map[lexer.XItemType]lexFn{
-	lexer.XItemAbsLocPath:     xiXPath,
-	lexer.XItemAbbrAbsLocPath: xiXPath,
-	lexer.XItemAbbrRelLocPath: xiXPath,
-	lexer.XItemRelLocPath:     xiXPath,
-	lexer.XItemEndPath:        xiEndPath,
-	lexer.XItemAxis:           xiXPath,
-	lexer.XItemAbbrAxis:       xiXPath,
-	lexer.XItemNCName:         xiXPath,
-	lexer.XItemQName:          xiXPath,
-	lexer.XItemNodeType:       xiXPath,
-	lexer.XItemProcLit:        xiXPath,
-	lexer.XItemFunction:       xiFunc,
-	lexer.XItemArgument:       xiFuncArg,
-	lexer.XItemEndFunction:    xiEndFunc,
-	lexer.XItemPredicate:      xiPred,
-	lexer.XItemEndPredicate:   xiEndPred,
-	lexer.XItemStrLit:         xiValue,
-	lexer.XItemNumLit:         xiValue,
-	lexer.XItemOperator:       xiOp,
-	lexer.XItemVariable:       xiValue,
+	if i.Val == "" {
+		return p.errorAt(i, expected, fmt.Errorf("Unexpected %s", i.Typ))
+	}
+
+	return p.errorAt(i, expected, fmt.Errorf("Unexpected %s: %s", i.Typ, i.Val))
 }
 
-var opPrecedence = map[string]int{
-	"|":   1,
-	"*":   2,
-	"div": 2,
-	"mod": 2,
-	"+":   3,
-	"-":   3,
-	"=":   4,
-	"!=":  4,
-	"<":   4,
-	"<=":  4,
-	">":   4,
-	">=":  4,
-	"and": 5,
-	"or":  6,
-}
-
-//Parse creates an AST tree for XPath expressions.
+func newNode(i lexer.Token) *Node {
+	return &Node{Val: i.XItem, Pos: i.Pos}
+}
+
+//Parse creates an AST tree for XPath expressions.  Errors are returned as an
+//*Error.
 func Parse(xp string) (*Node, error) {
-	var err error
-	c := lexer.Lex(xp)
-	n := &Node{}
-	p := &parseStack{cur: n}
+	p := &parseStack{input: xp, lex: lexer.NewLexer(xp)}
 
-	for next := range c {
-		if next.Typ != lexer.XItemError {
-			parseMap[next.Typ](p, next)
-		} else if err == nil {
-			err = fmt.Errorf(next.Val)
-		}
+	n, err := p.parseExpr(MaxPrecedence)
+	if err == nil && p.peek().Typ != itemEOF {
+		err = p.unexpected(p.peek(), "operator", string(itemEOF))
+	}
+
+	p.drain()
+
+	if p.lexErr != nil {
+		err = p.lexErr
 	}
 
+	if n == nil {
+		n = &Node{}
+	}
+	setParents(n, nil)
+
 	return n, err
 }
 
-func xiXPath(p *parseStack, i lexer.XItem) {
-	if p.curState() == xpathState {
-		p.cur.push(i)
-		p.cur = p.cur.next
+func setParents(n, parent *Node) {
+	if n == nil {
 		return
 	}
 
-	if p.cur.Val.Typ == lexer.XItemFunction {
-		p.cur.Right = &Node{Val: i, Parent: p.cur}
-		p.cur.next = p.cur.Right
-		p.push(xpathState)
-		p.cur = p.cur.next
-		return
+	n.Parent = parent
+	setParents(n.Left, n)
+	setParents(n.Right, n)
+}
+
+//parseExpr parses binary operators whose precedence is at most prec.
+func (p *parseStack) parseExpr(prec int) (*Node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+
+	if p.depth > lexer.MaxDepth {
+		return nil, p.errorAt(p.peek(), nil, fmt.Errorf("Expression is nested more than %d levels deep", lexer.MaxDepth))
 	}
 
-	p.cur.pushNotEmpty(i)
-	p.push(xpathState)
-	p.cur = p.cur.next
-}
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		i := p.peek()
+		if i.Typ != lexer.XItemOperator {
+			return left, nil
+		}
+
+		opPrec, ok := opPrecedence[i.Val]
+		if !ok || opPrec > prec {
+			return left, nil
+		}
+
+		p.next()
+		right, err := p.parseExpr(opPrec - 1)
+		if err != nil {
+			return nil, err
+		}
 
-func xiEndPath(p *parseStack, i lexer.XItem) {
-	p.pop()
+		left = &Node{Val: i.XItem, Pos: i.Pos, Left: left, Right: right}
+	}
 }
 
-func xiFunc(p *parseStack, i lexer.XItem) {
-	if p.cur.Val.Typ == Empty {
-		p.cur.pushNotEmpty(i)
-		p.push(funcState)
-		p.cur = p.cur.next
-		return
+//parseUnary parses negation.  Negated numeric literals are folded into the
+//literal.  Otherwise, the operator node has no left operand.
+func (p *parseStack) parseUnary() (*Node, error) {
+	i := p.peek()
+	if i.Typ != lexer.XItemOperator || i.Val != "-" {
+		return p.parsePrimary()
+	}
+
+	p.next()
+	operand, err := p.parseExpr(UnionPrecedence)
+	if err != nil {
+		return nil, err
+	}
+
+	if operand.Val.Typ == lexer.XItemNumLit {
+		if operand.Val.Val[0] == '-' {
+			operand.Val.Val = operand.Val.Val[1:]
+		} else {
+			operand.Val.Val = "-" + operand.Val.Val
+		}
+		return operand, nil
 	}
-	p.cur.push(i)
-	p.cur = p.cur.next
-	p.push(funcState)
+
+	return &Node{Val: i.XItem, Pos: i.Pos, Right: operand}, nil
 }
 
-func xiFuncArg(p *parseStack, i lexer.XItem) {
-	if p.curState() != funcState {
-		p.pop()
+func (p *parseStack) parsePrimary() (*Node, error) {
+	i := p.peek()
+
+	if pathTypes[i.Typ] {
+		return p.parsePath()
+	}
+
+	var n *Node
+	var err error
+
+	switch i.Typ {
+	case lexer.XItemFor, lexer.XItemLet:
+		return p.parseBindingExpr(lexer.XItemReturn)
+	case lexer.XItemQuantifier:
+		return p.parseBindingExpr(lexer.XItemSatisfies)
+	case lexer.XItemIf:
+		return p.parseIf()
+	case lexer.XItemFunction:
+		n, err = p.parseFunc()
+	case lexer.XItemStrLit, lexer.XItemNumLit, lexer.XItemVariable:
+		n = newNode(p.next())
+	case lexer.XItemOperator:
+		if i.Val != "(" {
+			return nil, p.unexpected(p.next(), "expression")
+		}
+		n, err = p.parseParen()
+	default:
+		return nil, p.unexpected(p.next(), "expression")
+	}
+
+	if err != nil {
+		return nil, err
 	}
 
-	p.cur.push(i)
-	p.cur = p.cur.next
-	p.push(paramState)
-	p.cur.push(lexer.XItem{Typ: Empty, Val: ""})
-	p.cur = p.cur.next
+	cont, err := p.parseFilter()
+	if err != nil {
+		return nil, err
+	}
+
+	if cont != nil {
+		if n.Val.Typ != lexer.XItemFunction || n.Right != nil {
+			n = &Node{Val: lexer.XItem{Typ: Empty}, Pos: n.Pos, Left: n}
+		}
+		n.Right = cont
+	}
+
+	return n, nil
 }
 
-func xiEndFunc(p *parseStack, i lexer.XItem) {
-	if p.curState() == paramState {
-		p.pop()
+//parseParen parses a parenthesized expression.  The parentheses themselves
+//do not appear in the AST, except for the empty sequence, which is an
+//Empty node without any children.  If predicates or a path follow the
+//parentheses, parsePrimary wraps the expression in an Empty node with the
+//continuation on its right.
+func (p *parseStack) parseParen() (*Node, error) {
+	start := p.next()
+
+	if i := p.peek(); i.Typ == lexer.XItemOperator && i.Val == ")" {
+		p.next()
+		return &Node{Val: lexer.XItem{Typ: Empty}, Pos: start.Pos}, nil
+	}
+
+	n, err := p.parseExpr(MaxPrecedence)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.expect(lexer.XItemOperator, ")"); err != nil {
+		return nil, err
 	}
-	p.pop()
+
+	return n, nil
 }
 
-func xiPred(p *parseStack, i lexer.XItem) {
-	p.cur.push(i)
-	p.cur = p.cur.next
-	p.push(predState)
-	p.cur.push(lexer.XItem{Typ: Empty, Val: ""})
-	p.cur = p.cur.next
+//parseFilter parses the predicates and location path that follow a primary
+//expression.  They are chained the same way as the steps in a location path.
+func (p *parseStack) parseFilter() (*Node, error) {
+	var first, last *Node
+
+	for {
+		i := p.peek()
+		var n *Node
+		var err error
+
+		if i.Typ == lexer.XItemPredicate {
+			n, err = p.parsePred()
+		} else if i.Typ == lexer.XItemRelLocPath || i.Typ == lexer.XItemAbbrRelLocPath {
+			n, err = p.parsePath()
+		} else {
+			return first, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if first == nil {
+			first = n
+		} else {
+			chain(last, n)
+		}
+		last = n
+
+		if n.Val.Typ != lexer.XItemPredicate {
+			return first, nil
+		}
+	}
 }
 
-func xiEndPred(p *parseStack, i lexer.XItem) {
-	p.pop()
+//chain links n to the end of a location path.  Predicates hold their
+//expression on the left, so the path continues on their right.
+func chain(last, n *Node) {
+	if last.Val.Typ == lexer.XItemPredicate {
+		last.Right = n
+	} else {
+		last.Left = n
+	}
 }
 
-func xiValue(p *parseStack, i lexer.XItem) {
-	p.cur.add(i)
+func (p *parseStack) parsePath() (*Node, error) {
+	n := newNode(p.next())
+	last := n
+
+	for {
+		i := p.peek()
+		var next *Node
+
+		if i.Typ == lexer.XItemEndPath {
+			p.next()
+			return n, nil
+		} else if i.Typ == lexer.XItemPredicate {
+			if partialStepTypes[last.Val.Typ] {
+				return nil, p.unexpected(p.next(), "node test")
+			}
+
+			var err error
+			if next, err = p.parsePred(); err != nil {
+				return nil, err
+			}
+		} else if stepTypes[i.Typ] || pathTypes[i.Typ] {
+			next = newNode(p.next())
+		} else {
+			return nil, p.unexpected(p.next(), "step")
+		}
+
+		chain(last, next)
+		last = next
+	}
 }
 
-func xiOp(p *parseStack, i lexer.XItem) {
-	if i.Val == "(" {
-		p.cur.push(lexer.XItem{Typ: Empty, Val: ""})
-		p.push(parenState)
-		p.cur = p.cur.next
-		return
+func (p *parseStack) parsePred() (*Node, error) {
+	n := newNode(p.next())
+
+	expr, err := p.parseExpr(MaxPrecedence)
+	if err != nil {
+		return nil, err
 	}
+	n.Left = expr
 
-	if i.Val == ")" {
-		p.pop()
-		return
+	if i := p.next(); i.Typ != lexer.XItemEndPredicate {
+		return nil, p.unexpected(i, "]")
+	}
+
+	return n, nil
+}
+
+func (p *parseStack) parseFunc() (*Node, error) {
+	n := newNode(p.next())
+	var last *Node
+
+	for {
+		i := p.next()
+
+		switch i.Typ {
+		case lexer.XItemEndFunction:
+			return n, nil
+		case lexer.XItemArgument:
+			expr, err := p.parseExpr(MaxPrecedence)
+			if err != nil {
+				return nil, err
+			}
+
+			arg := &Node{Val: i.XItem, Pos: i.Pos, Left: expr}
+			if last == nil {
+				n.Left = arg
+			} else {
+				last.Right = arg
+			}
+			last = arg
+		default:
+			return nil, p.unexpected(i, ",", ")")
+		}
 	}
+}
+
+//parseBindingExpr parses for, let, some and every expressions.  The
+//variable bindings are chained on the left, each one holding its expression
+//on the left and the next binding on the right.  The return or satisfies
+//expression is on the right.
+func (p *parseStack) parseBindingExpr(ret lexer.XItemType) (*Node, error) {
+	n := newNode(p.next())
+	var last *Node
 
-	if p.cur.Val.Typ == lexer.XItemOperator {
-		if opPrecedence[p.cur.Val.Val] <= opPrecedence[i.Val] {
-			p.cur.add(i)
+	for p.peek().Typ == lexer.XItemBinding {
+		b := newNode(p.next())
+
+		expr, err := p.parseExpr(SinglePrecedence)
+		if err != nil {
+			return nil, err
+		}
+		b.Left = expr
+
+		if last == nil {
+			n.Left = b
 		} else {
-			p.cur.push(i)
+			last.Right = b
 		}
-	} else {
-		p.cur.add(i)
+		last = b
+	}
+
+	if i := p.next(); last == nil || i.Typ != ret {
+		return nil, p.unexpected(i, string(ret))
+	}
+
+	expr, err := p.parseExpr(SinglePrecedence)
+	if err != nil {
+		return nil, err
+	}
+	n.Right = expr
+
+	return n, nil
+}
+
+//parseIf parses if expressions.  The condition is on the left, and the right
+//holds a Then node with the then branch on its left and the else branch on
+//its right.
+func (p *parseStack) parseIf() (*Node, error) {
+	n := newNode(p.next())
+
+	if err := p.expect(lexer.XItemOperator, "("); err != nil {
+		return nil, err
+	}
+
+	cond, err := p.parseExpr(MaxPrecedence)
+	if err != nil {
+		return nil, err
+	}
+	n.Left = cond
+
+	if err = p.expect(lexer.XItemOperator, ")"); err != nil {
+		return nil, err
+	}
+
+	then := p.next()
+	if then.Typ != lexer.XItemThen {
+		return nil, p.unexpected(then, string(lexer.XItemThen))
+	}
+
+	thenExpr, err := p.parseExpr(SinglePrecedence)
+	if err != nil {
+		return nil, err
+	}
+
+	if i := p.next(); i.Typ != lexer.XItemElse {
+		return nil, p.unexpected(i, string(lexer.XItemElse))
 	}
-	p.cur = p.cur.next
+
+	elseExpr, err := p.parseExpr(SinglePrecedence)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Right = &Node{Val: then.XItem, Pos: then.Pos, Left: thenExpr, Right: elseExpr}
+
+	return n, nil
 }
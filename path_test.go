@@ -539,3 +539,49 @@ func TestUnion(t *testing.T) {
 	exp := []string{"<test2>foobar</test2>", "<test3>hamneggs</test3>"}
 	execPath(p, x, exp, nil, t)
 }
+
+func TestKeywordNames(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><order>1</order><android>2</android><div>3</div></p1>`
+	execPath(`/p1[order]`, x, []string{"<p1><order>1</order><android>2</android><div>3</div></p1>"}, nil, t)
+	execPath(`/p1/div`, x, []string{"<div>3</div>"}, nil, t)
+	execPath(`/p1/*[android or order]`, x, []string{}, nil, t)
+	execVal(`count(/p1[order and android]/*)`, x, "3", nil, t)
+	execVal(`count(/p1/*)`, x, "3", nil, t)
+	execVal(`count(/p1/node()[not(self::div)])`, x, "2", nil, t)
+}
+
+func TestUnionOrder(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2/><p3/><p2/><p3/></p1>`
+	exp := []string{"<p2></p2>", "<p3></p3>", "<p2></p2>", "<p3></p3>"}
+	execPath(`/p1/p3 | /p1/p2`, x, exp, nil, t)
+	execPath(`/p1/p3 union /p1/p2 | /p1/p3`, x, exp, nil, t)
+	execSeq(`(/p1/p3 | /p1/p2)`, x, exp, t)
+}
+
+func TestIntersectExcept(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><a x="1"/><a/><b x="2"/><a x="3"/></p1>`
+	execPath(`//a except //a[@x]`, x, []string{"<a></a>"}, nil, t)
+	execPath(`//*[@x] intersect //a`, x, []string{`<a x="1"></a>`, `<a x="3"></a>`}, nil, t)
+	execPath(`(//a[3], //a[1], //a[3]) intersect //a`, x, []string{`<a x="1"></a>`, `<a x="3"></a>`}, nil, t)
+	execPath(`/p1/* except //a`, x, []string{`<b x="2"></b>`}, nil, t)
+	execPath(`//a except //a`, x, []string{}, nil, t)
+	execPath(`//a intersect //b`, x, []string{}, nil, t)
+	execVal(`count(/p1/* except //b | //a)`, x, "3", nil, t)
+	execVal(`count(/p1/* except (//b | //a))`, x, "0", nil, t)
+	execPath(`/p1/except`, `<p1><except/></p1>`, []string{"<except></except>"}, nil, t)
+}
+
+func TestRange(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>2</p2><p2>4</p2></p1>`
+	execSeq(`1 to 5`, x, []string{"1", "2", "3", "4", "5"}, t)
+	execSeq(`3 to 3`, x, []string{"3"}, t)
+	execSeq(`5 to 1`, x, []string{}, t)
+	execSeq(`() to 3`, x, []string{}, t)
+	execSeq(`-1 to 1`, x, []string{"-1", "0", "1"}, t)
+	execSeq(`1 + 1 to 2 * 2`, x, []string{"2", "3", "4"}, t)
+	execSeq(`/p1/p2[1] to /p1/p2[2]`, x, []string{"2", "3", "4"}, t)
+	execSeq(`(1 to 10)[. mod 3 = 0]`, x, []string{"3", "6", "9"}, t)
+	execVal(`sum(1 to 100)`, x, "5050", nil, t)
+	execSeq(`for $i in 1 to 3 return $i * $i`, x, []string{"1", "4", "9"}, t)
+	execVal(`count(1 to 5) = 5`, x, "true", nil, t)
+}
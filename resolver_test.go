@@ -0,0 +1,113 @@
+package goxpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+)
+
+func TestVariableResolver(t *testing.T) {
+	calls := []xml.Name{}
+	opts := func(o *Opts) {
+		o.NS["cfg"] = "http://cfg"
+		o.Vars[xml.Name{Local: "x"}] = tree.Num(1)
+		o.VarResolver = VariableResolverFunc(func(name xml.Name) (tree.Result, error) {
+			calls = append(calls, name)
+			switch name.Local {
+			case "timeout", "x":
+				return tree.Num(30), nil
+			case "fail":
+				return nil, fmt.Errorf("Could not connect")
+			}
+			return nil, nil
+		})
+	}
+
+	x := xmltree.MustParseXML(bytes.NewBufferString(`<?xml version="1.0" encoding="UTF-8"?><r><a/><a/><a/></r>`))
+
+	res, err := MustParse(`$cfg:timeout + $x + count(/r/a[$cfg:timeout > 10])`).Exec(x, opts)
+	if err != nil || res.String() != "34" {
+		t.Error("Incorrect result:", res, err)
+	}
+
+	if !reflect.DeepEqual(calls, []xml.Name{{Space: "http://cfg", Local: "timeout"}}) {
+		t.Error("Incorrect resolver calls:", calls)
+	}
+
+	calls = nil
+	if res := MustParse(`if (1) then 2 else $cfg:timeout`).MustExec(x, opts); res.String() != "2" || len(calls) != 0 {
+		t.Error("The variable was resolved when it was not referenced:", res, calls)
+	}
+
+	errs := map[string]string{
+		`$y`:           "Invalid variable 'y'",
+		`$fail`:        "Could not connect",
+		`$foo:timeout`: "Unknown namespace prefix: foo",
+	}
+
+	for xp, exp := range errs {
+		if _, err := MustParse(xp).Exec(x, opts); err == nil || err.Error() != exp {
+			t.Errorf("Incorrect error for '%s': %v", xp, err)
+		}
+	}
+
+	if _, err := Compile(`$cfg:timeout + $y`, opts); err != nil {
+		t.Error("Unknown variables should not be reported with a resolver:", err)
+	}
+}
+
+func TestFunctionResolver(t *testing.T) {
+	type call struct {
+		name  xml.Name
+		arity int
+	}
+	calls := []call{}
+
+	opts := func(o *Opts) {
+		o.NS["p"] = "http://p"
+		o.FuncResolver = FunctionResolverFunc(func(name xml.Name, arity int) (tree.Wrap, bool) {
+			calls = append(calls, call{name, arity})
+			if name.Space != "http://p" || name.Local != "add" {
+				return tree.Wrap{}, false
+			}
+
+			return tree.Wrap{Fn: func(c tree.Ctx, args ...tree.Result) (tree.Result, error) {
+				sum := 0.0
+				for _, i := range args {
+					sum += float64(i.(tree.Num))
+				}
+				return tree.Num(sum), nil
+			}, NArgs: 1, LastArgOpt: tree.Variadic}, true
+		})
+	}
+
+	x := xmltree.MustParseXML(bytes.NewBufferString(`<?xml version="1.0" encoding="UTF-8"?><r><a/><a/></r>`))
+
+	res, err := MustParse(`count(/r/a[p:add(1, 2) = 3]) + p:add(1)`).Exec(x, opts)
+	if err != nil || res.String() != "3" {
+		t.Error("Incorrect result:", res, err)
+	}
+
+	exp := []call{{xml.Name{Space: "http://p", Local: "add"}, 2}, {xml.Name{Space: "http://p", Local: "add"}, 1}}
+	if !reflect.DeepEqual(calls, exp) {
+		t.Error("Incorrect resolver calls:", calls)
+	}
+
+	if _, err := MustParse(`p:sub(1)`).Exec(x, opts); err == nil || err.Error() != "Unknown function: p:sub" {
+		t.Error("Incorrect error:", err)
+	}
+
+	_, err = Compile(`p:add(1, 2, 3) + p:sub()`, opts)
+	if err == nil || err.Error() != "1:18: Unknown function: p:sub" {
+		t.Error("Incorrect error:", err)
+	}
+
+	if res := MustParse(`p:add(1)`).Explain(opts); res != "function p:add (resolved to {http://p}add, custom, 1 arg)\n  number 1" {
+		t.Error("Incorrect plan:", res)
+	}
+}
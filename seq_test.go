@@ -0,0 +1,115 @@
+package goxpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+)
+
+func execSeq(xp, x string, exp []string, t *testing.T) {
+	res, err := MustParse(xp).ExecSeq(xmltree.MustParseXML(bytes.NewBufferString(x)))
+	if err != nil {
+		t.Error("Error in XPath expression '"+xp+"':", err)
+		return
+	}
+
+	if len(res) != len(exp) {
+		t.Error("Result length not valid in XPath expression '"+xp+"':", len(res), ", expecting", len(exp))
+		return
+	}
+
+	for i := range res {
+		str := res[i].String()
+		if tree.IsNode(res[i]) {
+			str, _ = MarshalStr(res[i].(tree.NodeSet)[0])
+		}
+
+		if str != exp[i] {
+			t.Error("Incorrect result in XPath expression '" + xp + "':" + str + ".  Expecting: " + exp[i])
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>a</p2><p3>b</p3></p1>`
+	execSeq(`(1, 'two', 3)`, x, []string{"1", "two", "3"}, t)
+	execSeq(`1, 2`, x, []string{"1", "2"}, t)
+	execSeq(`()`, x, []string{}, t)
+	execSeq(`((1, 2), (), 3)`, x, []string{"1", "2", "3"}, t)
+	execSeq(`(/p1/p3, /p1/p2)`, x, []string{"<p3>b</p3>", "<p2>a</p2>"}, t)
+	execSeq(`(/p1/p3, 'c')`, x, []string{"<p3>b</p3>", "c"}, t)
+	execSeq(`concat('a', 'b'), 'c'`, x, []string{"ab", "c"}, t)
+}
+
+func TestSequenceFilter(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>a</p2><p3>b</p3></p1>`
+	execSeq(`(4, 5, 6)[2]`, x, []string{"5"}, t)
+	execSeq(`(4, 5, 6)[. > 4]`, x, []string{"5", "6"}, t)
+	execSeq(`(4, 5, 6)[position() = last()]`, x, []string{"6"}, t)
+	execSeq(`(4, 5, 6)[. > 4][1]`, x, []string{"5"}, t)
+	execSeq(`(/p1/p3, 'c', /p1/p2)[1]`, x, []string{"<p3>b</p3>"}, t)
+	execSeq(`(/p1/*)[2]`, x, []string{"<p3>b</p3>"}, t)
+	execSeq(`(/p1/*)[last()]/text()`, x, []string{"b"}, t)
+	execSeq(`(/p1/p3 | /p1/p2)[1]`, x, []string{"<p2>a</p2>"}, t)
+}
+
+func TestSequenceOps(t *testing.T) {
+	x := `<?xml version="1.0" encoding="UTF-8"?><p1><p2>1</p2><p3>2</p3></p1>`
+	execVal(`(1, 2, 3) = 2`, x, "true", nil, t)
+	execVal(`(1, 2, 3) = 4`, x, "false", nil, t)
+	execVal(`(1, 2, 3) != 1`, x, "true", nil, t)
+	execVal(`(4, 5) > /p1/*`, x, "true", nil, t)
+	execVal(`(/p1/p2, 5) = 1`, x, "true", nil, t)
+	execVal(`() = 1`, x, "false", nil, t)
+	execVal(`count((1, 2, 3))`, x, "3", nil, t)
+	execVal(`count(())`, x, "0", nil, t)
+	execVal(`count((/p1/*, /p1/p2))`, x, "3", nil, t)
+	execVal(`count((/p1/p2, /p1/p3) | /p1/p2)`, x, "2", nil, t)
+	execVal(`boolean(())`, x, "false", nil, t)
+	execVal(`boolean((0))`, x, "false", nil, t)
+	execVal(`boolean((0, 0))`, x, "true", nil, t)
+}
+
+func TestSequenceVar(t *testing.T) {
+	x := xmltree.MustParseXML(bytes.NewBufferString(xml.Header + "<p1><p2>foo</p2><p3>bar</p3></p1>"))
+	opt := func(o *Opts) {
+		o.Vars[xml.Name{Local: "seq"}] = tree.Sequence{tree.Num(1), tree.String("foo"), tree.Bool(true)}
+		o.Vars[xml.Name{Local: "nodes"}] = MustParse(`/p1/*`).MustExec(x)
+	}
+	res, err := MustParse(`$seq[2]`).ExecSeq(x, opt)
+	if err != nil || len(res) != 1 || res[0].String() != "foo" {
+		t.Error("Incorrect result", res, err)
+	}
+	if b, err := MustParse(`$nodes = $seq`).ExecBool(x, opt); err != nil || !b {
+		t.Error("Incorrect result", b, err)
+	}
+	if n, err := MustParse(`$nodes[2]/text()`).ExecNode(x, opt); err != nil || len(n) != 1 || n[0].ResValue() != "bar" {
+		t.Error("Incorrect result", n, err)
+	}
+	if n, err := MustParse(`($nodes, $nodes)`).ExecNode(x, opt); err != nil || len(n) != 4 {
+		t.Error("Incorrect result", n, err)
+	}
+	if _, err := MustParse(`$seq`).ExecNode(x, opt); err == nil {
+		t.Error("Error is nil")
+	}
+	if _, err := MustParse(`$seq/foo`).Exec(x, opt); err == nil || err.Error() != "Cannot convert data type to node-set" {
+		t.Error("Incorrect error", err)
+	}
+}
+
+func TestSequenceConv(t *testing.T) {
+	s := tree.Sequence{tree.NodeSet{}, tree.Sequence{tree.String("5"), tree.Num(6)}}
+	if s.String() != "5" || s.Num() != 5 || !s.Bool() {
+		t.Error("Incorrect conversion", s.String(), s.Num(), s.Bool())
+	}
+	s = tree.Sequence{}
+	if s.String() != "" || s.Num() == s.Num() || s.Bool() {
+		t.Error("Incorrect conversion", s.String(), s.Num(), s.Bool())
+	}
+	if _, ok := (tree.Sequence{tree.String("a")}).NodeSet(); ok {
+		t.Error("Sequence converted to a node-set")
+	}
+}
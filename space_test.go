@@ -0,0 +1,75 @@
+package goxpath
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+)
+
+func execSpace(xp, x, exp string, t *testing.T, op ...xmltree.ParseSettings) {
+	n := xmltree.MustParseXML(bytes.NewBufferString(x), op...)
+	res, err := MarshalStr(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != exp {
+		t.Errorf("Incorrect document: %s.  Expecting: %s", res, exp)
+	}
+
+	if xp != "" {
+		if res := MustParse(xp).MustExec(n, func(o *Opts) { o.NS["p"] = "http://p" }).String(); res != "true" {
+			t.Errorf("Incorrect result for %s: %s", xp, res)
+		}
+	}
+}
+
+func TestStripSpace(t *testing.T) {
+	x := "<r>\n  <a> </a>\n  <b xml:space=\"preserve\">\n    <c> </c>\n    <d xml:space=\"default\"> </d>\n  </b>\n  <e> x </e>\n</r>\n"
+
+	execSpace(`count(/r/node()) = 7 and name(/r/node()[1]) = ''`, x, "<r>\n  <a> </a>\n  <b xml:space=\"preserve\">\n    <c> </c>\n    <d xml:space=\"default\"> </d>\n  </b>\n  <e> x </e>\n</r>\n", t)
+
+	execSpace(`count(/r/node()) = 3 and name(/r/node()[1]) = 'a' and /r/e = ' x '`, x, "<r><a></a><b xml:space=\"preserve\">\n    <c> </c>\n    <d xml:space=\"default\"></d>\n  </b><e> x </e></r>", t, func(o *xmltree.ParseOptions) {
+		o.StripSpace = true
+	})
+
+	execSpace(`count(/r/node()) = 3 and count(/r/a/node()) = 1`, x, "<r><a> </a><b xml:space=\"preserve\">\n    <c> </c>\n    <d xml:space=\"default\"> </d>\n  </b><e> x </e></r>\n", t, func(o *xmltree.ParseOptions) {
+		o.StripSpaceElems = []xml.Name{{Local: "r"}}
+	})
+
+	execSpace(``, x, "<r><a> </a><b xml:space=\"preserve\">\n    <c> </c>\n    <d xml:space=\"default\"></d>\n  </b><e> x </e></r>", t, func(o *xmltree.ParseOptions) {
+		o.StripSpace = true
+		o.PreserveSpaceElems = []xml.Name{{Space: "*", Local: "a"}}
+	})
+}
+
+func TestStripSpaceNS(t *testing.T) {
+	x := `<r xmlns:p="http://p"><p:a> </p:a><a> </a><p:b> <![CDATA[x]]> </p:b></r>`
+
+	execSpace(`count(//p:a/node()) = 0 and count(/r/a/node()) = 1 and /r/p:b = ' x '`, x, `<r><a xmlns="http://p"></a><a> </a><b xmlns="http://p"> x </b></r>`, t, func(o *xmltree.ParseOptions) {
+		o.StripSpaceElems = []xml.Name{{Space: "http://p", Local: "*"}}
+	})
+}
+
+func TestNormalizeNewlines(t *testing.T) {
+	x := "<r a=\"1\r\n2\">\r\n<!--a\r\nb\rc--><?p a\r\nb?></r>"
+	n := xmltree.MustParseXML(bytes.NewBufferString(x), func(o *xmltree.ParseOptions) {
+		o.NormalizeNewlines = true
+	})
+
+	vals := map[string]string{
+		`/r/@a`:                       "1\n2",
+		`/r/text()`:                   "\n",
+		`/r/comment()`:                "a\nb\nc",
+		`/r/processing-instruction()`: "a\nb",
+	}
+
+	for xp, exp := range vals {
+		res := MustParse(xp).MustExec(n).(tree.NodeSet)
+		if len(res) != 1 || res[0].ResValue() != exp {
+			t.Errorf("Incorrect result for %s: %q.  Expecting: %q", xp, res, exp)
+		}
+	}
+}
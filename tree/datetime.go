@@ -0,0 +1,319 @@
+package tree
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DateTime is an xs:dateTime.  If the value does not have a timezone, TZ is
+//false and the time is in UTC, which is the implicit timezone.
+type DateTime struct {
+	Time time.Time
+	TZ   bool
+}
+
+//Date is an xs:date.  The time is midnight in the date's timezone.
+type Date struct {
+	Time time.Time
+	TZ   bool
+}
+
+//Time is an xs:time.  The date is always 1972-12-31, which is the reference
+//date used for comparing times.
+type Time struct {
+	Time time.Time
+	TZ   bool
+}
+
+//Duration is an xs:duration.  The year and month components are held in
+//Months, and the day and time components in Dur.  Both components have the
+//same sign.
+type Duration struct {
+	Months int
+	Dur    time.Duration
+}
+
+const (
+	datePat = `(-?\d{4,})-(\d{2})-(\d{2})`
+	timePat = `(\d{2}):(\d{2}):(\d{2}(?:\.\d+)?)`
+	tzPat   = `(Z|[+-]\d{2}:\d{2})?`
+)
+
+var (
+	dateTimeRegexp = regexp.MustCompile(`^` + datePat + `T` + timePat + tzPat + `$`)
+	dateRegexp     = regexp.MustCompile(`^` + datePat + tzPat + `$`)
+	timeRegexp     = regexp.MustCompile(`^` + timePat + tzPat + `$`)
+	durationRegexp = regexp.MustCompile(`^(-)?P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+)
+
+//ParseDateTime parses the lexical form of an xs:dateTime.
+func ParseDateTime(s string) (DateTime, error) {
+	m := dateTimeRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return DateTime{}, fmt.Errorf("Invalid xs:dateTime value: %s", s)
+	}
+
+	t, tz, ok := parseCalendar(m[1], m[2], m[3], m[4], m[5], m[6], m[7])
+	if !ok {
+		return DateTime{}, fmt.Errorf("Invalid xs:dateTime value: %s", s)
+	}
+
+	return DateTime{Time: t, TZ: tz}, nil
+}
+
+//ParseDate parses the lexical form of an xs:date.
+func ParseDate(s string) (Date, error) {
+	m := dateRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Date{}, fmt.Errorf("Invalid xs:date value: %s", s)
+	}
+
+	t, tz, ok := parseCalendar(m[1], m[2], m[3], "00", "00", "00", m[4])
+	if !ok {
+		return Date{}, fmt.Errorf("Invalid xs:date value: %s", s)
+	}
+
+	return Date{Time: t, TZ: tz}, nil
+}
+
+//ParseTime parses the lexical form of an xs:time.
+func ParseTime(s string) (Time, error) {
+	m := timeRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return Time{}, fmt.Errorf("Invalid xs:time value: %s", s)
+	}
+
+	t, tz, ok := parseCalendar("1972", "12", "31", m[1], m[2], m[3], m[4])
+	if !ok {
+		return Time{}, fmt.Errorf("Invalid xs:time value: %s", s)
+	}
+
+	return NewTime(t, tz), nil
+}
+
+//ParseDuration parses the lexical form of an xs:duration.
+func ParseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	m := durationRegexp.FindStringSubmatch(s)
+	if m == nil || strings.HasSuffix(s, "P") || strings.HasSuffix(s, "T") {
+		return Duration{}, fmt.Errorf("Invalid xs:duration value: %s", s)
+	}
+
+	num := func(str string) float64 {
+		ret, _ := strconv.ParseFloat("0"+str, 64)
+		return ret
+	}
+
+	//The duration is checked in floating point first, so the conversion to
+	//time.Duration does not silently overflow
+	months := num(m[2])*12 + num(m[3])
+	secs := num(m[4])*24*3600 + num(m[5])*3600 + num(m[6])*60 + num(m[7])
+	if months > math.MaxInt32 || secs*1e9 >= math.MaxInt64 {
+		return Duration{}, fmt.Errorf("xs:duration value is out of range: %s", s)
+	}
+
+	ret := Duration{
+		Months: int(months),
+		Dur: time.Duration(num(m[4]))*24*time.Hour +
+			time.Duration(num(m[5]))*time.Hour +
+			time.Duration(num(m[6]))*time.Minute +
+			time.Duration(math.Floor(num(m[7])*1e9+0.5)),
+	}
+
+	if m[1] == "-" {
+		return ret.Negate(), nil
+	}
+
+	return ret, nil
+}
+
+func parseCalendar(year, month, day, hour, min, sec, tz string) (time.Time, bool, bool) {
+	y, _ := strconv.Atoi(year)
+	mo, _ := strconv.Atoi(month)
+	d, _ := strconv.Atoi(day)
+	h, _ := strconv.Atoi(hour)
+	mi, _ := strconv.Atoi(min)
+	s, _ := strconv.ParseFloat(sec, 64)
+
+	if mo < 1 || mo > 12 || d < 1 || d > daysIn(y, time.Month(mo)) || mi > 59 || s >= 60 {
+		return time.Time{}, false, false
+	}
+
+	endOfDay := false
+	if h == 24 {
+		if mi != 0 || s != 0 {
+			return time.Time{}, false, false
+		}
+		h = 0
+		endOfDay = true
+	} else if h > 23 {
+		return time.Time{}, false, false
+	}
+
+	loc := time.UTC
+	if tz != "" && tz != "Z" {
+		tzh, _ := strconv.Atoi(tz[1:3])
+		tzm, _ := strconv.Atoi(tz[4:6])
+		if tzh > 14 || tzm > 59 || (tzh == 14 && tzm != 0) {
+			return time.Time{}, false, false
+		}
+
+		offset := tzh*3600 + tzm*60
+		if tz[0] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone("", offset)
+	}
+
+	ns := int(math.Floor((s-math.Floor(s))*1e9 + 0.5))
+	ret := time.Date(y, time.Month(mo), d, h, mi, int(s), ns, loc)
+	if endOfDay {
+		ret = ret.AddDate(0, 0, 1)
+	}
+
+	return ret, tz != "", true
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+//NewDate returns the date of t, in t's timezone.
+func NewDate(t time.Time, tz bool) Date {
+	return Date{Time: time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()), TZ: tz}
+}
+
+//NewTime returns the time of t, in t's timezone.
+func NewTime(t time.Time, tz bool) Time {
+	return Time{Time: time.Date(1972, 12, 31, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location()), TZ: tz}
+}
+
+func formatDate(t time.Time) string {
+	y := t.Year()
+	sign := ""
+	if y < 0 {
+		sign = "-"
+		y = -y
+	}
+
+	return fmt.Sprintf("%s%04d-%02d-%02d", sign, y, t.Month(), t.Day())
+}
+
+func formatTime(t time.Time) string {
+	ret := fmt.Sprintf("%02d:%02d:%02d", t.Hour(), t.Minute(), t.Second())
+
+	if t.Nanosecond() != 0 {
+		ret += strings.TrimRight(fmt.Sprintf(".%09d", t.Nanosecond()), "0")
+	}
+
+	return ret
+}
+
+func formatTZ(t time.Time, tz bool) string {
+	if !tz {
+		return ""
+	}
+
+	_, offset := t.Zone()
+	if offset == 0 {
+		return "Z"
+	}
+
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+
+	return fmt.Sprintf("%s%02d:%02d", sign, offset/3600, offset%3600/60)
+}
+
+//String satisfies the Res interface for DateTime
+func (d DateTime) String() string {
+	return formatDate(d.Time) + "T" + formatTime(d.Time) + formatTZ(d.Time, d.TZ)
+}
+
+//String satisfies the Res interface for Date
+func (d Date) String() string {
+	return formatDate(d.Time) + formatTZ(d.Time, d.TZ)
+}
+
+//String satisfies the Res interface for Time
+func (t Time) String() string {
+	return formatTime(t.Time) + formatTZ(t.Time, t.TZ)
+}
+
+//AddDuration adds the duration to t.  The months are added first, using the
+//last day of the month if the day does not exist in the new month.
+func AddDuration(t time.Time, d Duration) time.Time {
+	if d.Months != 0 {
+		months := int(t.Month()) - 1 + d.Months
+		y := t.Year() + months/12
+		m := months % 12
+		if m < 0 {
+			m += 12
+			y--
+		}
+
+		day := t.Day()
+		if last := daysIn(y, time.Month(m+1)); day > last {
+			day = last
+		}
+
+		t = time.Date(y, time.Month(m+1), day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	}
+
+	return t.Add(d.Dur)
+}
+
+//Negate returns the duration with the opposite sign.
+func (d Duration) Negate() Duration {
+	return Duration{Months: -d.Months, Dur: -d.Dur}
+}
+
+//String satisfies the Res interface for Duration
+func (d Duration) String() string {
+	if d.Months == 0 && d.Dur == 0 {
+		return "PT0S"
+	}
+
+	ret := "P"
+	if d.Months < 0 || d.Dur < 0 {
+		ret = "-P"
+		d = d.Negate()
+	}
+
+	if d.Months/12 != 0 {
+		ret += fmt.Sprintf("%dY", d.Months/12)
+	}
+	if d.Months%12 != 0 {
+		ret += fmt.Sprintf("%dM", d.Months%12)
+	}
+
+	days := d.Dur / (24 * time.Hour)
+	if days != 0 {
+		ret += fmt.Sprintf("%dD", days)
+	}
+
+	rem := d.Dur % (24 * time.Hour)
+	if rem == 0 {
+		return ret
+	}
+
+	ret += "T"
+	if rem/time.Hour != 0 {
+		ret += fmt.Sprintf("%dH", rem/time.Hour)
+	}
+	if rem%time.Hour/time.Minute != 0 {
+		ret += fmt.Sprintf("%dM", rem%time.Hour/time.Minute)
+	}
+	if sec := rem % time.Minute; sec != 0 {
+		ret += strconv.FormatFloat(sec.Seconds(), 'f', -1, 64) + "S"
+	}
+
+	return ret
+}
@@ -16,6 +16,20 @@ func (n NodePos) Pos() int {
 	return int(n)
 }
 
+//Location is the position of a node in the source document.  Line and Col are
+//1-based, and Offset is the byte offset of the node.  It is the zero Location
+//if the node was not parsed.
+type Location struct {
+	Line   int
+	Col    int
+	Offset int
+}
+
+//GetLocation returns the node's location
+func (l Location) GetLocation() Location {
+	return l
+}
+
 //NodeType is a safer way to determine a node's type than type assertions.
 type NodeType int
 
@@ -64,6 +78,39 @@ type NSElem interface {
 	GetNS() map[xml.Name]string
 }
 
+//IDElem is an optional interface for root nodes that know which attributes
+//are declared with the ID type in the document's DTD.
+type IDElem interface {
+	Elem
+	//IsIDAttr returns true if the attribute, attr, is an ID attribute on
+	//elements named elem.
+	IsIDAttr(elem, attr xml.Name) bool
+}
+
+//PrologElem is an optional interface for root nodes that keep the XML
+//declaration and document type declarations of the parsed document, which are
+//not nodes.
+type PrologElem interface {
+	Elem
+	//GetProlog returns the declarations in document order.
+	GetProlog() []PrologToken
+}
+
+//PrologToken is an XML declaration (an xml.ProcInst) or a document type
+//declaration (an xml.Directive).  Index is the index of the root node's child
+//that it comes before.
+type PrologToken struct {
+	Token xml.Token
+	Index int
+}
+
+//LocatedNode is an optional interface for nodes that know their location in
+//the source document.
+type LocatedNode interface {
+	Node
+	GetLocation() Location
+}
+
 //NSBuilder is a helper-struct for satisfying the NSElem interface
 type NSBuilder struct {
 	NS map[xml.Name]string
@@ -2,6 +2,7 @@ package tree
 
 import (
 	"fmt"
+	"time"
 )
 
 //Ctx represents the current context position, size, node, and the current filtered result
@@ -9,6 +10,8 @@ type Ctx struct {
 	NodeSet
 	Pos  int
 	Size int
+	//Now is the current date and time.  It is the same throughout the execution.
+	Now time.Time
 }
 
 //Fn is a XPath function, written in Go
@@ -34,19 +37,19 @@ type Wrap struct {
 
 //Call checks the arguments and calls Fn if they are valid
 func (w Wrap) Call(c Ctx, args ...Result) (Result, error) {
+	if w.ValidArgs(len(args)) {
+		return w.Fn(c, args...)
+	}
+	return nil, fmt.Errorf("Invalid number of arguments")
+}
+
+//ValidArgs returns true if the function can be called with n arguments
+func (w Wrap) ValidArgs(n int) bool {
 	switch w.LastArgOpt {
 	case Optional:
-		if len(args) == w.NArgs || len(args) == w.NArgs-1 {
-			return w.Fn(c, args...)
-		}
+		return n == w.NArgs || n == w.NArgs-1
 	case Variadic:
-		if len(args) >= w.NArgs-1 {
-			return w.Fn(c, args...)
-		}
-	default:
-		if len(args) == w.NArgs {
-			return w.Fn(c, args...)
-		}
+		return n >= w.NArgs-1
 	}
-	return nil, fmt.Errorf("Invalid number of arguments")
+	return n == w.NArgs
 }
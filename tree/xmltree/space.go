@@ -0,0 +1,111 @@
+package xmltree
+
+import (
+	"bytes"
+	"encoding/xml"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlbuilder"
+)
+
+//spaceScope is the whitespace handling of an open element.  preserve is set by
+//xml:space="preserve" and inherited by the children, and strip is set by the
+//element's name.
+type spaceScope struct {
+	preserve bool
+	strip    bool
+}
+
+type pendingText struct {
+	data xml.CharData
+	loc  tree.Location
+}
+
+//space strips the whitespace-only character data.  The decoder splits text at
+//CDATA sections, so adjacent character data is held until the next token to
+//decide if the whole text is whitespace.
+type space struct {
+	opts  *ParseOptions
+	stack []spaceScope
+	text  []pendingText
+}
+
+func (s *space) push(ele xml.StartElement) {
+	scope := spaceScope{}
+	if len(s.stack) > 0 {
+		scope.preserve = s.stack[len(s.stack)-1].preserve
+	}
+
+	for _, i := range ele.Attr {
+		if i.Name.Space == tree.XMLSpace && i.Name.Local == "space" {
+			scope.preserve = i.Value == "preserve"
+		}
+	}
+
+	if !scope.preserve && !matchName(s.opts.PreserveSpaceElems, ele.Name) {
+		scope.strip = s.opts.StripSpace || matchName(s.opts.StripSpaceElems, ele.Name)
+	}
+
+	s.stack = append(s.stack, scope)
+}
+
+func (s *space) pop() {
+	if len(s.stack) > 0 {
+		s.stack = s.stack[:len(s.stack)-1]
+	}
+}
+
+func (s *space) add(data xml.CharData, loc tree.Location) {
+	s.text = append(s.text, pendingText{data: data.Copy(), loc: loc})
+}
+
+//flush creates the nodes of the pending character data, unless it only
+//contains whitespace and it is stripped from the current element.  Text
+//outside of the document element is only stripped with StripSpace.
+func (s *space) flush(opts *xmlbuilder.BuilderOpts, xmlTree xmlbuilder.XMLBuilder, ordrPos *int) xmlbuilder.XMLBuilder {
+	if len(s.text) == 0 {
+		return xmlTree
+	}
+
+	strip := s.opts.StripSpace
+	if len(s.stack) > 0 {
+		strip = s.stack[len(s.stack)-1].strip
+	}
+
+	for _, i := range s.text {
+		if len(bytes.TrimLeft(i.data, " \t\r\n")) > 0 {
+			strip = false
+		}
+	}
+
+	if !strip {
+		loc := opts.Loc
+		for _, i := range s.text {
+			opts.Loc = i.loc
+			setNode(opts, xmlTree, i.data, tree.NtChd, ordrPos)
+			xmlTree = xmlTree.CreateNode(opts)
+		}
+		opts.Loc = loc
+	}
+
+	s.text = s.text[:0]
+	return xmlTree
+}
+
+//matchName returns true if name is in names.  A Space or Local of "*" in
+//names matches any namespace or local name.
+func matchName(names []xml.Name, name xml.Name) bool {
+	for _, i := range names {
+		if (i.Space == "*" || i.Space == name.Space) && (i.Local == "*" || i.Local == name.Local) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//normalizeNewlines converts \r\n and \r to \n.
+func normalizeNewlines(b []byte) []byte {
+	b = bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(b, []byte("\r"), []byte("\n"), -1)
+}
@@ -15,6 +15,7 @@ type BuilderOpts struct {
 	Attrs        []*xml.Attr
 	NodePos      int
 	AttrStartPos int
+	Loc          tree.Location
 }
 
 //XMLBuilder is an interface for creating XML structures.
@@ -0,0 +1,198 @@
+package xmlele
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlnode"
+)
+
+//Builder constructs a document in Go code instead of parsing it, e.g.:
+//
+//	xmlele.Elem("p:root").NS("p", "http://example.com").Attr("id", "1").
+//		Child(xmlele.Elem("p:item").Text("foo")).
+//		Build()
+//
+//Names may have a prefix declared with NS on the element or one of its
+//ancestors.  Unprefixed element names are in the default namespace, and
+//unprefixed attribute names are not in a namespace.  Errors in the names are
+//returned by Build.
+type Builder struct {
+	name     string
+	decls    []xml.Attr
+	attrs    []xml.Attr
+	children []builderNode
+}
+
+//builderNode is either a child element, or the token of a child node
+type builderNode struct {
+	ele *Builder
+	tok xml.Token
+	typ tree.NodeType
+}
+
+//Elem starts building an element named name.
+func Elem(name string) *Builder {
+	return &Builder{name: name}
+}
+
+//NS declares the namespace prefix, which is in scope for the element and its
+//children.  An empty prefix declares the default namespace.
+func (b *Builder) NS(prefix, url string) *Builder {
+	name := xml.Name{Space: "xmlns", Local: prefix}
+	if prefix == "" {
+		name = xml.Name{Local: "xmlns"}
+	}
+
+	b.decls = append(b.decls, xml.Attr{Name: name, Value: url})
+	return b
+}
+
+//Attr adds an attribute to the element.
+func (b *Builder) Attr(name, value string) *Builder {
+	b.attrs = append(b.attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+	return b
+}
+
+//Text adds character data to the element's children.
+func (b *Builder) Text(text string) *Builder {
+	b.children = append(b.children, builderNode{tok: xml.CharData(text), typ: tree.NtChd})
+	return b
+}
+
+//Comment adds a comment to the element's children.
+func (b *Builder) Comment(comm string) *Builder {
+	b.children = append(b.children, builderNode{tok: xml.Comment(comm), typ: tree.NtComm})
+	return b
+}
+
+//ProcInst adds a processing instruction to the element's children.
+func (b *Builder) ProcInst(target, inst string) *Builder {
+	b.children = append(b.children, builderNode{tok: xml.ProcInst{Target: target, Inst: []byte(inst)}, typ: tree.NtPi})
+	return b
+}
+
+//Child adds elements to the element's children.
+func (b *Builder) Child(c ...*Builder) *Builder {
+	for _, i := range c {
+		b.children = append(b.children, builderNode{ele: i})
+	}
+	return b
+}
+
+//MustBuild is like Build, but panics instead of returning an error.
+func (b *Builder) MustBuild() tree.Node {
+	ret, err := b.Build()
+	if err != nil {
+		panic(err)
+	}
+	return ret
+}
+
+//Build creates a document with the element as its document element.  The
+//document is numbered in the same order as a parsed document, so it can be
+//queried and marshaled like one.
+func (b *Builder) Build() (tree.Node, error) {
+	root := Root().(*XMLEle)
+	scope := map[string]string{"xml": tree.XMLSpace}
+
+	ele, err := b.build(root, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	ele.NS[xml.Name{Space: "xmlns", Local: "xml"}] = tree.XMLSpace
+	root.Children = []tree.Node{ele}
+	root.renumber()
+
+	return root, nil
+}
+
+func (b *Builder) build(parent *XMLEle, scope map[string]string) (*XMLEle, error) {
+	inner := make(map[string]string)
+	for k, v := range scope {
+		inner[k] = v
+	}
+
+	ret := &XMLEle{
+		NSBuilder: tree.NSBuilder{NS: make(map[xml.Name]string)},
+		Parent:    parent,
+		NodeType:  tree.NtElem,
+	}
+
+	for _, i := range b.decls {
+		ret.NS[i.Name] = i.Value
+		if i.Name.Space == "" {
+			inner[""] = i.Value
+		} else {
+			inner[i.Name.Local] = i.Value
+		}
+	}
+
+	name, err := builderName(b.name, inner, true)
+	if err != nil {
+		return nil, err
+	}
+	ret.Name = name
+
+	for _, i := range b.attrs {
+		attr, err := builderName(i.Name.Local, inner, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := tree.GetAttribute(ret, attr.Local, attr.Space); ok {
+			return nil, fmt.Errorf("Duplicate attribute %s on element %s", i.Name.Local, b.name)
+		}
+
+		ret.Attrs = append(ret.Attrs, xmlnode.XMLNode{
+			Token:    &xml.Attr{Name: attr, Value: i.Value},
+			NodeType: tree.NtAttr,
+			Parent:   ret,
+		})
+	}
+
+	for _, i := range b.children {
+		if i.ele == nil {
+			ret.Children = append(ret.Children, xmlnode.XMLNode{
+				Token:    i.tok,
+				NodeType: i.typ,
+				Parent:   ret,
+			})
+			continue
+		}
+
+		c, err := i.ele.build(ret, inner)
+		if err != nil {
+			return nil, err
+		}
+		ret.Children = append(ret.Children, c)
+	}
+
+	return ret, nil
+}
+
+//builderName resolves the prefix of the name.  Unprefixed element names are
+//in the default namespace.
+func builderName(name string, scope map[string]string, ele bool) (xml.Name, error) {
+	if name == "" {
+		return xml.Name{}, fmt.Errorf("Cannot build a node without a name")
+	}
+
+	spl := strings.SplitN(name, ":", 2)
+	if len(spl) == 1 {
+		if ele {
+			return xml.Name{Space: scope[""], Local: name}, nil
+		}
+		return xml.Name{Local: name}, nil
+	}
+
+	space, ok := scope[spl[0]]
+	if !ok {
+		return xml.Name{}, fmt.Errorf("Unknown namespace prefix: %s", spl[0])
+	}
+
+	return xml.Name{Space: space, Local: spl[1]}, nil
+}
@@ -0,0 +1,82 @@
+package xmlele
+
+import (
+	"encoding/xml"
+	"regexp"
+	"strings"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+)
+
+var (
+	dtdComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+	attlist    = regexp.MustCompile(`<!ATTLIST\s+([^\s>]+)((?:[^>"']|"[^"]*"|'[^']*')*)>`)
+	attDefTok  = regexp.MustCompile(`\([^)]*\)|"[^"]*"|'[^']*'|[^\s()"']+`)
+)
+
+//Directive is an implementation of xmltree.DirectiveParser.  It keeps the
+//directives of the root node in the prolog, and records the attributes
+//declared with the ID type in the DOCTYPE's internal subset.
+func (x *XMLEle) Directive(dir xml.Directive, dec *xml.Decoder) {
+	if x.NodeType == tree.NtRoot {
+		x.Prolog = append(x.Prolog, tree.PrologToken{Token: dir, Index: len(x.Children)})
+	}
+
+	str := string(dir)
+	if !strings.HasPrefix(str, "DOCTYPE") {
+		return
+	}
+
+	str = dtdComment.ReplaceAllString(str, "")
+
+	for _, decl := range attlist.FindAllStringSubmatch(str, -1) {
+		toks := attDefTok.FindAllString(decl[2], -1)
+
+		//Each attribute definition is a name, type and default declaration
+		for i := 0; i+2 < len(toks); i += 3 {
+			if toks[i+1] == "NOTATION" {
+				i++
+				if i+2 >= len(toks) {
+					break
+				}
+			}
+
+			if toks[i+1] == "ID" {
+				if x.IDAttrs == nil {
+					x.IDAttrs = make(map[string]map[string]bool)
+				}
+
+				elem := localName(decl[1])
+				if x.IDAttrs[elem] == nil {
+					x.IDAttrs[elem] = make(map[string]bool)
+				}
+				x.IDAttrs[elem][localName(toks[i])] = true
+			}
+
+			if toks[i+2] == "#FIXED" {
+				i++
+			}
+		}
+	}
+}
+
+func localName(name string) string {
+	return name[strings.Index(name, ":")+1:]
+}
+
+//IsIDAttr is an implementation of tree.IDElem.  Names are matched on their
+//local part, because the DTD does not know about namespaces.
+func (x *XMLEle) IsIDAttr(elem, attr xml.Name) bool {
+	return x.IDAttrs[elem.Local][attr.Local]
+}
+
+//Decl is an implementation of xmltree.DeclParser.  It keeps the XML
+//declaration in the prolog.
+func (x *XMLEle) Decl(decl xml.ProcInst) {
+	x.Prolog = append(x.Prolog, tree.PrologToken{Token: decl.Copy(), Index: len(x.Children)})
+}
+
+//GetProlog is an implementation of tree.PrologElem.
+func (x *XMLEle) GetProlog() []tree.PrologToken {
+	return x.Prolog
+}
@@ -0,0 +1,418 @@
+package xmlele
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree/xmlnode"
+)
+
+//The following methods edit the tree.  After each edit, the document order
+//positions of the document are renumbered, so XPath expressions executed
+//afterwards return their results in the correct order.  Only the positions of
+//the nodes that follow the edit change, so when editing several nodes of the
+//same result, edit them in reverse document order.  Use Batch to renumber the
+//document once after several edits.  Character data, comment and processing
+//instruction nodes are values, so they are found by their position and value.
+
+//NewElem creates an element that is not attached to a document.  It can be
+//added to a document with AppendChild, InsertBefore, InsertAfter or
+//ReplaceChild.
+func NewElem(name xml.Name, attrs ...xml.Attr) *XMLEle {
+	ret := &XMLEle{
+		StartElement: xml.StartElement{Name: name},
+		NSBuilder:    tree.NSBuilder{NS: make(map[xml.Name]string)},
+		NodeType:     tree.NtElem,
+	}
+
+	for i := range attrs {
+		ret.SetAttr(attrs[i].Name, attrs[i].Value)
+	}
+
+	return ret
+}
+
+//AppendChild adds n to the end of x's children.
+func (x *XMLEle) AppendChild(n tree.Node) error {
+	return x.insert(len(x.Children), n)
+}
+
+//InsertBefore adds n to x's children before the child, ref.
+func (x *XMLEle) InsertBefore(ref, n tree.Node) error {
+	i, err := x.childIndex(ref)
+	if err != nil {
+		return err
+	}
+
+	return x.insert(i, n)
+}
+
+//InsertAfter adds n to x's children after the child, ref.
+func (x *XMLEle) InsertAfter(ref, n tree.Node) error {
+	i, err := x.childIndex(ref)
+	if err != nil {
+		return err
+	}
+
+	return x.insert(i+1, n)
+}
+
+//RemoveChild removes the child, ref, from x.
+func (x *XMLEle) RemoveChild(ref tree.Node) error {
+	return x.RemoveChildren(ref)
+}
+
+//RemoveChildren removes the children, refs, from x.  The children are removed
+//in one pass, so it is faster than removing them one at a time.
+func (x *XMLEle) RemoveChildren(refs ...tree.Node) error {
+	remove := make(map[int]bool, len(refs))
+	for _, ref := range refs {
+		i, err := x.childIndex(ref)
+		if err != nil {
+			return err
+		}
+		remove[i] = true
+	}
+
+	children := x.Children[:0]
+	for i, c := range x.Children {
+		if !remove[i] {
+			children = append(children, c)
+		} else if ele, ok := c.(*XMLEle); ok {
+			ele.Parent = nil
+		}
+	}
+
+	for i := len(children); i < len(x.Children); i++ {
+		x.Children[i] = nil
+	}
+
+	x.Children = children
+	x.renumber()
+
+	return nil
+}
+
+//ReplaceChild replaces the child, ref, with n.
+func (x *XMLEle) ReplaceChild(ref, n tree.Node) error {
+	i, err := x.childIndex(ref)
+	if err != nil {
+		return err
+	}
+
+	if err = x.canInsert(n); err != nil {
+		return err
+	}
+
+	if ele, ok := x.Children[i].(*XMLEle); ok {
+		if ele == n {
+			return nil
+		}
+		ele.Parent = nil
+	}
+
+	x.Children = append(x.Children[:i], x.Children[i+1:]...)
+
+	return x.insert(i, n)
+}
+
+//SetAttr sets the value of the attribute, name, adding it if it does not
+//exist.
+func (x *XMLEle) SetAttr(name xml.Name, value string) {
+	for i := range x.Attrs {
+		if attr, ok := x.Attrs[i].(xmlnode.XMLNode); ok && attr.Token.(*xml.Attr).Name == name {
+			attr.Token.(*xml.Attr).Value = value
+			return
+		}
+	}
+
+	x.Attrs = append(x.Attrs, xmlnode.XMLNode{
+		Token:    &xml.Attr{Name: name, Value: value},
+		NodeType: tree.NtAttr,
+		Parent:   x,
+	})
+	x.renumber()
+}
+
+//RemoveAttr removes the attribute, name.  It returns false if the attribute
+//does not exist.
+func (x *XMLEle) RemoveAttr(name xml.Name) bool {
+	for i := range x.Attrs {
+		if x.Attrs[i].GetToken().(xml.Attr).Name == name {
+			x.Attrs = append(x.Attrs[:i], x.Attrs[i+1:]...)
+			x.renumber()
+			return true
+		}
+	}
+
+	return false
+}
+
+//RenameAttr changes the name of the attribute, name, keeping its value and
+//position.  It returns false if the attribute does not exist, or if an
+//attribute named newName already exists.
+func (x *XMLEle) RenameAttr(name, newName xml.Name) bool {
+	var found *xml.Attr
+
+	for i := range x.Attrs {
+		attr, ok := x.Attrs[i].(xmlnode.XMLNode)
+		if !ok {
+			continue
+		}
+
+		switch attr.Token.(*xml.Attr).Name {
+		case newName:
+			return name == newName
+		case name:
+			found = attr.Token.(*xml.Attr)
+		}
+	}
+
+	if found == nil {
+		return false
+	}
+
+	found.Name = newName
+	return true
+}
+
+//Rename changes the name of the element.  If the namespace of the new name is
+//not in scope, it is declared as the element's default namespace.
+func (x *XMLEle) Rename(name xml.Name) {
+	x.Name = name
+	x.declare()
+	x.renumber()
+}
+
+//childIndex returns the index of ref in x's children.  The children are in
+//document order, unless they were inserted during a Batch, so ref is looked up
+//by its position before searching all of the children.
+func (x *XMLEle) childIndex(ref tree.Node) (int, error) {
+	i := sort.Search(len(x.Children), func(i int) bool {
+		return x.Children[i].Pos() >= ref.Pos()
+	})
+	if i < len(x.Children) && isNode(x.Children[i], ref) {
+		return i, nil
+	}
+
+	for i, c := range x.Children {
+		if isNode(c, ref) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Cannot find the node at position %d in the children of the element", ref.Pos())
+}
+
+//isNode returns true if c is the node, ref.  Elements are compared by
+//identity, and the other nodes by their type, position and value.
+func isNode(c, ref tree.Node) bool {
+	if ele, ok := ref.(*XMLEle); ok {
+		return c == tree.Node(ele)
+	}
+
+	_, ok := c.(*XMLEle)
+	return !ok && c.GetNodeType() == ref.GetNodeType() && c.Pos() == ref.Pos() && c.ResValue() == ref.ResValue()
+}
+
+func (x *XMLEle) canInsert(n tree.Node) error {
+	switch n.GetNodeType() {
+	case tree.NtAttr, tree.NtNs:
+		return fmt.Errorf("Cannot insert an attribute or namespace node as a child.  Use SetAttr instead")
+	case tree.NtRoot:
+		return fmt.Errorf("Cannot insert a root node as a child")
+	case tree.NtElem:
+		if _, ok := n.(*XMLEle); !ok {
+			return fmt.Errorf("Cannot insert an element of type %T", n)
+		}
+		for p := tree.Elem(x); p != nil; p = p.GetParent() {
+			if p == tree.Elem(n.(*XMLEle)) {
+				return fmt.Errorf("Cannot insert an element into itself")
+			}
+			if p.GetNodeType() == tree.NtRoot {
+				break
+			}
+		}
+	default:
+		if _, ok := n.(xmlnode.XMLNode); !ok {
+			return fmt.Errorf("Cannot insert a node of type %T", n)
+		}
+	}
+
+	return nil
+}
+
+//insert adds n at the index i of x's children.  Elements are moved if they
+//are already in a document, and the other nodes are copied.
+func (x *XMLEle) insert(i int, n tree.Node) error {
+	if err := x.canInsert(n); err != nil {
+		return err
+	}
+
+	var child tree.Node
+
+	if ele, ok := n.(*XMLEle); ok {
+		if p, ok := ele.Parent.(*XMLEle); ok && p != nil {
+			if j, err := p.childIndex(ele); err == nil {
+				p.Children = append(p.Children[:j], p.Children[j+1:]...)
+				if p == x && j < i {
+					i--
+				}
+				p.renumber()
+			}
+		}
+		ele.Parent = x
+		ele.declare()
+		child = ele
+	} else {
+		node := n.(xmlnode.XMLNode)
+		node.Parent = x
+		child = node
+	}
+
+	x.Children = append(x.Children, nil)
+	copy(x.Children[i+1:], x.Children[i:])
+	x.Children[i] = child
+	x.renumber()
+
+	return nil
+}
+
+//declare adds the namespace declarations the element needs in its new
+//scope.  The namespace of the element's name is declared as the default
+//namespace if it is not bound to a prefix, and the elements at the top of the
+//document declare the xml namespace.
+func (x *XMLEle) declare() {
+	if x.NS == nil {
+		x.NS = make(map[xml.Name]string)
+	}
+
+	if x.Parent != nil && x.Parent.GetNodeType() == tree.NtRoot {
+		x.NS[xml.Name{Space: "xmlns", Local: "xml"}] = tree.XMLSpace
+	}
+
+	def := xml.Name{Local: "xmlns"}
+	inScope := tree.BuildNS(x)
+
+	if x.Name.Space == "" {
+		for _, i := range inScope {
+			if i.Name == def {
+				x.NS[def] = ""
+			}
+		}
+		return
+	}
+
+	for _, i := range inScope {
+		if i.Value == x.Name.Space {
+			return
+		}
+	}
+
+	x.NS[def] = x.Name.Space
+}
+
+//Batch calls edit, and renumbers the document that contains x once after it
+//returns, instead of after each edit.  The positions are not updated during
+//edit, so the nodes to edit should be selected before it, and edited in
+//reverse document order.
+func (x *XMLEle) Batch(edit func() error) error {
+	root := x.root()
+	if root == nil {
+		return edit()
+	}
+
+	root.batch++
+	defer func() {
+		root.batch--
+		root.renumber()
+	}()
+
+	return edit()
+}
+
+//root returns the root of the document that contains x, or nil if it is not
+//an XMLEle.
+func (x *XMLEle) root() *XMLEle {
+	var root tree.Elem = x
+	for root.GetNodeType() != tree.NtRoot && root.GetParent() != nil {
+		root = root.GetParent()
+	}
+
+	r, _ := root.(*XMLEle)
+	return r
+}
+
+//renumber sets the document order positions of the document that contains x,
+//unless a Batch is in progress.
+func (x *XMLEle) renumber() {
+	if r := x.root(); r != nil && r.batch == 0 {
+		r.Renumber(r.Pos())
+	}
+}
+
+//Renumber sets the document order positions of x and its descendants,
+//starting at pos, and returns the position after the last node.  Documents
+//that are numbered after each other can be combined in the same node-set.
+func (x *XMLEle) Renumber(pos int) int {
+	scope := make(map[xml.Name]string)
+	if p, ok := x.Parent.(*XMLEle); ok && p != nil {
+		for _, i := range tree.BuildNS(p) {
+			scope[i.Name] = i.Value
+		}
+	}
+
+	return x.renumberFrom(pos, scope)
+}
+
+//renumberFrom numbers x and its descendants, starting at pos.  scope holds
+//the namespace declarations that are in scope of x's parent, so the namespace
+//nodes of the elements are counted without walking their ancestors.
+func (x *XMLEle) renumberFrom(pos int, scope map[xml.Name]string) int {
+	x.NodePos = tree.NodePos(pos)
+	pos++
+
+	if x.NodeType != tree.NtRoot {
+		if len(x.NS) > 0 {
+			inner := make(map[xml.Name]string, len(scope)+len(x.NS))
+			for k, v := range scope {
+				inner[k] = v
+			}
+			for k, v := range x.NS {
+				inner[k] = v
+			}
+			scope = inner
+		}
+
+		for k, v := range scope {
+			//An empty default namespace undeclares it, and is not a node
+			if k.Space != "" || k.Local != "xmlns" || v != "" {
+				pos++
+			}
+		}
+	}
+
+	for i := range x.Attrs {
+		if attr, ok := x.Attrs[i].(xmlnode.XMLNode); ok {
+			attr.NodePos = tree.NodePos(pos)
+			attr.Parent = x
+			x.Attrs[i] = attr
+		}
+		pos++
+	}
+
+	for i := range x.Children {
+		switch c := x.Children[i].(type) {
+		case *XMLEle:
+			pos = c.renumberFrom(pos, scope)
+		case xmlnode.XMLNode:
+			c.NodePos = tree.NodePos(pos)
+			x.Children[i] = c
+			pos++
+		}
+	}
+
+	return pos
+}
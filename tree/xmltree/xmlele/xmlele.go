@@ -17,6 +17,16 @@ type XMLEle struct {
 	Parent   tree.Elem
 	tree.NodePos
 	tree.NodeType
+	tree.Location
+	//IDAttrs holds the attributes declared with the ID type in the DTD, keyed
+	//by element name.  It is only set on the root node.
+	IDAttrs map[string]map[string]bool
+	//Prolog holds the XML declaration and document type declarations of the
+	//document.  It is only set on the root node.
+	Prolog []tree.PrologToken
+	//batch is the number of Batch calls that are in progress on the document.
+	//It is only set on the root node.
+	batch int
 }
 
 //Root is the default root node builder for xmltree.ParseXML
@@ -35,12 +45,14 @@ func (x *XMLEle) CreateNode(opts *xmlbuilder.BuilderOpts) xmlbuilder.XMLBuilder
 			Parent:       x,
 			NodePos:      tree.NodePos(opts.NodePos),
 			NodeType:     opts.NodeType,
+			Location:     opts.Loc,
 		}
 		for i := range opts.Attrs {
 			ele.Attrs[i] = xmlnode.XMLNode{
 				Token:    opts.Attrs[i],
 				NodePos:  tree.NodePos(opts.AttrStartPos + i),
 				NodeType: tree.NtAttr,
+				Location: opts.Loc,
 				Parent:   ele,
 			}
 		}
@@ -52,6 +64,7 @@ func (x *XMLEle) CreateNode(opts *xmlbuilder.BuilderOpts) xmlbuilder.XMLBuilder
 		Token:    opts.Tok,
 		NodePos:  tree.NodePos(opts.NodePos),
 		NodeType: opts.NodeType,
+		Location: opts.Loc,
 		Parent:   x,
 	}
 	x.Children = append(x.Children, node)
@@ -11,6 +11,7 @@ type XMLNode struct {
 	xml.Token
 	tree.NodePos
 	tree.NodeType
+	tree.Location
 	Parent tree.Elem
 }
 
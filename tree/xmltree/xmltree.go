@@ -14,9 +14,28 @@ import (
 //ParseOptions is a set of methods and function pointers that alter
 //the way the XML decoder works and the Node types that are created.
 //Options that are not set will default to what is set in internal/defoverride.go
+//
+//The whitespace options are similar to XSLT's xsl:strip-space and
+//xsl:preserve-space.  Character data that only contains whitespace is removed
+//from the elements it is stripped from, unless an element or its ancestor has
+//xml:space="preserve".  PreserveSpaceElems takes precedence over StripSpace
+//and StripSpaceElems.  Element names are matched by their namespace URL and
+//local name, and a Space or Local of "*" matches any namespace or local name.
 type ParseOptions struct {
 	Strict  bool
 	XMLRoot func() xmlbuilder.XMLBuilder
+	//StripSpace strips whitespace-only character data from every element.
+	StripSpace bool
+	//StripSpaceElems strips whitespace-only character data from the elements
+	//with these names.
+	StripSpaceElems []xml.Name
+	//PreserveSpaceElems keeps the whitespace-only character data of the
+	//elements with these names.
+	PreserveSpaceElems []xml.Name
+	//NormalizeNewlines converts \r\n and \r to \n in comments and processing
+	//instructions.  The decoder already does this for character data and
+	//attributes.
+	NormalizeNewlines bool
 }
 
 //DirectiveParser is an optional interface extended from XMLBuilder that handles
@@ -26,6 +45,13 @@ type DirectiveParser interface {
 	Directive(xml.Directive, *xml.Decoder)
 }
 
+//DeclParser is an optional interface extended from XMLBuilder that handles
+//the XML declaration.
+type DeclParser interface {
+	xmlbuilder.XMLBuilder
+	Decl(xml.ProcInst)
+}
+
 //ParseSettings is a function for setting the ParseOptions you want when
 //parsing an XML tree.
 type ParseSettings func(s *ParseOptions)
@@ -58,6 +84,12 @@ func ParseXML(r io.Reader, op ...ParseSettings) (tree.Node, error) {
 	ordrPos := 1
 	xmlTree := ov.XMLRoot()
 
+	opts := xmlbuilder.BuilderOpts{
+		Dec: dec,
+	}
+	ws := &space{opts: &ov}
+
+	opts.Loc = location(dec)
 	t, err := dec.Token()
 
 	if err != nil {
@@ -65,28 +97,39 @@ func ParseXML(r io.Reader, op ...ParseSettings) (tree.Node, error) {
 	}
 
 	if head, ok := t.(xml.ProcInst); ok && head.Target == "xml" {
+		if dp, ok := xmlTree.(DeclParser); ok {
+			dp.Decl(head)
+		}
+		opts.Loc = location(dec)
 		t, err = dec.Token()
 	}
 
-	opts := xmlbuilder.BuilderOpts{
-		Dec: dec,
-	}
-
 	for err == nil {
+		if _, ok := t.(xml.CharData); !ok {
+			xmlTree = ws.flush(&opts, xmlTree, &ordrPos)
+		}
+
 		switch xt := t.(type) {
 		case xml.StartElement:
+			ws.push(xt)
 			setEle(&opts, xmlTree, xt, &ordrPos)
 			xmlTree = xmlTree.CreateNode(&opts)
 		case xml.CharData:
-			setNode(&opts, xmlTree, xt, tree.NtChd, &ordrPos)
-			xmlTree = xmlTree.CreateNode(&opts)
+			ws.add(xt, opts.Loc)
 		case xml.Comment:
+			if ov.NormalizeNewlines {
+				xt = xml.Comment(normalizeNewlines(xt))
+			}
 			setNode(&opts, xmlTree, xt, tree.NtComm, &ordrPos)
 			xmlTree = xmlTree.CreateNode(&opts)
 		case xml.ProcInst:
+			if ov.NormalizeNewlines {
+				xt.Inst = normalizeNewlines(xt.Inst)
+			}
 			setNode(&opts, xmlTree, xt, tree.NtPi, &ordrPos)
 			xmlTree = xmlTree.CreateNode(&opts)
 		case xml.EndElement:
+			ws.pop()
 			xmlTree = xmlTree.EndElem()
 		case xml.Directive:
 			if dp, ok := xmlTree.(DirectiveParser); ok {
@@ -94,16 +137,24 @@ func ParseXML(r io.Reader, op ...ParseSettings) (tree.Node, error) {
 			}
 		}
 
+		opts.Loc = location(dec)
 		t, err = dec.Token()
 	}
 
 	if err == io.EOF {
+		xmlTree = ws.flush(&opts, xmlTree, &ordrPos)
 		err = nil
 	}
 
 	return xmlTree, err
 }
 
+//location returns the position of the next token of the decoder.
+func location(dec *xml.Decoder) tree.Location {
+	line, col := dec.InputPos()
+	return tree.Location{Line: line, Col: col, Offset: int(dec.InputOffset())}
+}
+
 func setEle(opts *xmlbuilder.BuilderOpts, xmlTree xmlbuilder.XMLBuilder, ele xml.StartElement, ordrPos *int) {
 	opts.NodePos = *ordrPos
 	opts.Tok = ele
@@ -146,8 +197,13 @@ func setEle(opts *xmlbuilder.BuilderOpts, xmlTree xmlbuilder.XMLBuilder, ele xml
 		}
 	}
 
-	opts.AttrStartPos = len(opts.NS) + len(opts.Attrs) + *ordrPos
-	*ordrPos = opts.AttrStartPos + 1
+	nsLen := len(opts.NS)
+	if v, ok := opts.NS[xml.Name{Local: "xmlns"}]; ok && v == "" {
+		nsLen--
+	}
+
+	opts.AttrStartPos = nsLen + *ordrPos + 1
+	*ordrPos = opts.AttrStartPos + len(opts.Attrs)
 }
 
 func setNode(opts *xmlbuilder.BuilderOpts, xmlTree xmlbuilder.XMLBuilder, tok xml.Token, nt tree.NodeType, ordrPos *int) {
@@ -111,3 +111,92 @@ func (n NodeSet) Bool() Bool {
 func (n NodeSet) Num() Num {
 	return String(n.String()).Num()
 }
+
+//Sequence is an XPath 2.0 sequence.  Its items are atomic values, such as
+//Bool's, Num's and String's, or nodes.  Nodes are held as single-node NodeSet's.
+type Sequence []Result
+
+//Items flattens the result into a list of sequence items.  Nested sequences
+//are flattened and node-sets are split into single-node NodeSet's.
+func Items(r Result) []Result {
+	switch t := r.(type) {
+	case NodeSet:
+		ret := make([]Result, len(t))
+		for i := range t {
+			ret[i] = NodeSet{t[i]}
+		}
+		return ret
+	case Sequence:
+		ret := make([]Result, 0, len(t))
+		for _, i := range t {
+			ret = append(ret, Items(i)...)
+		}
+		return ret
+	}
+
+	return []Result{r}
+}
+
+//IsNode returns true if the sequence item is a node.
+func IsNode(item Result) bool {
+	n, ok := item.(NodeSet)
+	return ok && len(n) == 1
+}
+
+//NodeSet returns the sequence as a node-set.  false is returned if the
+//sequence contains an item that is not a node.
+func (s Sequence) NodeSet() (NodeSet, bool) {
+	ret := make(NodeSet, 0, len(s))
+
+	for _, i := range Items(s) {
+		if !IsNode(i) {
+			return nil, false
+		}
+		ret = append(ret, i.(NodeSet)[0])
+	}
+
+	return ret, true
+}
+
+//String satisfies the Res interface for Sequence.  Like node-sets, it returns
+//the string value of the first item.
+func (s Sequence) String() string {
+	items := Items(s)
+	if len(items) == 0 {
+		return ""
+	}
+
+	return items[0].String()
+}
+
+//Bool satisfies the HasBool interface for Sequence's.  It returns the
+//effective boolean value of the sequence.
+func (s Sequence) Bool() Bool {
+	items := Items(s)
+	if len(items) == 0 {
+		return false
+	}
+
+	if len(items) == 1 {
+		if b, ok := items[0].(IsBool); ok {
+			return b.Bool()
+		}
+	}
+
+	return true
+}
+
+//Num satisfies the HasNum interface for Sequence's.  It returns the number
+//value of the first item.
+func (s Sequence) Num() Num {
+	items := Items(s)
+	if len(items) == 0 {
+		return Num(math.NaN())
+	}
+
+	if n, ok := items[0].(IsNum); ok {
+		return n.Num()
+	}
+
+	return Num(math.NaN())
+}
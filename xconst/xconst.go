@@ -64,3 +64,12 @@ var NodeTypes = []string{
 	NodeTypeProcInst,
 	NodeTypeNode,
 }
+
+//NSXS is the XML Schema namespace
+const NSXS = "http://www.w3.org/2001/XMLSchema"
+
+//PredeclaredNS contains the namespace prefixes that can be used in names
+//without being declared in the namespace mappings
+var PredeclaredNS = map[string]string{
+	"xs": NSXS,
+}